@@ -10,6 +10,7 @@ require (
 	github.com/biogo/store v0.0.0-20201120204734-aad293a2328f
 	github.com/blevesearch/bleve v1.0.14
 	github.com/dustin/go-wikiparse v0.0.0-20211018054215-c01ec186f20c
+	github.com/golang/snappy v0.0.3
 	github.com/gomodule/redigo v1.8.5
 	github.com/google/pprof v0.0.0-20211122183932-1daafda22083
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
@@ -21,6 +22,8 @@ require (
 	golang.org/x/sync v0.7.0
 	golang.org/x/sys v0.22.0
 	google.golang.org/api v0.60.0
+	google.golang.org/grpc v1.41.0
+	google.golang.org/protobuf v1.31.0
 )
 
 require (
@@ -48,7 +51,6 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
-	github.com/golang/snappy v0.0.3 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/googleapis/gax-go/v2 v2.1.1 // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
@@ -72,6 +74,4 @@ require (
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20211021150943-2b146023228c // indirect
-	google.golang.org/grpc v1.41.0 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -0,0 +1,107 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+
+	"github.com/biogo/biogo/align"
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/io/seqio"
+	"github.com/biogo/biogo/io/seqio/fasta"
+	"github.com/biogo/biogo/seq/linear"
+)
+
+var short bool
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.BoolVar(&short, "short", false, "whether to align only the first pair of sequences")
+}
+
+// matrix is a simple match/mismatch scoring matrix over alphabet.DNAgapped.
+var matrix = align.Linear{
+	{0, -1, -1, -1, -1},
+	{-1, 2, -1, -1, -1},
+	{-1, -1, 2, -1, -1},
+	{-1, -1, -1, 2, -1},
+	{-1, -1, -1, -1, 2},
+}
+
+var aligners = []struct {
+	name string
+	a    align.Aligner
+}{
+	{"SW", align.SW(matrix)},
+	{"NW", align.NW(matrix)},
+	{"SWAffine", align.SWAffine{Matrix: matrix, GapOpen: -5}},
+	{"NWAffine", align.NWAffine{Matrix: matrix, GapOpen: -5}},
+}
+
+func readSeqs(path string) ([]*linear.Seq, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seqs []*linear.Seq
+	sc := seqio.NewScanner(fasta.NewReader(f, linear.NewSeq("", nil, alphabet.DNAgapped)))
+	for sc.Next() {
+		seqs = append(seqs, sc.Seq().(*linear.Seq))
+	}
+	if err := sc.Error(); err != nil {
+		return nil, err
+	}
+	if len(seqs) < 2 {
+		return nil, fmt.Errorf("%s: need at least 2 sequences, found %d", path, len(seqs))
+	}
+	return seqs, nil
+}
+
+func run(path string) error {
+	seqs, err := readSeqs(path)
+	if err != nil {
+		return err
+	}
+	if short {
+		seqs = seqs[:2]
+	}
+	// Each alignment allocates a dynamic programming table proportional
+	// to the product of the sequence lengths, so aligning every pair
+	// of sequences produces a steady stream of large allocations.
+	for _, al := range aligners {
+		err := driver.RunBenchmark("BiogoAlignment"+al.name, func(_ *driver.B) error {
+			for i := range seqs {
+				for j := i + 1; j < len(seqs); j++ {
+					if _, err := al.a.Align(seqs[i], seqs[j]); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}, driver.InProcessMeasurementOptions...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "expected FASTA file as input")
+		os.Exit(1)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
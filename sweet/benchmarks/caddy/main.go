@@ -0,0 +1,442 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm && !plan9
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/server"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
+)
+
+const (
+	serverHost = "127.0.0.1:8080"
+	adminHost  = "127.0.0.1:2019"
+
+	// serverName is the name in the server's certificate when running
+	// with -secure.
+	serverName = "localhost"
+
+	// clients is the number of concurrent connections driving load.
+	clients = 64
+)
+
+// fixtures are the names and sizes of the static files the server
+// serves. Clients request them round-robin.
+var fixtures = []struct {
+	name string
+	size int
+}{
+	{"small.html", 1 << 10},
+	{"medium.html", 16 << 10},
+	{"large.html", 256 << 10},
+}
+
+type config struct {
+	caddyBin   string
+	tmpDir     string
+	short      bool
+	secure     bool
+	procs      int
+	gomaxprocs int
+}
+
+var cliCfg config
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.StringVar(&cliCfg.caddyBin, "caddy-bin", "", "path to caddy binary")
+	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
+	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+	flag.BoolVar(&cliCfg.secure, "secure", false, "whether to serve over TLS")
+
+	// The load generator is as busy as the server, so split GOMAXPROCS
+	// evenly between the two.
+	procs := runtime.GOMAXPROCS(-1)
+	half := procs / 2
+	if half == 0 {
+		half = 1
+	}
+	runtime.GOMAXPROCS(half)
+	cliCfg.procs = half
+	cliCfg.gomaxprocs = procs
+}
+
+// writeFixtures creates the static site served by caddy in dir.
+func writeFixtures(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	line := []byte("<p>The quick brown fox jumps over the lazy dog.</p>\n")
+	for _, f := range fixtures {
+		data := bytes.Repeat(line, f.size/len(line)+1)[:f.size]
+		if err := os.WriteFile(filepath.Join(dir, f.name), data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCert generates a self-signed certificate for serverName and
+// writes it and its key to dir, returning their paths.
+func writeCert(dir string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: serverName},
+		DNSNames:              []string{serverName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// writeConfig writes a caddy JSON config serving siteDir to dir and
+// returns its path. If certFile is non-empty, the server terminates TLS
+// with the given certificate.
+func writeConfig(dir, siteDir, certFile, keyFile string) (string, error) {
+	srv := map[string]any{
+		"listen": []string{serverHost},
+		"routes": []any{
+			map[string]any{
+				"handle": []any{
+					map[string]any{"handler": "file_server", "root": siteDir},
+				},
+			},
+		},
+		// Never try to obtain certificates from a real CA.
+		"automatic_https": map[string]any{"disable": true},
+	}
+	apps := map[string]any{
+		"http": map[string]any{
+			"servers": map[string]any{"sweet": srv},
+		},
+	}
+	if certFile != "" {
+		srv["tls_connection_policies"] = []any{map[string]any{}}
+		apps["tls"] = map[string]any{
+			"certificates": map[string]any{
+				"load_files": []any{
+					map[string]any{"certificate": certFile, "key": keyFile},
+				},
+			},
+		}
+	}
+	b, err := json.Marshal(map[string]any{
+		"admin":   map[string]any{"listen": adminHost},
+		"logging": map[string]any{"logs": map[string]any{"default": map[string]any{"level": "ERROR"}}},
+		"apps":    apps,
+	})
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "caddy.json")
+	return path, os.WriteFile(path, b, 0o644)
+}
+
+type caddyInstance struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+}
+
+func launchCaddy(cfg *config, client *http.Client, baseURL string) (*caddyInstance, error) {
+	siteDir := filepath.Join(cfg.tmpDir, "site")
+	if err := writeFixtures(siteDir); err != nil {
+		return nil, err
+	}
+	var certFile, keyFile string
+	if cfg.secure {
+		var err error
+		certFile, keyFile, err = writeCert(cfg.tmpDir)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		certPEM, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		pool.AppendCertsFromPEM(certPEM)
+		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			ServerName: serverName,
+		}
+	}
+	configFile, err := writeConfig(cfg.tmpDir, siteDir, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &caddyInstance{}
+	inst.cmd = exec.Command(cfg.caddyBin, "run", "--config", configFile)
+	// Keep caddy from writing state into the user's home directory.
+	inst.cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GOMAXPROCS=%d", cfg.procs),
+		"XDG_DATA_HOME="+filepath.Join(cfg.tmpDir, "data"),
+		"XDG_CONFIG_HOME="+filepath.Join(cfg.tmpDir, "config"),
+	)
+	inst.cmd.Stdout = &inst.output
+	inst.cmd.Stderr = &inst.output
+	if err := inst.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start caddy: %v", err)
+	}
+	// Make sure the server is actually serving before continuing.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := get(client, baseURL+fixtures[0].name)
+		if err == nil {
+			return inst, nil
+		}
+		if time.Now().After(deadline) {
+			inst.shutdown()
+			return nil, fmt.Errorf("timed out waiting for caddy: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (i *caddyInstance) shutdown() error {
+	if err := i.cmd.Process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	if _, err := i.cmd.Process.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func get(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// gcStats returns the cumulative GC pause time and number of GCs reported
+// by caddy's metrics endpoint.
+func gcStats() (pause time.Duration, count uint64, err error) {
+	resp, err := http.Get("http://" + adminHost + "/metrics")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		name, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "go_gc_duration_seconds_sum":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			pause = time.Duration(v * 1e9)
+		case "go_gc_duration_seconds_count":
+			count, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	return pause, count, sc.Err()
+}
+
+func runBenchmark(b *driver.B, cfg *config, client *http.Client, baseURL string) error {
+	duration := 30 * time.Second
+	if cfg.short {
+		duration = 3 * time.Second
+	}
+	pause0, count0, err := gcStats()
+	if err != nil {
+		return err
+	}
+
+	latencies := make([][]time.Duration, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	stop := time.Now().Add(duration)
+	for i := 0; i < clients; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := i; time.Now().Before(stop); j++ {
+				start := time.Now()
+				if err := get(client, baseURL+fixtures[j%len(fixtures)].name); err != nil {
+					errs[i] = err
+					return
+				}
+				latencies[i] = append(latencies[i], time.Since(start))
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	pause1, count1, err := gcStats()
+	if err != nil {
+		return err
+	}
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return errors.New("no requests completed")
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	quantile := func(q float64) uint64 {
+		return uint64(all[int(q*float64(len(all)-1))])
+	}
+	b.Report("p50-latency-ns", quantile(0.50))
+	b.Report("p90-latency-ns", quantile(0.90))
+	b.Report("p99-latency-ns", quantile(0.99))
+	b.Report("gc-pause-ns", uint64(pause1-pause0))
+	b.Report("gcs", count1-count0)
+
+	b.Ops(len(all))
+	b.Report("requests/s", uint64(float64(len(all))/b.Elapsed().Seconds()))
+	return nil
+}
+
+func run(cfg *config) (err error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: clients,
+		},
+	}
+	scheme := "http"
+	name := "CaddyStaticFile"
+	if cfg.secure {
+		scheme = "https"
+		name += "/secure"
+	}
+	baseURL := scheme + "://" + serverHost + "/"
+
+	inst, err := launchCaddy(cfg, client, baseURL)
+	if err != nil {
+		return fmt.Errorf("starting server: %v", err)
+	}
+	defer func() {
+		if r := inst.shutdown(); r != nil {
+			if err == nil {
+				err = r
+			} else {
+				fmt.Fprintf(os.Stderr, "failed to shutdown caddy: %v", r)
+			}
+		}
+		if err != nil && inst.output.Len() != 0 {
+			fmt.Fprintln(os.Stderr, "=== caddy stdout+stderr ===")
+			fmt.Fprintln(os.Stderr, inst.output.String())
+		}
+	}()
+
+	opts := []driver.RunOption{
+		driver.DoTime(true),
+		driver.DoPeakRSS(true),
+		driver.DoPeakVM(true),
+		driver.DoDefaultAvgRSS(),
+		driver.DoCoreDump(true),
+		driver.BenchmarkPID(inst.cmd.Process.Pid),
+		driver.DoPerf(true),
+		driver.WithGOMAXPROCS(cfg.gomaxprocs),
+	}
+	return driver.RunBenchmark(name, func(d *driver.B) error {
+		if driver.DiagnosticEnabled(diagnostics.CPUProfile) {
+			stop := server.PollDiagnostic(adminHost, cfg.tmpDir, name, diagnostics.CPUProfile)
+			defer stop()
+		}
+		if driver.DiagnosticEnabled(diagnostics.Trace) {
+			stop := server.PollDiagnostic(adminHost, cfg.tmpDir, name, diagnostics.Trace)
+			defer func() {
+				d.Report("trace-bytes", stop())
+			}()
+		}
+		if driver.DiagnosticEnabled(diagnostics.MemProfile) {
+			defer func() {
+				if _, err := server.CollectDiagnostic(adminHost, cfg.tmpDir, name, diagnostics.MemProfile); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to read memprofile: %v", err)
+				}
+			}()
+		}
+		return runBenchmark(d, cfg, client, baseURL)
+	}, opts...)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
+		os.Exit(1)
+	}
+	if err := run(&cliCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+)
+
+// gcTraceRe matches a line of GODEBUG=gctrace=1 output, capturing when
+// the cycle started, in seconds since the process did, the wall-clock and
+// CPU time of each of its phases, in milliseconds, the live heap it left
+// behind, in MB, and GOMAXPROCS, e.g.
+//
+//	gc 7 @2.104s 3%: 0.020+12+0.041 ms clock, 0.16+1.2/23/44+0.33 ms cpu, 52->53->27 MB, 54 MB goal, 0 MB stacks, 0 MB globals, 8 P
+var gcTraceRe = regexp.MustCompile(`^gc \d+ @([0-9.]+)s \d+%: ([0-9.+]+) ms clock, ([0-9.+/]+) ms cpu, \d+->\d+->(\d+) MB, .* (\d+) P`)
+
+// userGCTrace is whether the servers' GODEBUG, from our own environment,
+// already asks for GC traces, in which case the user wants to see them.
+var userGCTrace = strings.Contains(os.Getenv("GODEBUG"), "gctrace=1")
+
+// gcCycle is a GC cycle of a server, from its trace.
+type gcCycle struct {
+	at       time.Time
+	cpu      time.Duration
+	pause    time.Duration // stop-the-world, at the start and end of the cycle
+	heapLive uint64        // bytes, once the cycle was done
+	procs    int
+}
+
+// gcTracer is an io.Writer for the output of a server run with
+// GODEBUG=gctrace=1, which records the GC cycles in it and passes the
+// rest on to out. The trace itself is passed on too only if userGCTrace.
+type gcTracer struct {
+	out   io.Writer
+	start time.Time // when the server started
+
+	mu     sync.Mutex
+	buf    []byte
+	cycles []gcCycle
+}
+
+// newGCTracer returns a gcTracer for the output of a server that's about
+// to start, passing it on to out.
+func newGCTracer(out io.Writer) *gcTracer {
+	return &gcTracer{out: out, start: time.Now()}
+}
+
+func (t *gcTracer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, b...)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := t.buf[:i+1]
+		t.buf = t.buf[i+1:]
+		if t.parse(string(line)) && !userGCTrace {
+			continue
+		}
+		if _, err := t.out.Write(line); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// parse records the GC cycle line describes, if it's a line of a GC
+// trace, reporting whether it is.
+func (t *gcTracer) parse(line string) bool {
+	m := gcTraceRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	at, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return false
+	}
+	heapLive, err := strconv.ParseUint(m[4], 10, 64)
+	if err != nil {
+		return false
+	}
+	procs, err := strconv.Atoi(m[5])
+	if err != nil {
+		return false
+	}
+	clock, ok := parseGCTraceMillis(m[2])
+	if !ok || len(clock) != 3 {
+		return false
+	}
+	cpus, ok := parseGCTraceMillis(m[3])
+	if !ok {
+		return false
+	}
+	var cpu float64
+	for _, ms := range cpus {
+		cpu += ms
+	}
+	t.cycles = append(t.cycles, gcCycle{
+		at:       t.start.Add(time.Duration(at * float64(time.Second))),
+		cpu:      time.Duration(cpu * float64(time.Millisecond)),
+		pause:    time.Duration((clock[0] + clock[2]) * float64(time.Millisecond)),
+		heapLive: heapLive << 20,
+		procs:    procs,
+	})
+	return true
+}
+
+// parseGCTraceMillis parses the times of the phases of a GC cycle, as a
+// GC trace lists them, e.g. 0.16+1.2/23/44+0.33, in milliseconds.
+func parseGCTraceMillis(s string) ([]float64, bool) {
+	var ms []float64
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == '/' }) {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, false
+		}
+		ms = append(ms, v)
+	}
+	return ms, true
+}
+
+// between returns the GC cycles of the server that started between start
+// and end.
+func (t *gcTracer) between(start, end time.Time) []gcCycle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cycles []gcCycle
+	for _, c := range t.cycles {
+		if !c.at.Before(start) && !c.at.After(end) {
+			cycles = append(cycles, c)
+		}
+	}
+	return cycles
+}
+
+// window returns the CPU time GC cycles that started between start and
+// end used, and the CPU time available to the server over that time,
+// judging by its GOMAXPROCS, or zero if no cycle started then.
+func (t *gcTracer) window(start, end time.Time) (gc, total time.Duration) {
+	procs := 0
+	for _, c := range t.between(start, end) {
+		gc += c.cpu
+		procs = c.procs
+	}
+	return gc, end.Sub(start) * time.Duration(procs)
+}
+
+// gcCPUSampler measures the fraction of the servers' CPU time GC used
+// while the workload runs, from their GC traces.
+type gcCPUSampler struct {
+	instances []*cockroachdbInstance
+	start     time.Time
+}
+
+// startGCCPUSampler starts measuring the GC CPU time of instances.
+func startGCCPUSampler(instances []*cockroachdbInstance) *gcCPUSampler {
+	return &gcCPUSampler{instances: instances, start: time.Now()}
+}
+
+// finish reports the fraction of CPU time GC used across the servers
+// since the sampler started, as driver.StatGCCPUFraction, if any of them
+// ran a GC cycle meanwhile.
+func (s *gcCPUSampler) finish(b *driver.B) {
+	end := time.Now()
+	var gc, total time.Duration
+	for _, inst := range s.instances {
+		g, t := inst.gcTrace.window(s.start, end)
+		gc += g
+		total += t
+	}
+	if total > 0 {
+		b.ReportFloat(driver.StatGCCPUFraction, gc.Seconds()/total.Seconds())
+	}
+}
@@ -12,9 +12,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -34,6 +39,8 @@ const (
 	basePort = 26257
 	// The percentage of memory to allocate to the pebble cache.
 	cacheSize = "0.25"
+	// The percentage of memory each node's store may use with -store mem.
+	memStoreSize = "0.25"
 )
 
 type config struct {
@@ -41,9 +48,24 @@ type config struct {
 	cockroachdbBin string
 	tmpDir         string
 	benchName      string
+	nameSuffix     string
+	serverCPUs     string
+	heapDiffDir    string
+	contentionDir  string
+	mutexFraction  int
+	blockRate      int
+	concurrency    int
+	secure         bool
+	store          string
+	gcTrace        bool
 	isProfiling    bool
 	short          bool
 	procsPerInst   int
+	serverProcs    int
+	readyTimeout   time.Duration
+	duration       time.Duration
+	sampleInterval time.Duration
+	seed           int64
 	bench          *benchmark
 }
 
@@ -55,7 +77,168 @@ func init() {
 	flag.StringVar(&cliCfg.cockroachdbBin, "cockroachdb-bin", "", "path to cockroachdb binary")
 	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
 	flag.StringVar(&cliCfg.benchName, "bench", "", "name of the benchmark to run")
+	flag.StringVar(&cliCfg.nameSuffix, "name-suffix", "", "suffix to append to the names of reported benchmarks, e.g. /memlimit=2GiB")
+	flag.StringVar(&cliCfg.serverCPUs, "server-cpus", "", "CPU list, in the format accepted by taskset -c, to pin cockroachdb servers to")
+	flag.StringVar(&cliCfg.heapDiffDir, "heap-diff-dir", "", "directory to write heap profiles of each cockroachdb server to, taken at the start and end of the benchmark")
+	flag.StringVar(&cliCfg.contentionDir, "contention-profile-dir", "", "directory to write mutex and block profiles of each cockroachdb server to, taken at the start and end of the benchmark")
+	flag.IntVar(&cliCfg.mutexFraction, "mutex-profile-fraction", 0, "the fraction of mutex contention events cockroachdb servers sample, as runtime.SetMutexProfileFraction takes it, or 0 for cockroachdb's default")
+	flag.IntVar(&cliCfg.blockRate, "block-profile-rate", 0, "the rate at which cockroachdb servers sample blocking events, as runtime.SetBlockProfileRate takes it, or 0 for cockroachdb's default")
+	flag.IntVar(&cliCfg.concurrency, "concurrency", 0, "number of concurrent workers the load generator runs, or 0 for the default of 10000 whatever the number of nodes; if set, reported benchmark names are tagged with it, e.g. /conc=64")
+	flag.BoolVar(&cliCfg.secure, "secure", false, "whether to run a secure cluster, with certificates generated in the temporary directory, and have the load generator connect to it over TLS; if set, reported benchmark names are tagged with /secure")
+	flag.BoolVar(&cliCfg.gcTrace, "gc-trace", false, "whether to run cockroachdb servers with GODEBUG=gctrace=1, to report the fraction of their CPU time GC used as gc-cpu-fraction; the trace perturbs the other results slightly, so if set, reported benchmark names are tagged with /gctrace")
+	flag.StringVar(&cliCfg.store, "store", "disk", "where cockroachdb servers keep their data: disk, in the temporary directory, or mem, in memory, to keep disk I/O out of the results; if mem, reported benchmark names are tagged with /store=mem")
+	flag.DurationVar(&cliCfg.readyTimeout, "ready-timeout", time.Minute, "how long to wait for the cluster, and then the workload's schema, to become ready before giving up")
 	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+	flag.DurationVar(&cliCfg.duration, "duration", 0, "how long to run the workload for, overriding the benchmark's duration (and -short's), with a ramp up of a quarter of it beforehand")
+	flag.DurationVar(&cliCfg.sampleInterval, "sample-interval", 0, "how often to sample the RSS, live heap and GC pauses of the servers while the workload runs, reporting each sample as a benchmark result of its own tagged with when it was taken, e.g. /t=10m0s, to show trends over long runs; implies -gc-trace, where the GC data comes from; 0 means only the totals over the run are reported")
+	flag.Int64Var(&cliCfg.seed, "seed", 0, "seed for the workload's random keys and values, or 0 to pick one at random and log it")
+}
+
+// errInterrupted is returned by runBenchmark when the benchmark is
+// stopped early by SIGINT or SIGTERM, e.g. from sweet on timeout, after
+// reporting results for the part of the workload that did run.
+var errInterrupted = errors.New("interrupted")
+
+// readyPollInterval is how often nodes are polled while waiting for them
+// to become ready.
+const readyPollInterval = 250 * time.Millisecond
+
+// serverCommand returns a command that runs a cockroachdb server with the
+// given arguments, pinned to cfg.serverCPUs if set.
+func serverCommand(cfg *config, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if cfg.serverCPUs == "" {
+		cmd = exec.Command(cfg.cockroachdbBin, args...)
+	} else {
+		cmd = exec.Command("taskset", append([]string{"-c", cfg.serverCPUs, cfg.cockroachdbBin}, args...)...)
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GOMAXPROCS=%d", cfg.serverProcs))
+	if cfg.gcTrace {
+		cmd.Env = append(cmd.Env, serverGODEBUG())
+	}
+	// cockroachdb sets its own contention profiling rates at startup,
+	// which only these variables override.
+	if cfg.mutexFraction != 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("COCKROACH_MUTEX_PROFILE_RATE=%d", cfg.mutexFraction))
+	}
+	if cfg.blockRate != 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("COCKROACH_BLOCK_PROFILE_RATE=%d", cfg.blockRate))
+	}
+	return cmd
+}
+
+// serverGODEBUG returns the GODEBUG setting for cockroachdb servers with
+// -gc-trace: ours, with GC traces turned on, for measuring how much CPU
+// time GC uses; see gcTracer.
+func serverGODEBUG() string {
+	godebug := os.Getenv("GODEBUG")
+	if godebug != "" {
+		godebug += ","
+	}
+	return "GODEBUG=" + godebug + "gctrace=1"
+}
+
+// certsDir returns the directory cockroach's certificates are generated
+// in for a secure cluster.
+func certsDir(cfg *config) string {
+	return filepath.Join(cfg.tmpDir, "certs")
+}
+
+// securityFlags returns the flags cockroach commands that talk to the
+// cluster need, whether it's secure or not.
+func securityFlags(cfg *config) []string {
+	if !cfg.secure {
+		return []string{"--insecure"}
+	}
+	return []string{"--certs-dir", certsDir(cfg)}
+}
+
+// serverSecurityFlags returns the flags cockroach servers need, whether
+// the cluster is secure or not. A secure cluster still serves HTTP in
+// plaintext on localhost, so that diagnostics can be collected over the
+// same http:// URLs, but it does require a session; see authenticateHTTP.
+// cockroach only allows this if -host is a name for localhost.
+func serverSecurityFlags(cfg *config) []string {
+	if !cfg.secure {
+		return securityFlags(cfg)
+	}
+	return append(securityFlags(cfg), "--unencrypted-localhost-http")
+}
+
+// createCerts generates a CA, a certificate for the nodes, which all
+// run on this host, and a certificate for the root user, which both the
+// load generator and the commands that set up the cluster connect as.
+func createCerts(cfg *config) error {
+	dir := certsDir(cfg)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	caKey := filepath.Join(dir, "ca.key")
+	for _, args := range [][]string{
+		{"cert", "create-ca", "--certs-dir", dir, "--ca-key", caKey},
+		{"cert", "create-node", "localhost", "127.0.0.1", cfg.host, "--certs-dir", dir, "--ca-key", caKey},
+		{"cert", "create-client", "root", "--certs-dir", dir, "--ca-key", caKey},
+	} {
+		cmd := exec.Command(cfg.cockroachdbBin, args...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v: %s", cmd, err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// sessionTransport is an http.RoundTripper that adds a session cookie
+// to each request, to authenticate with a secure cluster.
+type sessionTransport struct {
+	cookie string
+	base   http.RoundTripper
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Add("Cookie", t.cookie)
+	return t.base.RoundTrip(req)
+}
+
+// authenticateHTTP logs in to the secure cluster inst is part of as
+// root, and has every HTTP request made from here on carry the session,
+// since a secure cluster only serves diagnostics to an admin. All HTTP
+// requests to the cluster go through http.DefaultClient, including those
+// of the server package.
+func authenticateHTTP(cfg *config, inst *cockroachdbInstance) error {
+	args := append([]string{"auth-session", "login", "root", "--only-cookie"}, securityFlags(cfg)...)
+	args = append(args,
+		fmt.Sprintf("--host=%s", cfg.host),
+		fmt.Sprintf("--port=%d", inst.sqlPort),
+	)
+	cmd := exec.Command(cfg.cockroachdbBin, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("logging in to the cluster: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	// The cookie is printed as a Set-Cookie header would have it, e.g.
+	// "session=...; Path=/; HttpOnly", of which only the first attribute
+	// goes in the Cookie header.
+	cookie, _, _ := strings.Cut(strings.TrimSpace(string(out)), ";")
+	if cookie == "" {
+		return fmt.Errorf("logging in to the cluster: no session cookie in output")
+	}
+	http.DefaultClient.Transport = &sessionTransport{cookie: cookie, base: http.DefaultTransport}
+	return nil
+}
+
+// storeFlags returns the flags that tell the server of inst where to
+// keep its data. An in-memory store has no directory for cockroach to
+// write its logs to, so they're written where the store would have been
+// instead, for preserving them if the benchmark fails.
+func storeFlags(cfg *config, inst *cockroachdbInstance) []string {
+	dir := filepath.Join(cfg.tmpDir, inst.name)
+	if cfg.store == "mem" {
+		return []string{"--store", "type=mem,size=" + memStoreSize, "--log-dir", filepath.Join(dir, "logs")}
+	}
+	return []string{"--store", dir}
 }
 
 type cockroachdbInstance struct {
@@ -64,6 +247,7 @@ type cockroachdbInstance struct {
 	httpPort int // Used to scrape for metrics.
 	cmd      *exec.Cmd
 	output   bytes.Buffer
+	gcTrace  *gcTracer // the GC cycles in the server's output
 }
 
 func clusterAddresses(instances []*cockroachdbInstance) string {
@@ -85,20 +269,17 @@ func launchSingleNodeCluster(cfg *config) ([]*cockroachdbInstance, error) {
 
 	// `cockroach start-single-node` handles both creation of the node
 	// and initialization.
-	inst.cmd = exec.Command(cfg.cockroachdbBin,
-		"start-single-node",
-		"--insecure",
+	args := append([]string{"start-single-node"}, serverSecurityFlags(cfg)...)
+	args = append(args, storeFlags(cfg, inst)...)
+	inst.cmd = serverCommand(cfg, append(args,
 		"--listen-addr", inst.sqlAddr(),
 		"--http-addr", inst.httpAddr(),
 		"--cache", cacheSize,
-		"--store", fmt.Sprintf("%s/%s", cfg.tmpDir, inst.name),
 		"--logtostderr",
-	)
-	inst.cmd.Env = append(os.Environ(),
-		fmt.Sprintf("GOMAXPROCS=%d", cfg.procsPerInst),
-	)
-	inst.cmd.Stdout = &inst.output
-	inst.cmd.Stderr = &inst.output
+	)...)
+	inst.gcTrace = newGCTracer(&inst.output)
+	inst.cmd.Stdout = inst.gcTrace
+	inst.cmd.Stderr = inst.gcTrace
 	if err := inst.cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start instance %q: %v", inst.name, err)
 	}
@@ -124,21 +305,18 @@ func launchCockroachCluster(cfg *config) ([]*cockroachdbInstance, error) {
 		allOtherInstances := append(instances[:n:n], instances[n+1:]...)
 		join := fmt.Sprintf("--join=%s", clusterAddresses(allOtherInstances))
 
-		inst.cmd = exec.Command(cfg.cockroachdbBin,
-			"start",
-			"--insecure",
+		args := append([]string{"start"}, serverSecurityFlags(cfg)...)
+		args = append(args, storeFlags(cfg, inst)...)
+		inst.cmd = serverCommand(cfg, append(args,
 			"--listen-addr", inst.sqlAddr(),
 			"--http-addr", inst.httpAddr(),
 			"--cache", cacheSize,
-			"--store", fmt.Sprintf("%s/%s", cfg.tmpDir, inst.name),
 			"--logtostderr",
 			join,
-		)
-		inst.cmd.Env = append(os.Environ(),
-			fmt.Sprintf("GOMAXPROCS=%d", cfg.procsPerInst),
-		)
-		inst.cmd.Stdout = &inst.output
-		inst.cmd.Stderr = &inst.output
+		)...)
+		inst.gcTrace = newGCTracer(&inst.output)
+		inst.cmd.Stdout = inst.gcTrace
+		inst.cmd.Stderr = inst.gcTrace
 		if err := inst.cmd.Start(); err != nil {
 			return nil, fmt.Errorf("failed to start instance %q: %v", inst.name, err)
 		}
@@ -146,14 +324,13 @@ func launchCockroachCluster(cfg *config) ([]*cockroachdbInstance, error) {
 
 	// Initialize the cluster with `cockroach init`.
 	inst1 := instances[0]
-	initCmd := exec.Command(cfg.cockroachdbBin,
-		"init",
-		"--insecure",
+	initArgs := append([]string{"init"}, securityFlags(cfg)...)
+	initCmd := exec.Command(cfg.cockroachdbBin, append(initArgs,
 		fmt.Sprintf("--host=%s", cfg.host),
 		fmt.Sprintf("--port=%d", inst1.sqlPort),
-	)
+	)...)
 	initCmd.Env = append(os.Environ(),
-		fmt.Sprintf("GOMAXPROCS=%d", cfg.procsPerInst),
+		fmt.Sprintf("GOMAXPROCS=%d", cfg.serverProcs),
 	)
 	initCmd.Stdout = &inst1.output
 	initCmd.Stderr = &inst1.output
@@ -163,39 +340,33 @@ func launchCockroachCluster(cfg *config) ([]*cockroachdbInstance, error) {
 	return instances, nil
 }
 
-// waitForCluster pings nodes in the cluster until one responds, or
-// we time out. We only care to wait for one node to respond as the
-// workload will work as long as it can connect to one node initially.
-// The --ramp flag will take care of startup noise.
+// waitForCluster waits until every node in the cluster serves SQL
+// queries, or cfg.readyTimeout elapses. Starting load while some nodes
+// are still coming up would skew the first samples.
 func waitForCluster(instances []*cockroachdbInstance, cfg *config) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	return waitForSQL(instances, cfg, "cluster", "SELECT 1;")
+}
+
+// waitForSQL polls each of instances with the SQL statement stmt until it
+// succeeds on all of them. It gives up once cfg.readyTimeout has elapsed,
+// reporting the last error of the first node that wasn't ready. what
+// describes what's being waited for in that error.
+func waitForSQL(instances []*cockroachdbInstance, cfg *config, what, stmt string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.readyTimeout)
 	defer cancel()
 	for _, inst := range instances {
-		inst := inst
-		go func(ctx context.Context) {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(5 * time.Second):
-					// The node will almost certainly not be ready right away, so wait
-					// 5 seconds first and between pings. 5 seconds was chosen through
-					// trial and error as a time that nodes are *usually* ready by.
-					if err := inst.ping(cfg); err == nil {
-						cancel()
-						return
-					}
-				}
+		for {
+			err := inst.ping(ctx, cfg, stmt)
+			if err == nil {
+				break
 			}
-		}(ctx)
-	}
-
-	select {
-	case <-ctx.Done():
-	case <-time.After(time.Minute):
-		return errors.New("benchmark timed out waiting for cluster to be ready")
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("benchmark timed out after %s waiting for %s to be ready on %s: %v", cfg.readyTimeout, what, inst.name, err)
+			case <-time.After(readyPollInterval):
+			}
+		}
 	}
-
 	return nil
 }
 
@@ -213,13 +384,12 @@ func (i *cockroachdbInstance) setClusterSettings(cfg *config) error {
 
 	// Multi-line cluster setting changes aren't allowed.
 	for _, setting := range settings {
-		cmd := exec.Command(cfg.cockroachdbBin,
-			"sql",
-			"--insecure",
+		args := append([]string{"sql"}, securityFlags(cfg)...)
+		cmd := exec.Command(cfg.cockroachdbBin, append(args,
 			fmt.Sprintf("--host=%s", cfg.host),
 			fmt.Sprintf("--port=%d", i.sqlPort),
 			"--execute", fmt.Sprintf("SET CLUSTER SETTING %s;", setting),
-		)
+		)...)
 		cmd.Stdout = &i.output
 		cmd.Stderr = &i.output
 		if err := cmd.Run(); err != nil {
@@ -230,22 +400,18 @@ func (i *cockroachdbInstance) setClusterSettings(cfg *config) error {
 	return nil
 }
 
-func (i *cockroachdbInstance) ping(cfg *config) error {
-	// Ping the node to see if it is live. The actual command of
-	// `node status` is a bit arbitrary, if it responds at all
-	// we know the node is live. Generally it is used to see if
-	// *other* nodes are live.
-	cmd := exec.Command(cfg.cockroachdbBin,
-		"node",
-		"status",
-		"--insecure",
+// ping runs the SQL statement stmt against the node, returning an error
+// including its output if it fails. Failures are expected while the node
+// starts, so its output is kept out of the node's.
+func (i *cockroachdbInstance) ping(ctx context.Context, cfg *config, stmt string) error {
+	args := append([]string{"sql"}, securityFlags(cfg)...)
+	cmd := exec.CommandContext(ctx, cfg.cockroachdbBin, append(args,
 		fmt.Sprintf("--host=%s", cfg.host),
 		fmt.Sprintf("--port=%d", i.sqlPort),
-	)
-	cmd.Stdout = &i.output
-	cmd.Stderr = &i.output
-	if err := cmd.Run(); err != nil {
-		return err
+		"--execute", stmt,
+	)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
 	}
 	return nil
 }
@@ -321,13 +487,22 @@ type benchmark struct {
 	timeout     time.Duration
 }
 
+// defaultConcurrency is how many concurrent workers the load generator
+// runs unless -concurrency says otherwise. Results with it aren't tagged
+// with their concurrency, so it must stay what it's always been for them
+// to be comparable with earlier ones.
+const defaultConcurrency = 10000
+
 const (
 	readMetric  = "read"
 	writeMetric = "write"
 )
 
 func kvBenchmark(readPercent int, nodeCount int) benchmark {
-	metricTypes := []string{writeMetric}
+	var metricTypes []string
+	if readPercent < 100 {
+		metricTypes = append(metricTypes, writeMetric)
+	}
 	if readPercent > 0 {
 		metricTypes = append(metricTypes, readMetric)
 	}
@@ -336,7 +511,7 @@ func kvBenchmark(readPercent int, nodeCount int) benchmark {
 		name:        fmt.Sprintf("kv%d/nodes=%d", readPercent, nodeCount),
 		reportName:  fmt.Sprintf("CockroachDBkv%d/nodes=%d", readPercent, nodeCount),
 		workload:    "kv",
-		nodeCount:   1,
+		nodeCount:   nodeCount,
 		metricTypes: metricTypes,
 		// Very generous timeout, we don't expect to ever hit this, but just in case.
 		timeout: 5 * time.Minute,
@@ -345,7 +520,6 @@ func kvBenchmark(readPercent int, nodeCount int) benchmark {
 			fmt.Sprintf("--read-percent=%d", readPercent),
 			"--min-block-bytes=1024",
 			"--max-block-bytes=1024",
-			"--concurrency=10000",
 			"--max-rate=30000",
 			//Pre-splitting and scattering the ranges should help stabilize results.
 			"--scatter",
@@ -364,20 +538,49 @@ func kvBenchmark(readPercent int, nodeCount int) benchmark {
 	}
 }
 
-var benchmarks = []benchmark{
-	kvBenchmark(0 /* readPercent */, 1 /* nodeCount */),
-	kvBenchmark(0 /* readPercent */, 3 /* nodeCount */),
-	kvBenchmark(50 /* readPercent */, 1 /* nodeCount */),
-	kvBenchmark(50 /* readPercent */, 3 /* nodeCount */),
-	kvBenchmark(95 /* readPercent */, 1 /* nodeCount */),
-	kvBenchmark(95 /* readPercent */, 3 /* nodeCount */),
+// benchmarkNameRe matches the name of a benchmark, kv<read percent>/nodes=<count>.
+var benchmarkNameRe = regexp.MustCompile(`^kv(\d+)/nodes=(\d+)$`)
+
+// lookupBenchmark returns the benchmark named name, which runs the kv
+// workload with any percentage of reads against a cluster of any size,
+// e.g. "kv95/nodes=5".
+func lookupBenchmark(name string) (*benchmark, error) {
+	m := benchmarkNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return nil, fmt.Errorf("unknown benchmark %q", name)
+	}
+	readPercent, err := strconv.Atoi(m[1])
+	if err != nil || readPercent > 100 {
+		return nil, fmt.Errorf("invalid read percentage in benchmark %q", name)
+	}
+	nodeCount, err := strconv.Atoi(m[2])
+	if err != nil || nodeCount < 1 {
+		return nil, fmt.Errorf("invalid node count in benchmark %q", name)
+	}
+	b := kvBenchmark(readPercent, nodeCount)
+	return &b, nil
 }
 
-func runBenchmark(b *driver.B, cfg *config, instances []*cockroachdbInstance) (err error) {
+// pgURL returns the URL the load generator connects to inst with, as
+// root, over TLS if the cluster is secure.
+func pgURL(cfg *config, inst *cockroachdbInstance) string {
+	if !cfg.secure {
+		return fmt.Sprintf(`postgres://root@%s?sslmode=disable`, inst.sqlAddr())
+	}
+	dir := certsDir(cfg)
+	q := url.Values{
+		"sslmode":     {"verify-full"},
+		"sslrootcert": {filepath.Join(dir, "ca.crt")},
+		"sslcert":     {filepath.Join(dir, "client.root.crt")},
+		"sslkey":      {filepath.Join(dir, "client.root.key")},
+	}
+	return fmt.Sprintf(`postgres://root@%s?%s`, inst.sqlAddr(), q.Encode())
+}
+
+func runBenchmark(b *driver.B, cfg *config, instances []*cockroachdbInstance, soak *soakSampler) (err error) {
 	var pgurls []string
 	for _, inst := range instances {
-		host := inst.sqlAddr()
-		pgurls = append(pgurls, fmt.Sprintf(`postgres://root@%s?sslmode=disable`, host))
+		pgurls = append(pgurls, pgURL(cfg, inst))
 	}
 	// Load in the schema needed for the workload via `workload init`
 	log.Println("loading the schema")
@@ -391,18 +594,31 @@ func runBenchmark(b *driver.B, cfg *config, instances []*cockroachdbInstance) (e
 		return err
 	}
 
-	log.Println("sleeping")
-
 	// If we try and start the workload right after loading in the schema
-	// it will spam us with database does not exist errors. We could repeatedly
-	// retry until the database exists by parsing the output, or we can just
-	// wait 5 seconds.
-	time.Sleep(5 * time.Second)
+	// it will spam us with database does not exist errors, until every
+	// node sees the new database.
+	log.Println("waiting for schema")
+	if err = waitForSQL(instances, cfg, "schema", fmt.Sprintf("SHOW TABLES FROM %s;", cfg.bench.workload)); err != nil {
+		return err
+	}
 
-	args := cfg.bench.args
-	if cfg.short {
+	concurrency := cfg.concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+	args := append(cfg.bench.args[:len(cfg.bench.args):len(cfg.bench.args)],
+		fmt.Sprintf("--concurrency=%d", concurrency),
+		fmt.Sprintf("--seed=%d", cfg.seed),
+	)
+	switch {
+	case cfg.duration != 0:
+		args = append(args,
+			fmt.Sprintf("--ramp=%s", cfg.duration/4),
+			fmt.Sprintf("--duration=%s", cfg.duration),
+		)
+	case cfg.short:
 		args = append(args, cfg.bench.shortArgs...)
-	} else {
+	default:
 		args = append(args, cfg.bench.longArgs...)
 	}
 	args = append(args, pgurls...)
@@ -422,14 +638,26 @@ func runBenchmark(b *driver.B, cfg *config, instances []*cockroachdbInstance) (e
 		}
 	}()
 
+	// Stop early if asked to, while still reporting what the workload
+	// got done, so that a timeout doesn't lose everything.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
 	finished := make(chan bool, 1)
 	var benchmarkErr error
 	go func() {
+		rss := startRSSSampler(instances)
+		gc := startGCCPUSampler(instances)
+		soak.begin()
 		b.ResetTimer()
 		if err = cmd.Run(); err != nil {
 			benchmarkErr = err
 		}
 		b.StopTimer()
+		soak.end()
+		rss.finish(b)
+		gc.finish(b)
 		finished <- true
 	}()
 
@@ -440,6 +668,18 @@ func runBenchmark(b *driver.B, cfg *config, instances []*cockroachdbInstance) (e
 			return fmt.Errorf("error killing benchmark process, benchmark timed out: %w", err)
 		}
 		return errors.New("benchmark timed out")
+	case sig := <-sigs:
+		// The workload summarizes the operations so far when
+		// interrupted, just as when it finishes.
+		log.Printf("received %s, stopping the workload", sig)
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return fmt.Errorf("error interrupting benchmark process: %w", err)
+		}
+		<-finished
+		if err := reportFromBenchmarkOutput(b, cfg, stdout.String()); err != nil {
+			return err
+		}
+		return errInterrupted
 	}
 
 	if benchmarkErr != nil {
@@ -463,9 +703,34 @@ func reportFromBenchmarkOutput(b *driver.B, cfg *config, output string) (err err
 			return err
 		}
 	}
+	return reportResultLatencies(b, output)
+}
 
-	if err != nil {
-		return err
+// reportResultLatencies reports the latency percentiles across all
+// operation types, which the workload summarizes in the "__result" line
+// at the end of its output.
+//
+// Unlike the per-operation-type metrics, the latencies are converted to
+// nanoseconds before truncation, so sub-millisecond differences in the
+// tail are preserved.
+func reportResultLatencies(b *driver.B, output string) error {
+	re := regexp.MustCompile(`.*__result\n(.*)`)
+	match := re.FindStringSubmatch(output)
+	if match == nil {
+		return errors.New("failed to find result metrics in output")
+	}
+	// The fields are: elapsed, errors, ops(total), ops/sec(cum),
+	// avg(ms), p50(ms), p95(ms), p99(ms), pMax(ms).
+	fields := strings.Fields(match[1])
+	if len(fields) < 9 {
+		return fmt.Errorf("unexpected result metrics format: %q", match[1])
+	}
+	for i, name := range []string{"p50-latency-ns", "p95-latency-ns", "p99-latency-ns"} {
+		ms, err := strconv.ParseFloat(fields[5+i], 64)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		b.Report(name, uint64(ms*1e6))
 	}
 	return nil
 }
@@ -537,10 +802,28 @@ func reportMetrics(b *driver.B, metricType string, metrics benchmarkMetrics) {
 	b.Report(fmt.Sprintf("%s-p100-latency-ns", metricType), metrics.p100Latency)
 }
 
+// reportClusterStartup emits a separate benchmark result for the time
+// it took to bring up the cluster, which is otherwise not measured.
+func reportClusterStartup(cfg *config, startup time.Duration) error {
+	name := fmt.Sprintf("CockroachDBClusterStartup/nodes=%d%s", cfg.bench.nodeCount, cfg.nameSuffix)
+	return driver.RunBenchmark(name, func(d *driver.B) error {
+		d.Report(driver.StatTime, uint64(startup.Nanoseconds()))
+		return nil
+	})
+}
+
 func run(cfg *config) (err error) {
+	if cfg.secure {
+		log.Println("creating certificates")
+		if err := createCerts(cfg); err != nil {
+			return fmt.Errorf("creating certificates: %v", err)
+		}
+	}
 	log.Println("launching cluster")
 	var instances []*cockroachdbInstance
-	// Launch the server.
+	// Launch the server, timing how long it takes for the cluster
+	// to become ready.
+	startupStart := time.Now()
 	instances, err = launchCockroachCluster(cfg)
 
 	if err != nil {
@@ -582,6 +865,14 @@ func run(cfg *config) (err error) {
 	if err = waitForCluster(instances, cfg); err != nil {
 		return err
 	}
+	if cfg.secure {
+		if err = authenticateHTTP(cfg, instances[0]); err != nil {
+			return err
+		}
+	}
+	if err = reportClusterStartup(cfg, time.Since(startupStart)); err != nil {
+		return err
+	}
 
 	log.Println("setting cluster settings")
 	if err = instances[0].setClusterSettings(cfg); err != nil {
@@ -596,7 +887,23 @@ func run(cfg *config) (err error) {
 		driver.BenchmarkPID(instances[0].cmd.Process.Pid),
 		driver.DoPerf(true),
 	}
-	return driver.RunBenchmark(cfg.bench.reportName, func(d *driver.B) error {
+	if cfg.heapDiffDir != "" {
+		if err = writeProfiles(instances, cfg.heapDiffDir, "heap", "start"); err != nil {
+			return err
+		}
+	}
+	if cfg.contentionDir != "" {
+		for _, kind := range []string{"mutex", "block"} {
+			if err = writeProfiles(instances, cfg.contentionDir, kind, "start"); err != nil {
+				return err
+			}
+		}
+	}
+	// Samples over time, if asked for, are reported once the benchmark
+	// itself is, so that its result isn't interleaved with theirs.
+	soak := newSoakSampler(instances, cfg.sampleInterval)
+	var interrupted bool
+	err = driver.RunBenchmark(cfg.bench.reportName, func(d *driver.B) error {
 		// Set up diagnostics.
 		var finishers []func() uint64
 		if driver.DiagnosticEnabled(diagnostics.CPUProfile) {
@@ -661,10 +968,81 @@ func run(cfg *config) (err error) {
 				wg.Wait()
 			}()
 		}
-		// Actually run the benchmark.
+		// Actually run the benchmark. If it's interrupted, still finish
+		// the run so that the partial results and diagnostics are
+		// written out.
 		log.Println("running benchmark")
-		return runBenchmark(d, cfg, instances)
+		err := runBenchmark(d, cfg, instances, soak)
+		if errors.Is(err, errInterrupted) {
+			interrupted = true
+			return nil
+		}
+		return err
 	}, opts...)
+	if err != nil {
+		return err
+	}
+	if err = soak.report(cfg); err != nil {
+		return err
+	}
+	if interrupted {
+		return errInterrupted
+	}
+	if cfg.heapDiffDir != "" {
+		if err = writeProfiles(instances, cfg.heapDiffDir, "heap", "end"); err != nil {
+			return err
+		}
+	}
+	if cfg.contentionDir != "" {
+		for _, kind := range []string{"mutex", "block"} {
+			if err = writeProfiles(instances, cfg.contentionDir, kind, "end"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeProfiles writes a profile of kind, "heap", "mutex" or "block", of
+// each instance to dir, named <instance>.<kind>-<when>.pprof. All these
+// kinds are cumulative, so it's the difference between the profiles at
+// the start and at the end that describes the benchmark. Heap profiles
+// are taken just after a GC, so they reflect live memory rather than
+// garbage that happens to not be collected yet.
+func writeProfiles(instances []*cockroachdbInstance, dir, kind, when string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	query := ""
+	if kind == "heap" {
+		// gc=1 has the server run a GC before writing the profile,
+		// equivalent to runtime.GC followed by pprof.WriteHeapProfile.
+		query = "?gc=1"
+	}
+	for _, inst := range instances {
+		resp, err := http.Get(fmt.Sprintf("http://%s/debug/pprof/%s%s", inst.httpAddr(), kind, query))
+		if err != nil {
+			return fmt.Errorf("fetching %s profile of %s: %w", kind, inst.name, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("fetching %s profile of %s: %s", kind, inst.name, resp.Status)
+		}
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s.%s-%s.pprof", inst.name, kind, when)))
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		_, err = io.Copy(f, resp.Body)
+		resp.Body.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s profile of %s: %w", kind, inst.name, err)
+		}
+	}
+	return nil
 }
 
 func main() {
@@ -676,26 +1054,83 @@ func main() {
 	for _, typ := range diagnostics.Types() {
 		cliCfg.isProfiling = cliCfg.isProfiling || driver.DiagnosticEnabled(typ)
 	}
-	for i := range benchmarks {
-		if benchmarks[i].name == cliCfg.benchName {
-			cliCfg.bench = &benchmarks[i]
-			break
-		}
+	bench, err := lookupBenchmark(cliCfg.benchName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if cliCfg.concurrency < 0 {
+		fmt.Fprintf(os.Stderr, "error: -concurrency must not be negative\n")
+		os.Exit(1)
 	}
-	if cliCfg.bench == nil {
-		fmt.Fprintf(os.Stderr, "error: unknown benchmark %q\n", cliCfg.benchName)
+	if cliCfg.duration < 0 || cliCfg.sampleInterval < 0 {
+		fmt.Fprintf(os.Stderr, "error: -duration and -sample-interval must not be negative\n")
 		os.Exit(1)
 	}
+	cliCfg.bench = bench
+	if cliCfg.duration != 0 {
+		// The timeout is sized for the usual run, on top of which the
+		// workload now runs for as long as it's asked to, ramp included.
+		cliCfg.bench.timeout += cliCfg.duration + cliCfg.duration/4
+	}
+	if cliCfg.concurrency != 0 {
+		cliCfg.bench.reportName += fmt.Sprintf("/conc=%d", cliCfg.concurrency)
+	}
+	switch cliCfg.store {
+	case "disk":
+	case "mem":
+		cliCfg.nameSuffix = "/store=mem" + cliCfg.nameSuffix
+	default:
+		fmt.Fprintf(os.Stderr, "error: -store must be disk or mem, got %q\n", cliCfg.store)
+		os.Exit(1)
+	}
+	if cliCfg.secure {
+		// Cluster startup is tagged too, since a secure cluster does
+		// more work to come up.
+		cliCfg.nameSuffix = "/secure" + cliCfg.nameSuffix
+	}
+	if cliCfg.sampleInterval != 0 {
+		// The samples' live heap and GC pauses come from the trace.
+		cliCfg.gcTrace = true
+	}
+	if cliCfg.gcTrace {
+		// Writing out a trace of every GC cycle costs the servers a
+		// little, so traced results aren't quite comparable with others.
+		cliCfg.nameSuffix = "/gctrace" + cliCfg.nameSuffix
+	}
+	cliCfg.bench.reportName += cliCfg.nameSuffix
+	if cliCfg.seed == 0 {
+		cliCfg.seed = time.Now().UnixNano()
+		log.Printf("using seed %d; pass -seed %d to reproduce", cliCfg.seed, cliCfg.seed)
+	}
 
 	// We're going to launch a bunch of cockroachdb instances. Distribute
 	// GOMAXPROCS between those and ourselves equally.
+	//
+	// If GOMAXPROCS is set explicitly, e.g. to sweep over it, every
+	// cockroachdb instance runs with that value instead, while we, and
+	// the load generator, still get an equal share of the CPUs.
 	procs := runtime.GOMAXPROCS(-1)
+	var serverProcs int
+	if s := os.Getenv("GOMAXPROCS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "error: invalid GOMAXPROCS %q\n", s)
+			os.Exit(1)
+		}
+		procs = runtime.NumCPU()
+		serverProcs = n
+	}
 	procsPerInst := procs / (cliCfg.bench.nodeCount + 1)
 	if procsPerInst == 0 {
 		procsPerInst = 1
 	}
+	if serverProcs == 0 {
+		serverProcs = procsPerInst
+	}
 	runtime.GOMAXPROCS(procsPerInst)
 	cliCfg.procsPerInst = procsPerInst
+	cliCfg.serverProcs = serverProcs
 
 	if err := run(&cliCfg); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -0,0 +1,89 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+)
+
+// rssSampleInterval is how often the RSS of each server is sampled.
+const rssSampleInterval = 100 * time.Millisecond
+
+var rssUnsupportedOnce sync.Once
+
+// rssSampler samples the resident set size of the cluster's servers while
+// the workload runs, so that the RSS it reports reflects the steady state
+// being measured rather than the cluster's startup or the schema load.
+// It reports them as server-peak-RSS-bytes and server-avg-RSS-bytes,
+// next to the driver's peak-RSS-bytes and average-RSS-bytes, which only
+// cover the first server over the whole run but keep their old meaning.
+type rssSampler struct {
+	stop chan struct{}
+	done chan struct{}
+
+	// peak and sum are the largest and total of the samples, across all
+	// servers, and n is the number of samples.
+	peak, sum, n uint64
+}
+
+// startRSSSampler starts sampling the RSS of instances, returning nil
+// if RSS can't be read on this platform.
+func startRSSSampler(instances []*cockroachdbInstance) *rssSampler {
+	if runtime.GOOS != "linux" {
+		rssUnsupportedOnce.Do(func() {
+			fmt.Fprintf(os.Stderr, "# warning: RSS of the servers isn't reported on %s\n", runtime.GOOS)
+		})
+		return nil
+	}
+	s := &rssSampler{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(rssSampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+			for _, inst := range instances {
+				rss, err := driver.ReadRSS(inst.cmd.Process.Pid)
+				if err != nil || rss == 0 {
+					// The server may be exiting.
+					continue
+				}
+				if rss > s.peak {
+					s.peak = rss
+				}
+				s.sum += rss
+				s.n++
+			}
+		}
+	}()
+	return s
+}
+
+// finish stops sampling and reports the peak and average RSS of any one
+// server, if any samples were taken. A nil sampler reports nothing.
+func (s *rssSampler) finish(b *driver.B) {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	if s.n == 0 {
+		return
+	}
+	b.Report("server-peak-RSS-bytes", s.peak)
+	b.Report("server-avg-RSS-bytes", s.sum/s.n)
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+)
+
+// soakSample is the state of the cluster's servers at one point of a long
+// run, summed over all of them, and what their GCs did since the sample
+// before.
+type soakSample struct {
+	elapsed  time.Duration // since the workload started
+	rss      uint64        // bytes
+	heapLive uint64        // bytes, as the latest GC cycle of each server left it
+
+	gcCycles        uint64
+	gcPause         time.Duration
+	gcCPU, totalCPU time.Duration
+}
+
+// soakSampler samples the cluster's servers every -sample-interval while
+// the workload runs, so that runs of a fixed duration, e.g. an hour, show
+// how memory use and GC behave over time, and not only on average. That
+// catches slow leaks and heap growth that the usual short runs miss.
+type soakSampler struct {
+	instances []*cockroachdbInstance
+	interval  time.Duration
+	start     time.Time
+
+	stop chan struct{}
+	done chan struct{}
+
+	samples []soakSample
+}
+
+// newSoakSampler returns a sampler for instances that samples them every
+// interval once started, or nil if interval is zero.
+func newSoakSampler(instances []*cockroachdbInstance, interval time.Duration) *soakSampler {
+	if interval == 0 {
+		return nil
+	}
+	return &soakSampler{
+		instances: instances,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// begin starts sampling, as the workload starts. A nil sampler does
+// nothing.
+func (s *soakSampler) begin() {
+	if s == nil {
+		return
+	}
+	s.start = time.Now()
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		heapLive := make([]uint64, len(s.instances))
+		prev := s.start
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-t.C:
+				s.sample(prev, now, heapLive)
+				prev = now
+			}
+		}
+	}()
+}
+
+// end stops sampling, as the workload finishes. A nil sampler does
+// nothing.
+func (s *soakSampler) end() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// sample records the state of the servers at now, and what their GCs did
+// since prev. heapLive holds the live heap of each server as of its latest
+// GC cycle so far, and is updated with any newer ones.
+func (s *soakSampler) sample(prev, now time.Time, heapLive []uint64) {
+	ss := soakSample{elapsed: now.Sub(s.start).Round(time.Second)}
+	for i, inst := range s.instances {
+		rss, err := driver.ReadRSS(inst.cmd.Process.Pid)
+		if err == nil {
+			// The server may be exiting, in which case it's zero.
+			ss.rss += rss
+		}
+		cycles := inst.gcTrace.between(prev, now)
+		for _, c := range cycles {
+			ss.gcPause += c.pause
+			heapLive[i] = c.heapLive
+		}
+		ss.gcCycles += uint64(len(cycles))
+		ss.heapLive += heapLive[i]
+		gc, total := inst.gcTrace.window(prev, now)
+		ss.gcCPU += gc
+		ss.totalCPU += total
+	}
+	log.Printf("soak: at %s, RSS %d MiB, live heap %d MiB, %d GC cycles pausing for %s",
+		ss.elapsed, ss.rss>>20, ss.heapLive>>20, ss.gcCycles, ss.gcPause)
+	s.samples = append(s.samples, ss)
+}
+
+// report reports each sample as a benchmark result of its own, named
+// after the benchmark and when the sample was taken, e.g.
+// CockroachDBkv0/nodes=3/t=10m0s, so that the trend over the run can be
+// compared with benchstat. It must be called once the sampler ended, and
+// outside of the benchmark itself, whose result is reported separately. A
+// nil sampler reports nothing.
+func (s *soakSampler) report(cfg *config) error {
+	if s == nil {
+		return nil
+	}
+	for _, ss := range s.samples {
+		name := fmt.Sprintf("%s/t=%s", cfg.bench.reportName, ss.elapsed)
+		err := driver.RunBenchmark(name, func(d *driver.B) error {
+			d.Report("rss-bytes", ss.rss)
+			d.Report("heap-live-bytes", ss.heapLive)
+			d.Report("gc-cycles", ss.gcCycles)
+			d.Report("gc-pause-ns", uint64(ss.gcPause.Nanoseconds()))
+			if ss.totalCPU > 0 {
+				d.ReportFloat(driver.StatGCCPUFraction, ss.gcCPU.Seconds()/ss.totalCPU.Seconds())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,11 +11,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -182,6 +186,10 @@ type benchmark struct {
 	args       []string
 	longArgs   []string // if !config.short
 	shortArgs  []string // if config.short
+
+	// walFsync indicates whether to report percentiles of how long the
+	// cluster took to fsync its write-ahead log during the benchmark.
+	walFsync bool
 }
 
 var benchmarks = []benchmark{
@@ -224,6 +232,29 @@ var benchmarks = []benchmark{
 			"--total=1000",
 		},
 	},
+	// "wal-fsync" issues a high rate of small writes, each of which etcd
+	// fsyncs to its write-ahead log before acknowledging it. Few clients
+	// are used so that etcd can't amortize an fsync over many writes.
+	{
+		name:       "wal-fsync",
+		reportName: "EtcdWALFsync",
+		args: []string{
+			"--precise",
+			"--conns=8",
+			"--clients=32",
+			"put",
+			"--key-size=8",
+			"--sequential-keys",
+			"--val-size=16",
+		},
+		longArgs: []string{
+			"--total=200000",
+		},
+		shortArgs: []string{
+			"--total=2000",
+		},
+		walFsync: true,
+	},
 }
 
 func runBenchmark(b *driver.B, cfg *config, instances []*etcdInstance) (err error) {
@@ -251,15 +282,120 @@ func runBenchmark(b *driver.B, cfg *config, instances []*etcdInstance) (err erro
 		}
 	}()
 
+	var fsyncsBefore fsyncHistogram
+	if cfg.bench.walFsync {
+		if fsyncsBefore, err = scrapeWALFsyncs(instances); err != nil {
+			return err
+		}
+	}
+
 	b.ResetTimer()
 	if err := cmd.Run(); err != nil {
 		return err
 	}
 	b.StopTimer()
 
+	if cfg.bench.walFsync {
+		fsyncsAfter, err := scrapeWALFsyncs(instances)
+		if err != nil {
+			return err
+		}
+		fsyncs := fsyncsAfter.sub(fsyncsBefore)
+		b.Report("wal-fsyncs", uint64(fsyncs.count()))
+		b.Report("p50-wal-fsync-ns", uint64(fsyncs.quantile(0.50)*1e9))
+		b.Report("p90-wal-fsync-ns", uint64(fsyncs.quantile(0.90)*1e9))
+		b.Report("p99-wal-fsync-ns", uint64(fsyncs.quantile(0.99)*1e9))
+	}
+
 	return reportFromBenchmarkOutput(b, stdout.String())
 }
 
+// walFsyncMetric is the name of the histogram, in seconds, of how long
+// etcd takes to fsync its write-ahead log.
+const walFsyncMetric = "etcd_disk_wal_fsync_duration_seconds"
+
+var walFsyncBucketRE = regexp.MustCompile(`(?m)^` + walFsyncMetric + `_bucket\{le="([^"]+)"\} (\S+)$`)
+
+// fsyncHistogram is a cumulative histogram of WAL fsync durations,
+// mapping the upper bound of each bucket, in seconds, to the number of
+// fsyncs that took at most that long.
+type fsyncHistogram map[float64]float64
+
+// scrapeWALFsyncs returns the histogram of WAL fsync durations so far,
+// summed over instances.
+func scrapeWALFsyncs(instances []*etcdInstance) (fsyncHistogram, error) {
+	h := make(fsyncHistogram)
+	for _, inst := range instances {
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", inst.host(clientPort)))
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to scrape metrics from %s: %s", inst.name, resp.Status)
+		}
+		matches := walFsyncBucketRE.FindAllSubmatch(body, -1)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("failed to find %s metric from %s", walFsyncMetric, inst.name)
+		}
+		for _, m := range matches {
+			le, err := strconv.ParseFloat(string(m[1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s bucket bound %q: %v", walFsyncMetric, m[1], err)
+			}
+			n, err := strconv.ParseFloat(string(m[2]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s bucket count %q: %v", walFsyncMetric, m[2], err)
+			}
+			h[le] += n
+		}
+	}
+	return h, nil
+}
+
+// sub returns the histogram of the fsyncs counted in h but not start.
+func (h fsyncHistogram) sub(start fsyncHistogram) fsyncHistogram {
+	d := make(fsyncHistogram)
+	for le, n := range h {
+		d[le] = n - start[le]
+	}
+	return d
+}
+
+// count returns the number of fsyncs in h.
+func (h fsyncHistogram) count() float64 {
+	return h[math.Inf(1)]
+}
+
+// quantile estimates the q-quantile of the fsync durations in h, in
+// seconds, interpolating linearly within buckets, as Prometheus'
+// histogram_quantile does.
+func (h fsyncHistogram) quantile(q float64) float64 {
+	bounds := make([]float64, 0, len(h))
+	for le := range h {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	rank := q * h.count()
+	var lower, below float64
+	for _, le := range bounds {
+		n := h[le]
+		if n >= rank && n > below {
+			if math.IsInf(le, 1) {
+				// The quantile is beyond the largest bucket.
+				return lower
+			}
+			return lower + (le-lower)*(rank-below)/(n-below)
+		}
+		lower, below = le, n
+	}
+	return lower
+}
+
 func reportFromBenchmarkOutput(b *driver.B, output string) (err error) {
 	defer func() {
 		if err != nil {
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/pprof/profile"
@@ -22,10 +23,12 @@ import (
 )
 
 var (
-	goTool    string
-	tmpDir    string
-	toolexec  bool
-	benchName string
+	goTool     string
+	tmpDir     string
+	toolexec   bool
+	benchName  string
+	nameSuffix string
+	parallel   int
 )
 
 func init() {
@@ -34,6 +37,8 @@ func init() {
 	flag.StringVar(&tmpDir, "tmp", "", "work directory (cleared before use)")
 	flag.BoolVar(&toolexec, "toolexec", false, "run as a toolexec binary")
 	flag.StringVar(&benchName, "bench-name", "", "for -toolexec")
+	flag.StringVar(&nameSuffix, "name-suffix", "", "suffix to append to the names of reported benchmarks, e.g. /gomaxprocs=8")
+	flag.IntVar(&parallel, "p", 0, "the number of programs that go build may run in parallel, where 0 means the go command's default")
 }
 
 func tmpResultsDir() string {
@@ -57,6 +62,9 @@ func run(pkgPath string) error {
 	name := "GoBuild" + strings.Title(filepath.Base(pkgPath))
 
 	cmdArgs := []string{"build", "-a"}
+	if parallel > 0 {
+		cmdArgs = append(cmdArgs, "-p", strconv.Itoa(parallel))
+	}
 
 	// Build a command comprised of this binary to pass to -toolexec.
 	selfPath, err := filepath.Abs(os.Args[0])
@@ -68,7 +76,7 @@ func run(pkgPath string) error {
 		"-bench-name", name,
 	}
 	flag.CommandLine.Visit(func(f *flag.Flag) {
-		if f.Name == "go" || f.Name == "bench-name" || strings.HasPrefix(f.Name, "perf") {
+		if f.Name == "go" || f.Name == "bench-name" || f.Name == "p" || strings.HasPrefix(f.Name, "perf") {
 			// No need to pass this along.
 			return
 		}
@@ -94,7 +102,7 @@ func run(pkgPath string) error {
 	if err != nil {
 		return err
 	}
-	err = driver.RunBenchmark(name, func(d *driver.B) error {
+	err = driver.RunBenchmark(name+nameSuffix, func(d *driver.B) error {
 		return cmd.Run()
 	}, append(benchOpts, driver.DoAvgRSS(cmd.RSSFunc()))...)
 	if err != nil {
@@ -242,7 +250,7 @@ func runToolexec() error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if benchmark {
-		name := benchName + benchSuffix
+		name := benchName + benchSuffix + nameSuffix
 		f, err := os.Create(filepath.Join(tmpResultsDir(), name+".results"))
 		if err != nil {
 			return err
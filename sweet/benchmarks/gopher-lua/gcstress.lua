@@ -0,0 +1,19 @@
+-- Copyright 2024 The Go Authors. All rights reserved.
+-- Use of this source code is governed by a BSD-style
+-- license that can be found in the LICENSE file.
+
+-- stress allocates a table and a couple of strings in each of rounds
+-- iterations, keeping only the most recent few thousand tables live. Almost
+-- everything it allocates dies young, so the Go heap backing the VM turns
+-- over quickly and the GC runs often, with little to retain each time.
+function stress(rounds)
+  local window = {}
+  local size = 4096
+  local sum = 0
+  for i = 1, rounds do
+    local t = {i, i * 2, i * 3, name = "item" .. i, tag = tostring(i % 97)}
+    window[i % size + 1] = t
+    sum = sum + #t.name + #t.tag
+  end
+  return sum
+end
@@ -8,9 +8,11 @@ package main
 
 import (
 	"bufio"
+	_ "embed"
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 
 	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
@@ -18,16 +20,29 @@ import (
 	lua "github.com/yuin/gopher-lua"
 )
 
-var short bool
+var (
+	short     bool
+	benchName string
+)
 
 func init() {
 	flag.BoolVar(&short, "short", false, "whether to run a short version of this benchmark")
+	flag.StringVar(&benchName, "bench", "knucleotide", "name of the benchmark to run: knucleotide or gc-stress")
 }
 
 func parseFlags() error {
 	flag.Parse()
-	if flag.NArg() != 2 {
-		return fmt.Errorf("expected lua program and input for it")
+	switch benchName {
+	case "knucleotide":
+		if flag.NArg() != 2 {
+			return fmt.Errorf("expected lua program and input for it")
+		}
+	case "gc-stress":
+		if flag.NArg() != 0 {
+			return fmt.Errorf("unexpected args: the gc-stress program is built in")
+		}
+	default:
+		return fmt.Errorf("unknown benchmark %q", benchName)
 	}
 	return nil
 }
@@ -96,13 +111,65 @@ func run(luafile, inputfile string) error {
 	}, driver.InProcessMeasurementOptions...)
 }
 
+// gcStressProgram is the Lua program run by the gc-stress benchmark.
+//
+//go:embed gcstress.lua
+var gcStressProgram string
+
+// The number of iterations of the gc-stress program's allocation loop in
+// each run, and in each run of the short version.
+const (
+	gcStressRounds      = 2_000_000
+	gcStressShortRounds = 100_000
+)
+
+// runGCStress runs the gc-stress benchmark, which allocates heavily from
+// Lua, reporting how many allocations the VM made and how much time the
+// GC spent paused for them.
+func runGCStress() error {
+	s := lua.NewState()
+	defer s.Close()
+	if err := s.DoString(gcStressProgram); err != nil {
+		return err
+	}
+	rounds := gcStressRounds
+	if short {
+		rounds = gcStressShortRounds
+	}
+	stress := lua.P{
+		Fn:      s.GetGlobal("stress"),
+		NRet:    1,
+		Protect: true,
+	}
+	return driver.RunBenchmark("GopherLuaGCStress", func(b *driver.B) error {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if err := s.CallByParam(stress, lua.LNumber(rounds)); err != nil {
+			return err
+		}
+		s.Pop(1)
+		runtime.ReadMemStats(&after)
+		b.Report("allocs/op", after.Mallocs-before.Mallocs)
+		b.Report("gc-cycles", uint64(after.NumGC-before.NumGC))
+		b.Report("gc-pause-ns", after.PauseTotalNs-before.PauseTotalNs)
+		return nil
+	}, driver.InProcessMeasurementOptions...)
+}
+
 func main() {
 	driver.SetFlags(flag.CommandLine)
 	if err := parseFlags(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+	var err error
+	switch benchName {
+	case "knucleotide":
+		err = run(flag.Arg(0), flag.Arg(1))
+	case "gc-stress":
+		err = runGCStress()
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -0,0 +1,368 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm && !plan9
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/server"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
+)
+
+const (
+	grpcHost    = "127.0.0.1:50051"
+	profileHost = "127.0.0.1:50052"
+)
+
+type config struct {
+	serve       bool
+	tmpDir      string
+	short       bool
+	payload     int
+	concurrency int
+	procs       int
+	gomaxprocs  int
+}
+
+var cliCfg config
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.BoolVar(&cliCfg.serve, "serve", false, "run the echo server instead of the benchmark")
+	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
+	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+	flag.IntVar(&cliCfg.payload, "payload", 1024, "size in bytes of each request and response message")
+	flag.IntVar(&cliCfg.concurrency, "concurrency", 16, "number of concurrent calls (unary) or streams (streaming)")
+
+	// The client is as busy as the server, so split GOMAXPROCS evenly
+	// between the two.
+	procs := runtime.GOMAXPROCS(-1)
+	half := procs / 2
+	if half == 0 {
+		half = 1
+	}
+	runtime.GOMAXPROCS(half)
+	cliCfg.procs = half
+	cliCfg.gomaxprocs = procs
+}
+
+// rawCodec passes messages through as raw bytes, so the benchmark needs no
+// generated protobuf code and measures only the RPC layer.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = append((*v.(*[]byte))[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "sweet-raw"
+}
+
+type echoService interface{}
+
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "sweet.Echo",
+	HandlerType: (*echoService)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Unary",
+		Handler: func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			var msg []byte
+			if err := dec(&msg); err != nil {
+				return nil, err
+			}
+			return &msg, nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(_ any, stream grpc.ServerStream) error {
+			var msg []byte
+			for {
+				if err := stream.RecvMsg(&msg); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(&msg); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+// serve runs the echo server until killed.
+func serve() error {
+	go http.ListenAndServe(profileHost, nil)
+	lis, err := net.Listen("tcp", grpcHost)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	s.RegisterService(&echoDesc, struct{}{})
+	return s.Serve(lis)
+}
+
+type serverInstance struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+}
+
+func launchServer(cfg *config) (*serverInstance, error) {
+	// Re-execute ourselves as the server, so it runs in its own process.
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	inst := &serverInstance{}
+	inst.cmd = exec.Command(self, "-serve")
+	inst.cmd.Env = append(os.Environ(), fmt.Sprintf("GOMAXPROCS=%d", cfg.procs))
+	inst.cmd.Stdout = &inst.output
+	inst.cmd.Stderr = &inst.output
+	if err := inst.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start server: %v", err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		c, err := net.Dial("tcp", grpcHost)
+		if err == nil {
+			c.Close()
+			return inst, nil
+		}
+		if time.Now().After(deadline) {
+			inst.shutdown()
+			return nil, fmt.Errorf("timed out waiting for server: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (i *serverInstance) shutdown() error {
+	if err := i.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	// The server never exits on its own, so an error from Wait is
+	// expected.
+	i.cmd.Wait()
+	return nil
+}
+
+// worker performs one call's worth of work, returning its latency.
+type worker func(ctx context.Context) (time.Duration, error)
+
+type benchmark struct {
+	name string
+	// newWorker creates the state for one of cfg.concurrency concurrent
+	// workers.
+	newWorker func(ctx context.Context, cc *grpc.ClientConn, payload []byte) (worker, error)
+}
+
+var benchmarks = []benchmark{
+	{
+		name: "Unary",
+		newWorker: func(ctx context.Context, cc *grpc.ClientConn, payload []byte) (worker, error) {
+			var resp []byte
+			return func(ctx context.Context) (time.Duration, error) {
+				start := time.Now()
+				err := cc.Invoke(ctx, "/sweet.Echo/Unary", &payload, &resp, grpc.ForceCodec(rawCodec{}))
+				return time.Since(start), err
+			}, nil
+		},
+	},
+	{
+		name: "Streaming",
+		newWorker: func(ctx context.Context, cc *grpc.ClientConn, payload []byte) (worker, error) {
+			stream, err := cc.NewStream(ctx, &echoDesc.Streams[0], "/sweet.Echo/Stream", grpc.ForceCodec(rawCodec{}))
+			if err != nil {
+				return nil, err
+			}
+			var resp []byte
+			return func(ctx context.Context) (time.Duration, error) {
+				start := time.Now()
+				if err := stream.SendMsg(&payload); err != nil {
+					return 0, err
+				}
+				err := stream.RecvMsg(&resp)
+				return time.Since(start), err
+			}, nil
+		},
+	},
+}
+
+func runBenchmark(b *driver.B, cfg *config, bench *benchmark, cc *grpc.ClientConn) error {
+	calls := 200000
+	if cfg.short {
+		calls = 1000
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := bytes.Repeat([]byte{'x'}, cfg.payload)
+	workers := make([]worker, cfg.concurrency)
+	for i := range workers {
+		w, err := bench.newWorker(ctx, cc, payload)
+		if err != nil {
+			return err
+		}
+		workers[i] = w
+	}
+
+	var next atomic.Int64
+	latencies := make([][]time.Duration, len(workers))
+	errs := make([]error, len(workers))
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i, w := range workers {
+		i, w := i, w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(calls) {
+				lat, err := w(ctx)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				latencies[i] = append(latencies[i], lat)
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return errors.New("no calls completed")
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	quantile := func(q float64) uint64 {
+		return uint64(all[int(q*float64(len(all)-1))])
+	}
+	b.Report("p50-latency-ns", quantile(0.50))
+	b.Report("p90-latency-ns", quantile(0.90))
+	b.Report("p99-latency-ns", quantile(0.99))
+
+	b.Ops(calls)
+	b.Report("ops/s", uint64(float64(calls)/b.Elapsed().Seconds()))
+	return nil
+}
+
+func run(cfg *config) (err error) {
+	inst, err := launchServer(cfg)
+	if err != nil {
+		return fmt.Errorf("starting server: %v", err)
+	}
+	defer func() {
+		if r := inst.shutdown(); r != nil && err == nil {
+			err = r
+		}
+		if err != nil && inst.output.Len() != 0 {
+			fmt.Fprintln(os.Stderr, "=== server stdout+stderr ===")
+			fmt.Fprintln(os.Stderr, inst.output.String())
+		}
+	}()
+
+	cc, err := grpc.Dial(grpcHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	opts := []driver.RunOption{
+		driver.DoTime(true),
+		driver.DoPeakRSS(true),
+		driver.DoPeakVM(true),
+		driver.DoDefaultAvgRSS(),
+		driver.DoCoreDump(true),
+		driver.BenchmarkPID(inst.cmd.Process.Pid),
+		driver.DoPerf(true),
+		driver.WithGOMAXPROCS(cfg.gomaxprocs),
+	}
+	for i := range benchmarks {
+		bench := &benchmarks[i]
+		name := fmt.Sprintf("GRPC%s/payload=%d/concurrency=%d", bench.name, cfg.payload, cfg.concurrency)
+		err := driver.RunBenchmark(name, func(d *driver.B) error {
+			if driver.DiagnosticEnabled(diagnostics.CPUProfile) {
+				stop := server.PollDiagnostic(profileHost, cfg.tmpDir, name, diagnostics.CPUProfile)
+				defer stop()
+			}
+			if driver.DiagnosticEnabled(diagnostics.Trace) {
+				stop := server.PollDiagnostic(profileHost, cfg.tmpDir, name, diagnostics.Trace)
+				defer func() {
+					d.Report("trace-bytes", stop())
+				}()
+			}
+			if driver.DiagnosticEnabled(diagnostics.MemProfile) {
+				defer func() {
+					if _, err := server.CollectDiagnostic(profileHost, cfg.tmpDir, name, diagnostics.MemProfile); err != nil {
+						fmt.Fprintf(os.Stderr, "failed to read memprofile: %v", err)
+					}
+				}()
+			}
+			return runBenchmark(d, cfg, bench, cc)
+		}, opts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
+		os.Exit(1)
+	}
+	if cliCfg.serve {
+		if err := serve(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cliCfg.payload < 0 || cliCfg.concurrency <= 0 {
+		fmt.Fprintf(os.Stderr, "error: -payload must be non-negative and -concurrency positive\n")
+		os.Exit(1)
+	}
+	if err := run(&cliCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
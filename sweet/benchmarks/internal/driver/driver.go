@@ -32,6 +32,8 @@ var (
 
 func SetFlags(f *flag.FlagSet) {
 	f.StringVar(&coreDumpDir, "dump-cores", "", "dump a core file to the given directory after every benchmark run")
+	f.StringVar(&runtimeMetricsDir, "runtime-metrics-dir", "", "write a JSON snapshot of the runtime/metrics of the benchmark process to the given directory at the end of every benchmark run, for benchmarks that run in this process")
+	f.StringVar(&runtimeMetricsNames, "runtime-metrics", "default", "comma-separated list of the runtime/metrics to snapshot for -runtime-metrics-dir, where default stands for a small set describing the GC and scheduler, and all for every metric")
 	diag = diagnostics.SetFlagsForDriver(f)
 }
 
@@ -40,6 +42,12 @@ const (
 	StatPeakVM  = "peak-VM-bytes"
 	StatAvgRSS  = "average-RSS-bytes"
 	StatTime    = "ns/op"
+
+	// StatGCCPUFraction is the fraction of the CPU time available to the
+	// benchmark's Go processes that the garbage collector used while the
+	// benchmark ran. Benchmarks that measure it for processes other than
+	// themselves should report it under this name too, with ReportFloat.
+	StatGCCPUFraction = "gc-cpu-fraction"
 )
 
 type RunOption func(*B)
@@ -94,6 +102,15 @@ func DoMemProfile(v bool) RunOption {
 	}
 }
 
+// DoGCCPUFraction reports StatGCCPUFraction for this process, over the
+// time the timer runs. It requires the runtime/metrics of Go 1.20 or
+// later, and is otherwise not reported.
+func DoGCCPUFraction(v bool) RunOption {
+	return func(b *B) {
+		b.doGCCPU = v
+	}
+}
+
 func DoPerf(v bool) RunOption {
 	return func(b *B) {
 		b.collectDiag[diagnostics.Perf] = v
@@ -110,6 +127,7 @@ func BenchmarkPID(pid int) RunOption {
 	return func(b *B) {
 		b.pid = pid
 		if pid != os.Getpid() {
+			b.doGCCPU = false
 			b.collectDiag[diagnostics.CPUProfile] = false
 			b.collectDiag[diagnostics.MemProfile] = false
 			b.collectDiag[diagnostics.Perf] = false
@@ -146,6 +164,7 @@ var InProcessMeasurementOptions = []RunOption{
 	DoMemProfile(true),
 	DoPerf(true),
 	DoTrace(true),
+	DoGCCPUFraction(true),
 }
 
 type B struct {
@@ -158,11 +177,15 @@ type B struct {
 	doPeakRSS     bool
 	doPeakVM      bool
 	doCoreDump    bool
+	doGCCPU       bool
+	gcCPU         cpuTimes // GC and total CPU time while the timer ran
+	gcCPUStart    cpuTimes // GC and total CPU time when the timer started
 	gomaxprocs    int
 	collectDiag   map[diagnostics.Type]bool
 	rssFunc       func() (uint64, error)
 	statsMu       sync.Mutex
 	stats         map[string]uint64
+	floatStats    map[string]float64
 	ops           int
 	wg            sync.WaitGroup
 	diagnostics   map[diagnostics.Type]*os.File
@@ -179,6 +202,7 @@ func newB(name string) *B {
 			diagnostics.MemProfile: false,
 		},
 		stats:       make(map[string]uint64),
+		floatStats:  make(map[string]float64),
 		ops:         1,
 		diagnostics: make(map[diagnostics.Type]*os.File),
 	}
@@ -208,6 +232,9 @@ func (b *B) StartTimer() {
 			warningf("failed to start perf: %v", err)
 		}
 	}
+	if b.doGCCPU {
+		b.gcCPUStart = readCPUTimes()
+	}
 	b.start = time.Now()
 }
 
@@ -231,9 +258,13 @@ func (b *B) ResetTimer() {
 		}
 	}
 	if !b.start.IsZero() {
+		if b.doGCCPU {
+			b.gcCPUStart = readCPUTimes()
+		}
 		b.start = time.Now()
 	}
 	b.dur = 0
+	b.gcCPU = cpuTimes{}
 }
 
 func (b *B) truncateDiagnosticData(typ diagnostics.Type) error {
@@ -252,6 +283,9 @@ func (b *B) StopTimer() {
 	}
 	b.dur += end.Sub(b.start)
 	b.start = time.Time{}
+	if b.doGCCPU {
+		b.gcCPU = b.gcCPU.add(readCPUTimes().sub(b.gcCPUStart))
+	}
 
 	if b.shouldCollectDiag(diagnostics.CPUProfile) {
 		pprof.StopCPUProfile()
@@ -275,6 +309,14 @@ func (b *B) Report(name string, value uint64) {
 	b.stats[name] = value
 }
 
+// ReportFloat is like Report, for metrics that aren't whole numbers, like
+// StatGCCPUFraction.
+func (b *B) ReportFloat(name string, value float64) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	b.floatStats[name] = value
+}
+
 func (b *B) Ops(ops int) {
 	b.ops = ops
 }
@@ -337,12 +379,17 @@ func (b *B) report() {
 	defer b.statsMu.Unlock()
 
 	// Collect all names of non-zero stats.
-	names := make([]string, 0, len(b.stats))
+	names := make([]string, 0, len(b.stats)+len(b.floatStats))
 	for name, value := range b.stats {
 		if value != 0 {
 			names = append(names, name)
 		}
 	}
+	for name, value := range b.floatStats {
+		if value != 0 {
+			names = append(names, name)
+		}
+	}
 	if len(names) == 0 {
 		fmt.Fprintln(os.Stderr, "# No benchmark results found for this run.")
 		return
@@ -386,9 +433,10 @@ func (b *B) report() {
 	}
 	fmt.Fprintf(out, "Benchmark%s%s %d", b.name, suffix, b.ops)
 	for _, name := range names {
-		value := b.stats[name]
-		if value != 0 {
-			fmt.Fprintf(out, " %d %s", value, name)
+		if value, ok := b.floatStats[name]; ok {
+			fmt.Fprintf(out, " %g %s", value, name)
+		} else {
+			fmt.Fprintf(out, " %d %s", b.stats[name], name)
 		}
 	}
 	fmt.Fprintln(out)
@@ -488,6 +536,11 @@ func RunBenchmark(name string, f func(*B) error, opts ...RunOption) error {
 	if b.TimerRunning() {
 		b.StopTimer()
 	}
+	if runtimeMetricsDir != "" && b.pid == os.Getpid() {
+		if err := writeRuntimeMetrics(b.name); err != nil {
+			warningf("failed to write runtime metrics: %v", err)
+		}
+	}
 
 	// Stop the RSS sampler.
 	if stop != nil {
@@ -523,6 +576,9 @@ func RunBenchmark(name string, f func(*B) error, opts ...RunOption) error {
 		}
 		b.setStat(StatTime, uint64(b.dur.Nanoseconds())/uint64(b.ops))
 	}
+	if b.doGCCPU && b.gcCPU.total > 0 {
+		b.ReportFloat(StatGCCPUFraction, b.gcCPU.gc/b.gcCPU.total)
+	}
 	if b.doCoreDump && coreDumpDir != "" {
 		// Use gcore to dump the core of the benchmark process.
 		cmd := exec.Command(
@@ -0,0 +1,39 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import "runtime/metrics"
+
+// cpuTimes are the CPU time, in seconds, the garbage collector used and
+// that was available to this process in all, as the runtime estimates
+// them: the latter is the wall-clock time multiplied by GOMAXPROCS.
+type cpuTimes struct {
+	gc, total float64
+}
+
+func (t cpuTimes) add(u cpuTimes) cpuTimes {
+	return cpuTimes{gc: t.gc + u.gc, total: t.total + u.total}
+}
+
+func (t cpuTimes) sub(u cpuTimes) cpuTimes {
+	return cpuTimes{gc: t.gc - u.gc, total: t.total - u.total}
+}
+
+// readCPUTimes returns the CPU time used so far by this process. It
+// returns zero if the runtime doesn't support the metrics needed, which
+// appeared in Go 1.20.
+func readCPUTimes() cpuTimes {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/gc/total:cpu-seconds"},
+		{Name: "/cpu/classes/total:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		if s.Value.Kind() != metrics.KindFloat64 {
+			return cpuTimes{}
+		}
+	}
+	return cpuTimes{gc: samples[0].Value.Float64(), total: samples[1].Value.Float64()}
+}
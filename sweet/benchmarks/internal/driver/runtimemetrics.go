@@ -0,0 +1,122 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strings"
+)
+
+var (
+	runtimeMetricsDir   string
+	runtimeMetricsNames string
+)
+
+// defaultRuntimeMetrics are the runtime/metrics snapshotted unless
+// -runtime-metrics says otherwise: a few that say a lot about how the
+// GC and scheduler behaved, without the file growing too large.
+var defaultRuntimeMetrics = []string{
+	"/gc/cycles/total:gc-cycles",
+	"/gc/heap/goal:bytes",
+	"/gc/heap/live:bytes",
+	"/gc/heap/allocs:bytes",
+	"/gc/pauses:seconds",
+	"/memory/classes/total:bytes",
+	"/sched/goroutines:goroutines",
+	"/sched/latencies:seconds",
+}
+
+// runtimeMetrics returns the names of the metrics -runtime-metrics
+// selects: "default" stands for defaultRuntimeMetrics, and "all" for
+// every metric the runtime supports.
+func runtimeMetrics() []string {
+	var names []string
+	for _, name := range strings.Split(runtimeMetricsNames, ",") {
+		switch name {
+		case "":
+		case "default":
+			names = append(names, defaultRuntimeMetrics...)
+		case "all":
+			for _, d := range metrics.All() {
+				names = append(names, d.Name)
+			}
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonFloat is a float64 that's encoded as a string if it's infinite,
+// like the outermost boundaries of runtime/metrics histograms, which
+// JSON numbers can't represent.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(f), 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(float64(f), -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+// histogramBucket is a bucket of a runtime/metrics histogram, of the
+// values from Low, inclusive, to High, exclusive.
+type histogramBucket struct {
+	Low   jsonFloat `json:"low"`
+	High  jsonFloat `json:"high"`
+	Count uint64    `json:"count"`
+}
+
+// writeRuntimeMetrics writes a snapshot of the runtime/metrics of this
+// process that -runtime-metrics selects to a JSON file named after the
+// benchmark in -runtime-metrics-dir, e.g. BleveIndexBatch100.metrics.json,
+// replacing any from earlier runs. Counters and gauges are numbers, and
+// histograms lists of their non-empty buckets. Metrics this runtime
+// doesn't support are left out.
+func writeRuntimeMetrics(name string) error {
+	names := runtimeMetrics()
+	samples := make([]metrics.Sample, 0, len(names))
+	for _, n := range names {
+		samples = append(samples, metrics.Sample{Name: n})
+	}
+	metrics.Read(samples)
+	values := make(map[string]any)
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			values[s.Name] = jsonFloat(s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			buckets := []histogramBucket{}
+			for i, c := range h.Counts {
+				if c != 0 {
+					buckets = append(buckets, histogramBucket{jsonFloat(h.Buckets[i]), jsonFloat(h.Buckets[i+1]), c})
+				}
+			}
+			values[s.Name] = buckets
+		}
+	}
+	b, err := json.Marshal(struct {
+		Benchmark string         `json:"benchmark"`
+		Metrics   map[string]any `json:"metrics"`
+	}{name, values})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(runtimeMetricsDir, 0755); err != nil {
+		return err
+	}
+	file := strings.ReplaceAll(name, "/", "-") + ".metrics.json"
+	return os.WriteFile(filepath.Join(runtimeMetricsDir, file), append(b, '\n'), 0644)
+}
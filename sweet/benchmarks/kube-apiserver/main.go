@@ -0,0 +1,463 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm && !plan9
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+)
+
+const (
+	apiserverHost = "127.0.0.1:6443"
+	etcdClientURL = "http://127.0.0.1:2379"
+	etcdPeerURL   = "http://127.0.0.1:2380"
+
+	// token is a static bearer token granting full access to the apiserver.
+	token = "sweet-benchmark-token"
+
+	namespace = "sweet"
+)
+
+type config struct {
+	apiserverBin string
+	etcdBin      string
+	tmpDir       string
+	short        bool
+}
+
+var cliCfg config
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.StringVar(&cliCfg.apiserverBin, "apiserver-bin", "", "path to kube-apiserver binary")
+	flag.StringVar(&cliCfg.etcdBin, "etcd-bin", "", "path to etcd binary")
+	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
+	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+}
+
+// workload describes the scripted create/list/watch workload.
+type workload struct {
+	// objects is the number of ConfigMaps to create.
+	objects int
+
+	// creators is the number of concurrent clients creating objects.
+	creators int
+
+	// lists is the number of times to list all objects once they're
+	// created.
+	lists int
+
+	// watchers is the number of clients watching for objects as they're
+	// created.
+	watchers int
+}
+
+var (
+	longWorkload  = workload{objects: 5000, creators: 16, lists: 50, watchers: 10}
+	shortWorkload = workload{objects: 200, creators: 4, lists: 5, watchers: 2}
+)
+
+type process struct {
+	name   string
+	cmd    *exec.Cmd
+	output bytes.Buffer
+}
+
+func start(name string, bin string, args ...string) (*process, error) {
+	p := &process{name: name}
+	p.cmd = exec.Command(bin, args...)
+	p.cmd.Stdout = &p.output
+	p.cmd.Stderr = &p.output
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", name, err)
+	}
+	return p, nil
+}
+
+func (p *process) shutdown() error {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	if _, err := p.cmd.Process.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeServiceAccountKey generates the key kube-apiserver uses to sign and
+// verify service account tokens, and returns its path.
+func writeServiceAccountKey(dir string) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "sa.key")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return path, os.WriteFile(path, data, 0o600)
+}
+
+func launch(cfg *config) (procs []*process, err error) {
+	defer func() {
+		if err != nil {
+			shutdown(procs)
+		}
+	}()
+	etcd, err := start("etcd", cfg.etcdBin,
+		"--data-dir", filepath.Join(cfg.tmpDir, "etcd.data"),
+		"--listen-client-urls", etcdClientURL,
+		"--advertise-client-urls", etcdClientURL,
+		"--listen-peer-urls", etcdPeerURL,
+		"--initial-advertise-peer-urls", etcdPeerURL,
+		"--initial-cluster", "default="+etcdPeerURL,
+		"--logger=zap",
+		"--log-outputs=stderr",
+	)
+	if err != nil {
+		return nil, err
+	}
+	procs = append(procs, etcd)
+
+	saKey, err := writeServiceAccountKey(cfg.tmpDir)
+	if err != nil {
+		return procs, err
+	}
+	tokenFile := filepath.Join(cfg.tmpDir, "tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte(token+`,sweet,sweet,"system:masters"`+"\n"), 0o600); err != nil {
+		return procs, err
+	}
+	apiserver, err := start("kube-apiserver", cfg.apiserverBin,
+		"--etcd-servers", etcdClientURL,
+		"--bind-address", "127.0.0.1",
+		"--advertise-address", "127.0.0.1",
+		"--secure-port", strings.TrimPrefix(apiserverHost, "127.0.0.1:"),
+		"--cert-dir", filepath.Join(cfg.tmpDir, "certs"),
+		"--token-auth-file", tokenFile,
+		"--authorization-mode", "AlwaysAllow",
+		"--service-account-issuer", "https://kubernetes.default.svc",
+		"--service-account-key-file", saKey,
+		"--service-account-signing-key-file", saKey,
+		"--service-cluster-ip-range", "10.0.0.0/24",
+	)
+	if err != nil {
+		return procs, err
+	}
+	procs = append(procs, apiserver)
+
+	// Wait for the apiserver to be ready, which also means it can reach etcd.
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		_, err := do("GET", "/readyz", nil, http.StatusOK)
+		if err == nil {
+			return procs, nil
+		}
+		if time.Now().After(deadline) {
+			return procs, fmt.Errorf("timed out waiting for kube-apiserver: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func shutdown(procs []*process) error {
+	var errs []error
+	// Shut down in reverse order, so the apiserver doesn't lose etcd first.
+	for i := len(procs) - 1; i >= 0; i-- {
+		if err := procs[i].shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to shutdown %s: %v", procs[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// client talks to the apiserver, which serves a self-signed certificate.
+var client = &http.Client{
+	Transport: &http.Transport{
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConnsPerHost: 64,
+	},
+}
+
+func request(method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, "https://"+apiserverHost+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do performs a request against the apiserver and returns the response
+// body, or an error if the response doesn't have the status want.
+func do(method, path string, body []byte, want int) ([]byte, error) {
+	req, err := request(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != want {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+func configMap(i int) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":   fmt.Sprintf("sweet-%d", i),
+			"labels": map[string]string{"app": "sweet", "shard": strconv.Itoa(i % 16)},
+		},
+		"data": map[string]string{
+			"payload": strings.Repeat("x", 1024),
+		},
+	})
+	return b
+}
+
+// resourceVersion lists the objects in the namespace and returns the
+// resource version of the list, from which watches can start.
+func resourceVersion() (string, error) {
+	data, err := do("GET", "/api/v1/namespaces/"+namespace+"/configmaps", nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return "", err
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch watches the namespace's ConfigMaps starting at rv until it has seen
+// n objects added.
+func watch(rv string, n int) error {
+	req, err := request("GET", "/api/v1/namespaces/"+namespace+"/configmaps?watch=1&resourceVersion="+rv, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("watch: %s", resp.Status)
+	}
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for added := 0; added < n; {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := dec.Decode(&event); err != nil {
+			return fmt.Errorf("watch: %v", err)
+		}
+		switch event.Type {
+		case "ADDED":
+			added++
+		case "ERROR":
+			return fmt.Errorf("watch: received error event")
+		}
+	}
+	return nil
+}
+
+// heapAlloc returns the apiserver's current heap size, as reported by its
+// metrics endpoint.
+func heapAlloc() (uint64, error) {
+	data, err := do("GET", "/metrics", nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "go_memstats_heap_alloc_bytes "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			return uint64(f), err
+		}
+	}
+	return 0, fmt.Errorf("heap size not found in metrics")
+}
+
+func runBenchmark(b *driver.B, cfg *config) error {
+	w := longWorkload
+	if cfg.short {
+		w = shortWorkload
+	}
+
+	ns, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]any{"name": namespace},
+	})
+	if _, err := do("POST", "/api/v1/namespaces", ns, http.StatusCreated); err != nil {
+		return err
+	}
+	rv, err := resourceVersion()
+	if err != nil {
+		return err
+	}
+	heapBefore, err := heapAlloc()
+	if err != nil {
+		return err
+	}
+
+	b.ResetTimer()
+
+	// Start the watchers first, so they observe every object as it's created.
+	var watchWG sync.WaitGroup
+	watchErrs := make([]error, w.watchers)
+	for i := 0; i < w.watchers; i++ {
+		i := i
+		watchWG.Add(1)
+		go func() {
+			defer watchWG.Done()
+			watchErrs[i] = watch(rv, w.objects)
+		}()
+	}
+
+	// Create all the objects.
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	errs := make([]error, w.creators)
+	for i := 0; i < w.creators; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j := int(next.Add(1)) - 1
+				if j >= w.objects {
+					return
+				}
+				if _, err := do("POST", "/api/v1/namespaces/"+namespace+"/configmaps", configMap(j), http.StatusCreated); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// List everything repeatedly.
+	for i := 0; i < w.lists; i++ {
+		if _, err := do("GET", "/api/v1/namespaces/"+namespace+"/configmaps", nil, http.StatusOK); err != nil {
+			return err
+		}
+	}
+
+	watchWG.Wait()
+	b.StopTimer()
+	if err := errors.Join(watchErrs...); err != nil {
+		return err
+	}
+
+	heapAfter, err := heapAlloc()
+	if err != nil {
+		return err
+	}
+	var growth uint64
+	if heapAfter > heapBefore {
+		growth = heapAfter - heapBefore
+	}
+	ops := w.objects + w.lists
+	b.Ops(ops)
+	b.Report("ops/s", uint64(float64(ops)/b.Elapsed().Seconds()))
+	b.Report("heap-growth-bytes", growth)
+	return nil
+}
+
+func run(cfg *config) (err error) {
+	procs, err := launch(cfg)
+	if err != nil {
+		return fmt.Errorf("starting servers: %v", err)
+	}
+	defer func() {
+		if r := shutdown(procs); r != nil {
+			if err == nil {
+				err = r
+			} else {
+				fmt.Fprintln(os.Stderr, r)
+			}
+		}
+		if err != nil {
+			for _, p := range procs {
+				if p.output.Len() != 0 {
+					fmt.Fprintf(os.Stderr, "=== %s stdout+stderr ===\n", p.name)
+					fmt.Fprintln(os.Stderr, p.output.String())
+				}
+			}
+		}
+	}()
+
+	// The apiserver only serves its profiling endpoints over
+	// authenticated TLS, so the server package's diagnostic collection
+	// doesn't apply here.
+	opts := []driver.RunOption{
+		driver.DoTime(true),
+		driver.DoPeakRSS(true),
+		driver.DoPeakVM(true),
+		driver.DoDefaultAvgRSS(),
+		driver.DoCoreDump(true),
+		driver.BenchmarkPID(procs[len(procs)-1].cmd.Process.Pid),
+		driver.DoPerf(true),
+	}
+	return driver.RunBenchmark("KubeAPIServerCreateListWatch", func(d *driver.B) error {
+		return runBenchmark(d, cfg)
+	}, opts...)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
+		os.Exit(1)
+	}
+	if err := run(&cliCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -16,6 +16,48 @@ import (
 	"gitlab.com/golang-commonmark/markdown"
 )
 
+var (
+	short     bool
+	benchName string
+)
+
+func init() {
+	flag.BoolVar(&short, "short", false, "whether to run a short version of this benchmark")
+	flag.StringVar(&benchName, "bench", "render", "name of the benchmark to run: render, which renders each document in the asset directory, or render-large, which renders a much larger corpus built from them")
+}
+
+// The size of each document in the render-large corpus, and the size of
+// the whole corpus, in bytes.
+const (
+	largeDocSize         = 4 << 20
+	largeCorpusSize      = 128 << 20
+	largeCorpusShortSize = 8 << 20
+)
+
+// largeCorpus returns documents of largeDocSize bytes, totalling size
+// bytes, each a concatenation of the documents in contents, cycling
+// through them as needed. The documents are real-world READMEs, so this
+// renders real content, but at a scale that exercises the allocator.
+func largeCorpus(contents [][]byte, size int) ([][]byte, error) {
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("no markdown documents to build a corpus from")
+	}
+	var docs [][]byte
+	next := 0
+	for total := 0; total < size; total += largeDocSize {
+		doc := make([]byte, 0, largeDocSize+len(contents[next]))
+		for len(doc) < largeDocSize {
+			// Separate documents with a blank line so that one's last
+			// block doesn't run into the next one's first.
+			doc = append(doc, contents[next]...)
+			doc = append(doc, "\n\n"...)
+			next = (next + 1) % len(contents)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
 func run(mddir string) error {
 	files, err := os.ReadDir(mddir)
 	if err != nil {
@@ -33,6 +75,23 @@ func run(mddir string) error {
 		}
 	}
 
+	name := "MarkdownRenderXHTML"
+	if benchName == "render-large" {
+		size := largeCorpusSize
+		if short {
+			size = largeCorpusShortSize
+		}
+		var err error
+		if contents, err = largeCorpus(contents, size); err != nil {
+			return err
+		}
+		name = "MarkdownRenderXHTMLLarge"
+	}
+	var total int
+	for _, c := range contents {
+		total += len(c)
+	}
+
 	out := bytes.Buffer{}
 	out.Grow(1024 * 1024)
 
@@ -44,11 +103,15 @@ func run(mddir string) error {
 		markdown.Linkify(true),
 	)
 
-	return driver.RunBenchmark("MarkdownRenderXHTML", func(_ *driver.B) error {
+	return driver.RunBenchmark(name, func(b *driver.B) error {
 		for _, c := range contents {
 			md.Render(&out, c)
 			out.Reset()
 		}
+		b.StopTimer()
+		if elapsed := b.Elapsed(); elapsed > 0 {
+			b.Report("B/s", uint64(float64(total)/elapsed.Seconds()))
+		}
 		return nil
 	}, driver.InProcessMeasurementOptions...)
 }
@@ -60,6 +123,10 @@ func main() {
 		fmt.Fprintln(os.Stderr, "expected asset directory as input")
 		os.Exit(1)
 	}
+	if benchName != "render" && benchName != "render-large" {
+		fmt.Fprintf(os.Stderr, "unknown benchmark %q\n", benchName)
+		os.Exit(1)
+	}
 	if err := run(flag.Arg(0)); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -0,0 +1,396 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm && !plan9
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/server"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
+)
+
+const (
+	clientAddr  = "127.0.0.1:4222"
+	profileHost = "127.0.0.1:8222"
+
+	// Each publisher publishes to its own subject, and every subscriber
+	// subscribes to all of them, so each message is delivered
+	// subscribers times.
+	publishers  = 4
+	subscribers = 4
+
+	payloadSize = 128
+
+	// flushEvery is the number of messages a publisher sends before
+	// waiting for the server to acknowledge them. This keeps publishers
+	// from racing far enough ahead of subscribers that the server
+	// disconnects them as slow consumers.
+	flushEvery = 1000
+)
+
+type config struct {
+	natsBin    string
+	tmpDir     string
+	short      bool
+	procs      int
+	gomaxprocs int
+}
+
+var cliCfg config
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.StringVar(&cliCfg.natsBin, "nats-bin", "", "path to nats-server binary")
+	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
+	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+
+	// The load generator is as busy as the server, so split GOMAXPROCS
+	// evenly between the two.
+	procs := runtime.GOMAXPROCS(-1)
+	half := procs / 2
+	if half == 0 {
+		half = 1
+	}
+	runtime.GOMAXPROCS(half)
+	cliCfg.procs = half
+	cliCfg.gomaxprocs = procs
+}
+
+type natsInstance struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+}
+
+func launchNATS(cfg *config) (*natsInstance, error) {
+	host, port, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		return nil, err
+	}
+	_, profilePort, err := net.SplitHostPort(profileHost)
+	if err != nil {
+		return nil, err
+	}
+	inst := &natsInstance{}
+	inst.cmd = exec.Command(cfg.natsBin,
+		"-a", host,
+		"-p", port,
+		"-profile", profilePort,
+		"-P", filepath.Join(cfg.tmpDir, "nats-server.pid"),
+		"-l", filepath.Join(cfg.tmpDir, "nats-server.log"),
+	)
+	inst.cmd.Env = append(os.Environ(), fmt.Sprintf("GOMAXPROCS=%d", cfg.procs))
+	inst.cmd.Stdout = &inst.output
+	inst.cmd.Stderr = &inst.output
+	if err := inst.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start nats-server: %v", err)
+	}
+	// Make sure the server is accepting clients before continuing.
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		c, err := dial()
+		if err == nil {
+			c.Close()
+			return inst, nil
+		}
+		if time.Now().After(deadline) {
+			inst.shutdown()
+			return nil, fmt.Errorf("timed out waiting for nats-server: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (i *natsInstance) shutdown() error {
+	if err := i.cmd.Process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	if _, err := i.cmd.Process.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// conn is a minimal client for the NATS text protocol.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func dial() (*conn, error) {
+	nc, err := net.Dial("tcp", clientAddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		Conn: nc,
+		r:    bufio.NewReaderSize(nc, 64<<10),
+		w:    bufio.NewWriterSize(nc, 64<<10),
+	}
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !bytes.HasPrefix(line, []byte("INFO ")) {
+		c.Close()
+		return nil, fmt.Errorf("unexpected greeting from server: %q", line)
+	}
+	c.w.WriteString(`CONNECT {"verbose":false,"pedantic":false,"name":"sweet"}` + "\r\n")
+	if err := c.flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// flush writes out any buffered commands and waits for the server to
+// process them.
+func (c *conn) flush() error {
+	c.w.WriteString("PING\r\n")
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("PONG")):
+			return nil
+		case bytes.HasPrefix(line, []byte("PING")):
+			c.w.WriteString("PONG\r\n")
+		case bytes.HasPrefix(line, []byte("-ERR")):
+			return fmt.Errorf("server error: %s", bytes.TrimSpace(line))
+		}
+	}
+}
+
+// publish sends count messages to subject, each stamped with the time it
+// was sent relative to epoch.
+func publish(subject string, count int, epoch time.Time) error {
+	c, err := dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	header := []byte(fmt.Sprintf("PUB %s %d\r\n", subject, payloadSize))
+	msg := make([]byte, payloadSize+2)
+	copy(msg[payloadSize:], "\r\n")
+	for i := 0; i < count; i++ {
+		binary.LittleEndian.PutUint64(msg, uint64(time.Since(epoch)))
+		c.w.Write(header)
+		c.w.Write(msg)
+		if (i+1)%flushEvery == 0 {
+			if err := c.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return c.flush()
+}
+
+// subscriber is a connection subscribed to all publishers' subjects.
+type subscriber struct {
+	c         *conn
+	latencies []time.Duration
+}
+
+func subscribe(expected int) (*subscriber, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	c.w.WriteString("SUB sweet.* 1\r\n")
+	// Make sure the subscription is registered before any messages are
+	// published.
+	if err := c.flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &subscriber{c: c, latencies: make([]time.Duration, 0, expected)}, nil
+}
+
+// receive reads messages until it has seen cap(s.latencies) of them,
+// recording the latency of each relative to epoch.
+func (s *subscriber) receive(epoch time.Time) error {
+	defer s.c.Close()
+	buf := make([]byte, payloadSize+2)
+	for len(s.latencies) < cap(s.latencies) {
+		line, err := s.c.r.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("MSG ")):
+			// MSG <subject> <sid> <size>\r\n
+			fields := bytes.Fields(line)
+			size, err := strconv.Atoi(string(fields[len(fields)-1]))
+			if err != nil {
+				return fmt.Errorf("malformed message header %q: %v", line, err)
+			}
+			if size != payloadSize {
+				return fmt.Errorf("unexpected message size %d", size)
+			}
+			if _, err := io.ReadFull(s.c.r, buf); err != nil {
+				return err
+			}
+			sent := time.Duration(binary.LittleEndian.Uint64(buf))
+			s.latencies = append(s.latencies, time.Since(epoch)-sent)
+		case bytes.HasPrefix(line, []byte("PING")):
+			s.c.w.WriteString("PONG\r\n")
+			if err := s.c.w.Flush(); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, []byte("-ERR")):
+			return fmt.Errorf("server error: %s", bytes.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+func runBenchmark(b *driver.B, cfg *config) error {
+	count := 500000
+	if cfg.short {
+		count = 10000
+	}
+	subs := make([]*subscriber, 0, subscribers)
+	for i := 0; i < subscribers; i++ {
+		s, err := subscribe(publishers * count)
+		if err != nil {
+			return err
+		}
+		subs = append(subs, s)
+	}
+
+	epoch := time.Now()
+	errs := make([]error, publishers+subscribers)
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i, s := range subs {
+		i, s := i, s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.receive(epoch)
+		}()
+	}
+	for i := 0; i < publishers; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[subscribers+i] = publish(fmt.Sprintf("sweet.%d", i), count, epoch)
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	var latencies []time.Duration
+	for _, s := range subs {
+		latencies = append(latencies, s.latencies...)
+	}
+	if len(latencies) == 0 {
+		return errors.New("no messages received")
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	quantile := func(q float64) uint64 {
+		return uint64(latencies[int(q*float64(len(latencies)-1))])
+	}
+	b.Report("p50-latency-ns", quantile(0.50))
+	b.Report("p90-latency-ns", quantile(0.90))
+	b.Report("p99-latency-ns", quantile(0.99))
+
+	published := publishers * count
+	b.Ops(published)
+	b.Report("msgs/s", uint64(float64(published)/b.Elapsed().Seconds()))
+	return nil
+}
+
+func run(cfg *config) (err error) {
+	inst, err := launchNATS(cfg)
+	if err != nil {
+		return fmt.Errorf("starting server: %v", err)
+	}
+	defer func() {
+		if r := inst.shutdown(); r != nil {
+			if err == nil {
+				err = r
+			} else {
+				fmt.Fprintf(os.Stderr, "failed to shutdown nats-server: %v", r)
+			}
+		}
+		if inst.output.Len() != 0 {
+			fmt.Fprintln(os.Stderr, "=== nats-server stdout+stderr ===")
+			fmt.Fprintln(os.Stderr, inst.output.String())
+		}
+	}()
+
+	opts := []driver.RunOption{
+		driver.DoTime(true),
+		driver.DoPeakRSS(true),
+		driver.DoPeakVM(true),
+		driver.DoDefaultAvgRSS(),
+		driver.DoCoreDump(true),
+		driver.BenchmarkPID(inst.cmd.Process.Pid),
+		driver.DoPerf(true),
+		driver.WithGOMAXPROCS(cfg.gomaxprocs),
+	}
+	return driver.RunBenchmark("NATSPubSub", func(d *driver.B) error {
+		if driver.DiagnosticEnabled(diagnostics.CPUProfile) {
+			stop := server.PollDiagnostic(profileHost, cfg.tmpDir, "NATSPubSub", diagnostics.CPUProfile)
+			defer stop()
+		}
+		if driver.DiagnosticEnabled(diagnostics.Trace) {
+			stop := server.PollDiagnostic(profileHost, cfg.tmpDir, "NATSPubSub", diagnostics.Trace)
+			defer func() {
+				d.Report("trace-bytes", stop())
+			}()
+		}
+		if driver.DiagnosticEnabled(diagnostics.MemProfile) {
+			defer func() {
+				if _, err := server.CollectDiagnostic(profileHost, cfg.tmpDir, "NATSPubSub", diagnostics.MemProfile); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to read memprofile: %v", err)
+				}
+			}()
+		}
+		return runBenchmark(d, cfg)
+	}, opts...)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
+		os.Exit(1)
+	}
+	if err := run(&cliCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
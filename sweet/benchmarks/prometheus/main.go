@@ -0,0 +1,378 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !wasm && !plan9
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/driver"
+	"golang.org/x/benchmarks/sweet/benchmarks/internal/server"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
+)
+
+const (
+	host = "127.0.0.1:9090"
+
+	// scrapeInterval is the spacing between the synthetic samples of each
+	// series, mimicking a typical scrape interval.
+	scrapeInterval = 15 * time.Second
+
+	// window is the span of synthetic time covered by each round of
+	// remote write requests. All series are written for a window before
+	// any are written for the next, so series never drift far enough
+	// apart for head compaction to make their samples out of bounds.
+	window = 10 * time.Minute
+
+	// writers is the number of concurrent remote write clients. Each
+	// owns a disjoint subset of the series.
+	writers = 4
+)
+
+type config struct {
+	prometheusBin string
+	tmpDir        string
+	short         bool
+}
+
+var cliCfg config
+
+func init() {
+	driver.SetFlags(flag.CommandLine)
+	flag.StringVar(&cliCfg.prometheusBin, "prometheus-bin", "", "path to prometheus binary")
+	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
+	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+}
+
+// workload describes the fixed set of samples replayed into Prometheus.
+type workload struct {
+	series int
+	span   time.Duration
+}
+
+func (w workload) samplesPerSeries() int {
+	return int(w.span / scrapeInterval)
+}
+
+var (
+	// The long workload spans enough time that the head block becomes
+	// compactable (1.5x the default 2h block range) several times over.
+	longWorkload  = workload{series: 2000, span: 8 * time.Hour}
+	shortWorkload = workload{series: 100, span: time.Hour}
+)
+
+type prometheusInstance struct {
+	cmd    *exec.Cmd
+	output bytes.Buffer
+}
+
+func launchPrometheus(cfg *config) (*prometheusInstance, error) {
+	configFile := filepath.Join(cfg.tmpDir, "prometheus.yml")
+	if err := os.WriteFile(configFile, []byte("global:\n  scrape_interval: 15s\n"), 0o644); err != nil {
+		return nil, err
+	}
+	inst := &prometheusInstance{}
+	inst.cmd = exec.Command(cfg.prometheusBin,
+		"--config.file", configFile,
+		"--storage.tsdb.path", filepath.Join(cfg.tmpDir, "data"),
+		"--web.listen-address", host,
+		"--web.enable-remote-write-receiver",
+		"--log.level", "warn",
+	)
+	inst.cmd.Stdout = &inst.output
+	inst.cmd.Stderr = &inst.output
+	if err := inst.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start prometheus: %v", err)
+	}
+	// Wait for the server to be ready before continuing, so that startup
+	// (including WAL replay) isn't included in the measurement.
+	deadline := time.Now().Add(time.Minute)
+	for {
+		resp, err := http.Get("http://" + host + "/-/ready")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return inst, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			inst.shutdown()
+			return nil, fmt.Errorf("timed out waiting for prometheus to become ready")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (i *prometheusInstance) shutdown() error {
+	if err := i.cmd.Process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	if _, err := i.cmd.Process.Wait(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// series is the state of a single synthetic series.
+type series struct {
+	labels [][2]string
+	value  float64
+	rng    *rand.Rand
+}
+
+func newSeries(i int) *series {
+	// Labels must be sorted by name.
+	return &series{
+		labels: [][2]string{
+			{"__name__", fmt.Sprintf("sweet_metric_%d", i/100)},
+			{"instance", fmt.Sprintf("host-%d", i%100)},
+			{"job", "sweet"},
+		},
+		rng: rand.New(rand.NewSource(int64(i))),
+	}
+}
+
+// appendWriteRequest appends the protobuf encoding of a remote write
+// request containing samples of each of ss at timestamps [start, end)
+// to b.
+func appendWriteRequest(b []byte, ss []*series, start, end time.Time) []byte {
+	var tsBuf, buf []byte
+	for _, s := range ss {
+		tsBuf = tsBuf[:0]
+		for _, l := range s.labels {
+			buf = buf[:0]
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendString(buf, l[0])
+			buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+			buf = protowire.AppendString(buf, l[1])
+			tsBuf = protowire.AppendTag(tsBuf, 1, protowire.BytesType)
+			tsBuf = protowire.AppendBytes(tsBuf, buf)
+		}
+		for t := start; t.Before(end); t = t.Add(scrapeInterval) {
+			// Random walk, so the values look like a real gauge.
+			s.value += s.rng.NormFloat64()
+			buf = buf[:0]
+			buf = protowire.AppendTag(buf, 1, protowire.Fixed64Type)
+			buf = protowire.AppendFixed64(buf, math.Float64bits(s.value))
+			buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+			buf = protowire.AppendVarint(buf, uint64(t.UnixMilli()))
+			tsBuf = protowire.AppendTag(tsBuf, 2, protowire.BytesType)
+			tsBuf = protowire.AppendBytes(tsBuf, buf)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsBuf)
+	}
+	return b
+}
+
+func remoteWrite(client *http.Client, body []byte) error {
+	req, err := http.NewRequest("POST", "http://"+host+"/api/v1/write", bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remote write failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// ingest replays w into Prometheus and returns the number of samples written.
+func ingest(w workload) (int, error) {
+	var shards [writers][]*series
+	for i := 0; i < w.series; i++ {
+		shards[i%writers] = append(shards[i%writers], newSeries(i))
+	}
+	// Align the start of the workload to a block boundary, so that the
+	// number of compactions is the same from run to run.
+	start := time.Now().Add(-w.span).Truncate(2 * time.Hour)
+	end := start.Add(w.span)
+	client := &http.Client{}
+	for ws := start; ws.Before(end); ws = ws.Add(window) {
+		we := ws.Add(window)
+		if we.After(end) {
+			we = end
+		}
+		var wg sync.WaitGroup
+		errs := make([]error, writers)
+		for i := range shards {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = remoteWrite(client, appendWriteRequest(nil, shards[i], ws, we))
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return 0, err
+		}
+	}
+	return w.series * w.samplesPerSeries(), nil
+}
+
+// metrics returns the values of the unlabeled metrics exposed by Prometheus
+// about itself.
+func metrics() (map[string]float64, error) {
+	resp, err := http.Get("http://" + host + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	m := make(map[string]float64)
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.Contains(name, "{") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		m[name] = v
+	}
+	return m, sc.Err()
+}
+
+// waitForCompaction waits until the head block is no longer compactable,
+// i.e. until Prometheus has caught up on compacting the ingested samples.
+func waitForCompaction() error {
+	const blockRange = 2 * time.Hour
+	deadline := time.Now().Add(5 * time.Minute)
+	for {
+		m, err := metrics()
+		if err != nil {
+			return err
+		}
+		headSpan := time.Duration(m["prometheus_tsdb_head_max_time"]-m["prometheus_tsdb_head_min_time"]) * time.Millisecond
+		if headSpan <= blockRange*3/2 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for compaction: head spans %s", headSpan)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func runBenchmark(d *driver.B, cfg *config) error {
+	w := longWorkload
+	if cfg.short {
+		w = shortWorkload
+	}
+	d.ResetTimer()
+	samples, err := ingest(w)
+	if err != nil {
+		return err
+	}
+	d.StopTimer()
+
+	if err := waitForCompaction(); err != nil {
+		return err
+	}
+	m, err := metrics()
+	if err != nil {
+		return err
+	}
+	d.Ops(samples)
+	d.Report("samples/s", uint64(float64(samples)/d.Elapsed().Seconds()))
+	d.Report("compactions", uint64(m["prometheus_tsdb_compactions_total"]))
+	d.Report("compaction-ns", uint64(m["prometheus_tsdb_compaction_duration_seconds_sum"]*1e9))
+	return nil
+}
+
+func run(cfg *config) (err error) {
+	inst, err := launchPrometheus(cfg)
+	if err != nil {
+		return fmt.Errorf("starting prometheus: %v", err)
+	}
+	defer func() {
+		if r := inst.shutdown(); r != nil {
+			if err == nil {
+				err = r
+			} else {
+				fmt.Fprintf(os.Stderr, "failed to shutdown prometheus: %v", r)
+			}
+		}
+		if err != nil && inst.output.Len() != 0 {
+			fmt.Fprintln(os.Stderr, "=== Prometheus stdout+stderr ===")
+			fmt.Fprintln(os.Stderr, inst.output.String())
+		}
+	}()
+
+	opts := []driver.RunOption{
+		driver.DoTime(true),
+		driver.DoPeakRSS(true),
+		driver.DoPeakVM(true),
+		driver.DoDefaultAvgRSS(),
+		driver.DoCoreDump(true),
+		driver.BenchmarkPID(inst.cmd.Process.Pid),
+		driver.DoPerf(true),
+	}
+	return driver.RunBenchmark("PrometheusIngest", func(d *driver.B) error {
+		if driver.DiagnosticEnabled(diagnostics.CPUProfile) {
+			stop := server.PollDiagnostic(host, cfg.tmpDir, "PrometheusIngest", diagnostics.CPUProfile)
+			defer stop()
+		}
+		if driver.DiagnosticEnabled(diagnostics.Trace) {
+			stop := server.PollDiagnostic(host, cfg.tmpDir, "PrometheusIngest", diagnostics.Trace)
+			defer func() {
+				d.Report("trace-bytes", stop())
+			}()
+		}
+		if driver.DiagnosticEnabled(diagnostics.MemProfile) {
+			defer func() {
+				if _, err := server.CollectDiagnostic(host, cfg.tmpDir, "PrometheusIngest", diagnostics.MemProfile); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to read memprofile: %v", err)
+				}
+			}()
+		}
+		return runBenchmark(d, cfg)
+	}, opts...)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
+		os.Exit(1)
+	}
+	if err := run(&cliCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -40,6 +42,9 @@ type config struct {
 	gomaxprocs  int
 	isProfiling bool
 	short       bool
+	bench       string
+	preload     int
+	queryMix    string
 }
 
 func (c *config) diagnosticDataPath(typ diagnostics.Type) string {
@@ -65,11 +70,14 @@ func init() {
 	driver.SetFlags(flag.CommandLine)
 	flag.StringVar(&cliCfg.host, "host", "127.0.0.1", "hostname of tile38 server")
 	flag.IntVar(&cliCfg.port, "port", 9851, "port for tile38 server")
-	flag.Int64Var(&cliCfg.seed, "seed", 0, "seed for PRNG")
+	flag.Int64Var(&cliCfg.seed, "seed", 0, "seed for the points queried and preloaded, or 0 to pick one at random and log it")
 	flag.StringVar(&cliCfg.serverBin, "server", "", "path to tile38 server binary")
 	flag.StringVar(&cliCfg.dataPath, "data", "", "path to tile38 server data")
 	flag.StringVar(&cliCfg.tmpDir, "tmp", "", "path to temporary directory")
 	flag.BoolVar(&cliCfg.short, "short", false, "whether to run a short version of this benchmark")
+	flag.StringVar(&cliCfg.bench, "bench", "query-load", "name of the benchmark to run: query-load, which queries the server's data, or preloaded-query, which queries random points loaded into an empty server first")
+	flag.IntVar(&cliCfg.preload, "preload", 0, "number of points to load into the server for preloaded-query (default 1000000, or 10000 with -short)")
+	flag.StringVar(&cliCfg.queryMix, "query-mix", "within=1,nearby=1", "comma-separated list of query=weight pairs, where query is within, intersects or nearby, setting the mix of queries preloaded-query issues")
 
 	// Grab the number of procs we have and give ourselves only 1/4 of those.
 	procs := runtime.GOMAXPROCS(-1)
@@ -120,24 +128,75 @@ var requestFuncs = []requestFunc{
 	doNearby,
 }
 
-func randPoint() (float64, float64) {
-	return rand.Float64()*180 - 90, rand.Float64()*360 - 180
+// parseQueryMix parses a query mix of the form "within=3,nearby=1" into
+// a list of requests to cycle through that issues each kind of query in
+// proportion to its weight.
+func parseQueryMix(mix string) ([]requestFunc, error) {
+	queries := map[string]requestFunc{
+		"within":     doWithinCircle,
+		"intersects": doIntersectsCircle,
+		"nearby":     doNearby,
+	}
+	var reqs []requestFunc
+	for _, part := range strings.Split(mix, ",") {
+		name, weight, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid query mix %q: expected query=weight, got %q", mix, part)
+		}
+		f, ok := queries[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid query mix %q: unknown query %q", mix, name)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid query mix %q: bad weight %q", mix, weight)
+		}
+		for i := 0; i < n; i++ {
+			reqs = append(reqs, f)
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("invalid query mix %q: no queries", mix)
+	}
+	return reqs, nil
+}
+
+// pointAt returns the point to query in the i'th request of a run with
+// the given seed. Points are a function of the request rather than drawn
+// from a shared source, so that which worker sends which request doesn't
+// change them: runs with the same seed send the same requests.
+func pointAt(seed, i int64) (float64, float64) {
+	// Two rounds of splitmix64, one for each coordinate.
+	x := uint64(seed) + uint64(i)*2*0x9e3779b97f4a7c15
+	next := func() float64 {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		return float64(z>>11) / (1 << 53)
+	}
+	return next()*180 - 90, next()*360 - 180
 }
 
 type worker struct {
 	redis.Conn
+	seed      int64
 	iterCount *int64 // Accessed atomically.
+	reqs      []requestFunc
 	lat       []time.Duration
 }
 
-func newWorker(host string, port int, iterCount *int64) (*worker, error) {
+func newWorker(host string, port int, seed int64, iterCount *int64, reqs []requestFunc) (*worker, error) {
 	conn, err := redis.Dial("tcp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
 		return nil, err
 	}
 	return &worker{
 		Conn:      conn,
+		seed:      seed,
 		iterCount: iterCount,
+		reqs:      reqs,
 		lat:       make([]time.Duration, 0, 100000),
 	}, nil
 }
@@ -147,9 +206,9 @@ func (w *worker) Run(_ context.Context) error {
 	if count < 0 {
 		return pool.Done
 	}
-	lat, lon := randPoint()
+	lat, lon := pointAt(w.seed, count)
 	start := time.Now()
-	if err := requestFuncs[count%3](w.Conn, lat, lon); err != nil {
+	if err := w.reqs[count%int64(len(w.reqs))](w.Conn, lat, lon); err != nil {
 		return err
 	}
 	dur := time.Now().Sub(start)
@@ -167,11 +226,14 @@ func (d durSlice) Len() int           { return len(d) }
 func (d durSlice) Less(i, j int) bool { return d[i] < d[j] }
 func (d durSlice) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
 
-func runBenchmark(d *driver.B, host string, port, clients int, iters int) error {
+// runBenchmark issues iters requests from clients concurrent clients,
+// cycling through reqs, with points chosen by seed, and reports the given
+// percentiles of their latency.
+func runBenchmark(d *driver.B, host string, port, clients int, iters int, seed int64, reqs []requestFunc, percentiles []int) error {
 	workers := make([]pool.Worker, 0, clients)
 	iterCount := int64(iters) // Shared atomic variable.
 	for i := 0; i < clients; i++ {
-		w, err := newWorker(host, port, &iterCount)
+		w, err := newWorker(host, port, seed, &iterCount, reqs)
 		if err != nil {
 			return err
 		}
@@ -193,12 +255,9 @@ func runBenchmark(d *driver.B, host string, port, clients int, iters int) error
 	sort.Sort(durSlice(latencies))
 
 	// Sort and report percentiles.
-	p50 := latencies[len(latencies)*50/100]
-	p90 := latencies[len(latencies)*90/100]
-	p99 := latencies[len(latencies)*99/100]
-	d.Report("p50-latency-ns", uint64(p50))
-	d.Report("p90-latency-ns", uint64(p90))
-	d.Report("p99-latency-ns", uint64(p99))
+	for _, p := range percentiles {
+		d.Report(fmt.Sprintf("p%d-latency-ns", p), uint64(latencies[len(latencies)*p/100]))
+	}
 
 	// Report throughput.
 	lengthS := float64(d.Elapsed()) / float64(time.Second)
@@ -268,11 +327,76 @@ func launchServer(cfg *config, out io.Writer) (*exec.Cmd, error) {
 	return nil, fmt.Errorf("timeout trying to connect to server: %v", err)
 }
 
-const pprofPort = 12345
+// preload loads n random points into the server as the objects of
+// key:bench, pipelining the writes over several connections.
+func preload(cfg *config, n int) error {
+	const (
+		conns = 8
+		batch = 1000
+	)
+	var wg sync.WaitGroup
+	errs := make([]error, conns)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := redis.Dial("tcp", fmt.Sprintf("%s:%d", cfg.host, cfg.port))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer c.Close()
+			r := rand.New(rand.NewSource(cfg.seed + int64(i)))
+			// Connection i loads points i, i+conns, i+2*conns, and so on.
+			for start := i; start < n; start += conns * batch {
+				sent := 0
+				for id := start; id < n && id < start+conns*batch; id += conns {
+					lat, lon := r.Float64()*180-90, r.Float64()*360-180
+					if err := c.Send("SET", "key:bench", "id"+strconv.Itoa(id), "POINT",
+						strconv.FormatFloat(lat, 'f', 5, 64),
+						strconv.FormatFloat(lon, 'f', 5, 64),
+					); err != nil {
+						errs[i] = err
+						return
+					}
+					sent++
+				}
+				if err := c.Flush(); err != nil {
+					errs[i] = err
+					return
+				}
+				for ; sent > 0; sent-- {
+					if _, err := c.Receive(); err != nil {
+						errs[i] = err
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("preloading points: %v", err)
+		}
+	}
+	return nil
+}
 
-const benchName = "Tile38QueryLoad"
+const pprofPort = 12345
 
 func run(cfg *config) (err error) {
+	benchName := "Tile38QueryLoad"
+	reqs, percentiles := requestFuncs, []int{50, 90, 99}
+	if cfg.bench == "preloaded-query" {
+		benchName = "Tile38PreloadedQuery"
+		reqs, err = parseQueryMix(cfg.queryMix)
+		if err != nil {
+			return err
+		}
+		percentiles = []int{50, 95, 99}
+	}
+
 	var buf bytes.Buffer
 
 	// Launch the server.
@@ -322,7 +446,19 @@ func run(cfg *config) (err error) {
 		}
 	}()
 
-	rand.Seed(cfg.seed)
+	if cfg.bench == "preloaded-query" {
+		n := cfg.preload
+		if n == 0 {
+			n = 1000000
+			if cfg.short {
+				n = 10000
+			}
+		}
+		if err := preload(cfg, n); err != nil {
+			return err
+		}
+	}
+
 	opts := []driver.RunOption{
 		driver.DoPeakRSS(true),
 		driver.DoPeakVM(true),
@@ -348,7 +484,7 @@ func run(cfg *config) (err error) {
 				d.Report("trace-bytes", stopTrace())
 			}()
 		}
-		return runBenchmark(d, cfg.host, cfg.port, cfg.serverProcs, iters)
+		return runBenchmark(d, cfg.host, cfg.port, cfg.serverProcs, iters, cfg.seed, reqs, percentiles)
 	}, opts...)
 }
 
@@ -358,9 +494,17 @@ func main() {
 		fmt.Fprintf(os.Stderr, "error: unexpected args\n")
 		os.Exit(1)
 	}
+	if cliCfg.bench != "query-load" && cliCfg.bench != "preloaded-query" {
+		fmt.Fprintf(os.Stderr, "error: unknown benchmark %q\n", cliCfg.bench)
+		os.Exit(1)
+	}
 	for _, typ := range diagnostics.Types() {
 		cliCfg.isProfiling = cliCfg.isProfiling || driver.DiagnosticEnabled(typ)
 	}
+	if cliCfg.seed == 0 {
+		cliCfg.seed = time.Now().UnixNano()
+		fmt.Fprintf(os.Stderr, "using seed %d; pass -seed %d to reproduce\n", cliCfg.seed, cliCfg.seed)
+	}
 	if err := run(&cliCfg); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -5,84 +5,163 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/benchmarks/sweet/common"
 	"golang.org/x/benchmarks/sweet/common/fileutil"
 	"golang.org/x/benchmarks/sweet/common/log"
 	"golang.org/x/benchmarks/sweet/generators"
 	"golang.org/x/benchmarks/sweet/harnesses"
+
+	shellquote "github.com/kballard/go-shellquote"
 )
 
-var allBenchmarks = []benchmark{
+// builtinBenchmarks describe the benchmarks whose harnesses are in this
+// repository. Their harnesses are looked up in the harnesses registry.
+var builtinBenchmarks = []benchmark{
+	{
+		name:        "biogo-alignment",
+		description: "Performs Smith-Waterman and Needleman-Wunsch alignment of a set of sequences",
+		generator:   generators.None{},
+	},
 	{
 		name:        "biogo-igor",
 		description: "Reports feature family groupings in pairwise alignment data",
-		harness:     harnesses.BiogoIgor(),
 		generator:   generators.BiogoIgor(),
 	},
 	{
 		name:        "biogo-krishna",
 		description: "Performs pairwise alignment of a target sequence against itself",
-		harness:     harnesses.BiogoKrishna(),
 		generator:   generators.BiogoKrishna(),
 	},
 	{
 		name:        "bleve-index",
 		description: "Indexes a subset of Wikipedia into a search index",
-		harness:     harnesses.BleveIndex(),
 		generator:   generators.BleveIndex(),
 	},
+	{
+		name:        "caddy",
+		description: "HTTP server serving static files (supports -secure)",
+		generator:   generators.None{},
+	},
 	{
 		name:        "cockroachdb",
-		description: "Distributed database",
-		harness:     harnesses.CockroachDB{},
+		description: "Distributed database (supports -secure, -store-mode, -gc-trace and -duration)",
 		generator:   generators.None{},
+		// The short benchmarks take a couple of minutes to run, mostly
+		// starting clusters.
+		// The long benchmarks take about 10 minutes to run.
+		// We set the timeout to 30 minutes to give ample buffer.
+		timeout: 30 * time.Minute,
+		// Measuring from cold makes the first results noisy.
+		warmup: 1,
+		remote: true,
+		// The bazel build and its caches take tens of GiB.
+		minFreeDisk: 25 << 30,
+		checkResult: checkCockroachDBResult,
 	},
 	{
 		name:        "etcd",
 		description: "Distributed key-value store",
-		harness:     harnesses.Etcd{},
 		generator:   generators.None{},
+		checkResult: checkConcurrentResult,
+		// Measuring from cold makes the first results noisy.
+		warmup: 1,
 	},
 	{
 		name:        "go-build",
 		description: "Go build command",
-		harness:     harnesses.GoBuild{},
 		generator:   generators.None{},
+		// Each of the projects built is a full checkout, and the
+		// benchmark builds them from scratch into the build cache.
+		minFreeDisk: 6 << 30,
 	},
 	{
 		name:        "gopher-lua",
-		description: "Runs a k-nucleotide benchmark written in Lua on a Go-based Lua VM",
-		harness:     harnesses.GopherLua(),
+		description: "Runs a k-nucleotide benchmark and a GC stress test written in Lua on a Go-based Lua VM",
 		generator:   generators.GopherLua(),
 	},
 	{
 		name:        "gvisor",
 		description: "Container runtime sandbox for Linux (requires root)",
-		harness:     harnesses.GVisor{},
 		generator:   generators.GVisor{},
+		checkResult: checkConcurrentResult,
+	},
+	{
+		name:        "grpc",
+		description: "gRPC unary and bidirectional streaming echo calls (supports -bench-args)",
+		generator:   generators.None{},
+	},
+	{
+		name:        "kube-apiserver",
+		description: "Kubernetes API server creating, listing, and watching objects stored in etcd",
+		generator:   generators.None{},
+		// Startup alone can take a minute on a slow machine, on top of
+		// a workload of thousands of writes. Give it ample buffer.
+		timeout: 20 * time.Minute,
+		// The kubernetes checkout alone takes several GiB to build.
+		minFreeDisk: 6 << 30,
 	},
 	{
 		name:        "markdown",
-		description: "Renders a corpus of markdown documents to XHTML",
-		harness:     harnesses.Markdown(),
+		description: "Renders a corpus of markdown documents to XHTML, both as is and scaled up to a large corpus",
 		generator:   generators.Markdown(),
 	},
+	{
+		name:        "nats",
+		description: "Message broker delivering published messages to subscribers",
+		generator:   generators.None{},
+	},
+	{
+		name:        "prometheus",
+		description: "Time-series database ingesting remote-written samples",
+		generator:   generators.None{},
+	},
 	{
 		name:        "tile38",
 		description: "Redis-like geospatial database and geofencing server",
-		harness:     harnesses.Tile38{},
 		generator:   generators.Tile38{},
+		checkResult: checkConcurrentResult,
 	},
 }
 
+// allBenchmarks are the benchmarks of every registered harness, sorted
+// by name. Benchmarks registered from outside this repository, which
+// builtinBenchmarks doesn't describe, get the defaults.
+var allBenchmarks = func() []benchmark {
+	builtin := make(map[string]benchmark)
+	for _, b := range builtinBenchmarks {
+		builtin[b.name] = b
+	}
+	var all []benchmark
+	for _, name := range harnesses.Names() {
+		h := harnesses.Lookup(name)
+		b, ok := builtin[name]
+		if !ok {
+			b = benchmark{name: name, generator: generators.None{}}
+			if d, ok := h.(interface{ Description() string }); ok {
+				b.description = d.Description()
+			}
+		}
+		b.harness = h
+		all = append(all, b)
+	}
+	return all
+}()
+
 var allBenchmarksMap = func() map[string]*benchmark {
 	m := make(map[string]*benchmark)
 	for i := range allBenchmarks {
@@ -131,35 +210,369 @@ func copyDirContents(dst, src string) error {
 	return fileutil.CopyDir(dst, src, nil)
 }
 
+// rmDirContents removes everything in dir, leaving dir itself in place.
+// It attempts to remove every entry even if some fail, and returns an
+// error listing each path that couldn't be removed. It also returns an
+// error if dir isn't empty afterwards, e.g. because a process that is
+// still running created new files in it.
 func rmDirContents(dir string) error {
 	log.CommandPrintf("rm -rf %s/*", dir)
 	fs, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
+	var errs []error
 	for _, fi := range fs {
 		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
-			return err
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove contents of %s:\n%w", dir, err)
+	}
+	fs, err = os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(fs) != 0 {
+		names := make([]string, 0, len(fs))
+		for _, fi := range fs {
+			names = append(names, fi.Name())
 		}
+		return fmt.Errorf("%s is not empty after removing its contents: %s", dir, strings.Join(names, ", "))
 	}
 	return nil
 }
 
+// phaseTime is how long one of the phases of a benchmark took.
+type phaseTime struct {
+	wall time.Duration
+
+	// cpu is the user and system CPU time of the processes the phase
+	// ran, if hasCPU. Daemons they started, like bazel's server, aren't
+	// counted.
+	cpu    time.Duration
+	hasCPU bool
+}
+
+func (t phaseTime) String() string {
+	if !t.hasCPU {
+		return t.wall.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%s (%s CPU)", t.wall.Round(time.Millisecond), t.cpu.Round(time.Millisecond))
+}
+
+// metrics returns t as the metrics of a Result.
+func (t phaseTime) metrics() map[string]float64 {
+	m := map[string]float64{"sec": t.wall.Seconds()}
+	if t.hasCPU {
+		m["cpu-sec"] = t.cpu.Seconds()
+	}
+	return m
+}
+
+// timePhase calls f, one of the phases of a benchmark, like Harness.Get,
+// Harness.Build or Harness.Run, and returns how long it took. The CPU
+// time of the processes it runs is only measured if exclusive, since
+// that of other processes sweet runs at the same time would be counted
+// too. The phase is bracketed by an EventPhaseStart and EventPhaseEnd
+// like e, which identifies it.
+func (r *runCfg) timePhase(e common.Event, exclusive bool, f func() error) (phaseTime, error) {
+	e.Type = common.EventPhaseStart
+	r.writeEvent(e)
+	cpu, hasCPU := childrenCPUTime()
+	start := time.Now()
+	err := f()
+	t := phaseTime{wall: time.Since(start)}
+	if end, ok := childrenCPUTime(); exclusive && hasCPU && ok {
+		t.cpu, t.hasCPU = end-cpu, true
+	}
+	e.Type = common.EventPhaseEnd
+	e.Seconds = t.wall.Seconds()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.writeEvent(e)
+	return t, err
+}
+
 type benchmark struct {
 	name        string
 	description string
 	harness     common.Harness
 	generator   common.Generator
+
+	// timeout is the default value for common.RunConfig.Timeout,
+	// used if it is not overridden with -timeout.
+	timeout time.Duration
+
+	// warmup is the default value for common.RunConfig.Warmup, used if
+	// it is not overridden with -warmup. It is ignored in short mode.
+	warmup int
+
+	// remote indicates whether the harness supports running on a remote
+	// host, i.e. common.RunConfig.Remote.
+	remote bool
+
+	// minFreeDisk is the free space the file systems the benchmark is
+	// built on must have before it's built, or zero for
+	// defaultMinFreeDisk. See checkFreeDisk.
+	minFreeDisk uint64
+
+	// checkResult returns an error if the result r, from a run that took
+	// elapsed, can't be right, or is nil for plausibleResult. See
+	// checkResults.
+	checkResult func(r *common.Result, elapsed time.Duration) error
+}
+
+// plausibleResult returns an error if r, from a run that took elapsed,
+// has no iterations, or more ns/op than the run had time for: the time
+// measured across all of r's iterations can't be more than the whole
+// run took.
+func plausibleResult(r *common.Result, elapsed time.Duration) error {
+	if err := checkConcurrentResult(r, elapsed); err != nil {
+		return err
+	}
+	if measured := float64(r.Iterations) * r.NsPerOp; measured > float64(elapsed) {
+		return fmt.Errorf("%d iterations of %v ns/op, more than the %s the run took", r.Iterations, r.NsPerOp, elapsed)
+	}
+	return nil
+}
+
+// checkConcurrentResult returns an error if r has no iterations or
+// negative ns/op. It's plausibleResult without the bound on the time
+// measured, for benchmarks whose ns/op is the latency of an operation
+// averaged over concurrent clients, e.g. tile38, gvisor's http_server
+// and etcd: their iterations times ns/op is about the number of clients
+// times how long the run took.
+func checkConcurrentResult(r *common.Result, _ time.Duration) error {
+	if r.Iterations <= 0 {
+		return fmt.Errorf("%d iterations", r.Iterations)
+	}
+	if r.NsPerOp < 0 {
+		return fmt.Errorf("negative ns/op %v", r.NsPerOp)
+	}
+	return nil
+}
+
+// checkCockroachDBResult is plausibleResult, except that it also checks
+// that each kv benchmark did some work. The wrapper reports the
+// workload's operations rather than ns/op for them, and leaves out
+// metrics that are zero.
+func checkCockroachDBResult(r *common.Result, elapsed time.Duration) error {
+	if err := plausibleResult(r, elapsed); err != nil {
+		return err
+	}
+	if strings.HasPrefix(r.Name, "BenchmarkCockroachDBkv") && r.Metrics["read-ops"] == 0 && r.Metrics["write-ops"] == 0 {
+		return errors.New("no operations")
+	}
+	return nil
+}
+
+// checkResults returns an error if any of the results b wrote to results
+// from offset on, over a run that took elapsed, fails b.checkResult. A
+// broken build can produce a benchmark that runs without error but does
+// no useful work, and results that silently skew comparisons.
+func (b *benchmark) checkResults(results *os.File, offset int64, elapsed time.Duration) error {
+	end, err := results.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	rs, err := common.ReadResults(io.NewSectionReader(results, offset, end-offset))
+	if err != nil {
+		return fmt.Errorf("reading results to check them: %w", err)
+	}
+	check := b.checkResult
+	if check == nil {
+		check = plausibleResult
+	}
+	var errs []error
+	for _, r := range rs {
+		if err := check(r, elapsed); err != nil {
+			errs = append(errs, fmt.Errorf("implausible result %s: %w", r.Name, err))
+		}
+	}
+	if len(errs) != 0 {
+		errs = append(errs, errors.New("the benchmark may be broken; use -check-results=false to skip this check"))
+	}
+	return errors.Join(errs...)
+}
+
+// defaultMinFreeDisk is the free disk space most benchmarks need to be
+// built and run.
+const defaultMinFreeDisk = 2 << 30
+
+// checkFreeDisk returns an error if any of dirs, which b is about to be
+// built in, is on a file system with less than b.minFreeDisk bytes
+// free. Running out of space part way through a long build fails it in
+// confusing ways, after wasting the time it took.
+func (b *benchmark) checkFreeDisk(dirs ...string) error {
+	min := b.minFreeDisk
+	if min == 0 {
+		min = defaultMinFreeDisk
+	}
+	for _, dir := range dirs {
+		free, err := fileutil.FreeSpace(dir)
+		if errors.Is(err, fileutil.ErrFreeSpaceUnsupported) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("checking free disk space for %s: %v", b.name, err)
+		}
+		if free < min {
+			return fmt.Errorf("not enough free disk space to build %s: %s has %s free, but %s needs %s (use -check-disk=false to skip this check)", b.name, dir, formatBytes(free), b.name, formatBytes(min))
+		}
+	}
+	return nil
 }
 
+// formatBytes formats n bytes in GiB, e.g. "1.5GiB".
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+}
+
+// execute fetches, builds and runs b for each of cfgs.
 func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
+	p, err := b.prepare(cfgs, r)
+	if err != nil {
+		return err
+	}
+	return p.run(r)
+}
+
+// errNotPrepared is the error prepareAll returns for benchmarks it didn't
+// prepare because another failed.
+var errNotPrepared = errors.New("not built: another benchmark failed to build")
+
+// prepareAll prepares each of benchmarks for cfgs, up to parallelism of
+// them at a time, and returns the result of preparing each, in order.
+// If stopOnError is set, no more benchmarks are prepared once one fails.
+func prepareAll(benchmarks []*benchmark, cfgs []*common.Config, r *runCfg, parallelism int, stopOnError bool) ([]*preparedBenchmark, []error) {
+	prepared := make([]*preparedBenchmark, len(benchmarks))
+	errs := make([]error, len(benchmarks))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for i, b := range benchmarks {
+		sem <- struct{}{}
+		if stopOnError && failed.Load() {
+			<-sem
+			errs[i] = errNotPrepared
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b *benchmark) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			prepared[i], errs[i] = b.prepare(cfgs, r)
+			if errs[i] != nil {
+				failed.Store(true)
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	return prepared, errs
+}
+
+// preparedBenchmark is a benchmark that's been fetched and built for
+// each of its configurations, ready to run.
+type preparedBenchmark struct {
+	b *benchmark
+
+	// cfgs are the configurations to run the benchmark for, which is
+	// none if a run being resumed already ran it for all of them.
+	cfgs []*common.Config
+	fps  []string
+	bs   *benchmarkState
+
+	// setups are the RunConfigs for each of cfgs, and localAssetsDirs
+	// their assets directories on this machine.
+	setups          []common.RunConfig
+	localAssetsDirs []string
+	hasAssets       bool
+	assetsFSDir     string
+
+	// files are the results files setups write to.
+	files []io.Closer
+}
+
+func (p *preparedBenchmark) close() {
+	for _, f := range p.files {
+		f.Close()
+	}
+	p.files = nil
+}
+
+// prepare fetches and builds b for each of cfgs, and creates the files
+// its results will be written to. It only touches b's own directories,
+// so benchmarks may be prepared concurrently.
+func (b *benchmark) prepare(cfgs []*common.Config, r *runCfg) (_ *preparedBenchmark, err error) {
+	p := &preparedBenchmark{b: b}
+	defer func() {
+		if err != nil {
+			p.close()
+		}
+	}()
+
+	// Skip the benchmark entirely if it already ran for every config.
+	// Its results are already in the results directory.
+	fps := make([]string, len(cfgs))
+	for i, cfg := range cfgs {
+		fp, err := configFingerprint(cfg, r.short, r.static)
+		if err != nil {
+			return nil, err
+		}
+		fps[i] = fp
+	}
+	var bs *benchmarkState
+	if r.state != nil {
+		bs = r.state.benchmark(b.name)
+	}
+	if r.resume && bs != nil && len(cfgs) != 0 {
+		ran := true
+		for i, cfg := range cfgs {
+			if bs.Ran[cfg.Name] != fps[i] {
+				ran = false
+			}
+		}
+		if ran {
+			log.Printf("Skipping benchmark %s: already run (-resume)", b.name)
+			return p, nil
+		}
+	}
+
 	log.Printf("Setting up benchmark: %s", b.name)
 
+	if r.remote.Host != "" {
+		if !b.remote {
+			return nil, fmt.Errorf("%s does not support running on a remote host (-remote-host)", b.name)
+		}
+		if r.dumpCore {
+			return nil, fmt.Errorf("core dumps (-dump-core) are not supported when running on a remote host")
+		}
+		for _, cfg := range cfgs {
+			if !cfg.Diagnostics.Empty() {
+				return nil, fmt.Errorf("config %s: diagnostics are not supported when running on a remote host", cfg.Name)
+			}
+		}
+	}
+
 	// Compute top-level directories for this benchmark to work in.
 	benchDir := filepath.Join(r.benchDir, b.name)
 	topDir := filepath.Join(r.workDir, b.name)
 	srcDir := filepath.Join(topDir, "src")
 
+	if r.checkDisk {
+		dirs := []string{topDir}
+		if r.buildCache != "" {
+			dirs = append(dirs, r.buildCache)
+		}
+		if err := b.checkFreeDisk(dirs...); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check if assets for this benchmark exist. Not all benchmarks have assets!
 	var hasAssets bool
 	assetsFSDir := b.name
@@ -167,42 +580,95 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 		fi, err := f.Stat()
 		if err != nil {
 			f.Close()
-			return err
+			return nil, err
 		}
 		if !fi.IsDir() {
 			f.Close()
-			return fmt.Errorf("found assets file for %s instead of directory", b.name)
+			return nil, fmt.Errorf("found assets file for %s instead of directory", b.name)
 		}
 		f.Close()
 		hasAssets = true
 	} else if !errors.Is(err, fs.ErrNotExist) {
-		return err
+		return nil, err
 	}
 
-	// Retrieve the benchmark's source, if needed. If execute is called
+	// Retrieve the benchmark's source, if needed. If prepare is called
 	// multiple times, this will already be done.
-	_, err := os.Stat(srcDir)
-	if os.IsNotExist(err) {
+	//
+	// When resuming, source left behind by a fetch that never completed
+	// is fetched again from scratch.
+	var getDuration phaseTime
+	var getStats *common.GetStats
+	_, err = os.Stat(srcDir)
+	if err == nil && r.resume && bs != nil && !bs.Got {
+		log.CommandPrintf("rm -rf %s", srcDir)
+		if err := os.RemoveAll(srcDir); err != nil {
+			return nil, fmt.Errorf("removing incomplete source for %s: %v", b.name, err)
+		}
+		err = fs.ErrNotExist
+	}
+	if errors.Is(err, fs.ErrNotExist) {
 		gcfg := &common.GetConfig{
-			SrcDir: srcDir,
-			Short:  r.short,
+			SrcDir:   srcDir,
+			Short:    r.short,
+			Retries:  r.getRetries,
+			LocalSrc: r.localSrc[b.name],
+
+			ExpectedTreeHash: r.treeHashes[b.name],
+			MirrorBase:       r.gitMirror,
+			Commit:           r.commits[b.name],
+			Shallow:          r.shallow,
+			Stats:            &common.GetStats{},
+		}
+		d, err := r.timePhase(common.Event{Benchmark: b.name, Phase: "get"}, r.buildParallelism <= 1, func() error { return b.harness.Get(gcfg) })
+		if err != nil {
+			return nil, fmt.Errorf("retrieving source for %s: %v", b.name, err)
+		}
+		log.Printf("Retrieved source for %s in %s", b.name, d)
+		for _, f := range gcfg.Stats.Fetches {
+			what := f.URL
+			if f.Path != "" {
+				what = fmt.Sprintf("submodule %s (%s)", f.Path, f.URL)
+			}
+			log.Infof("  fetched %s: %.1f MiB in %.1fs", what, float64(f.Bytes)/(1<<20), f.Seconds)
 		}
-		if err := b.harness.Get(gcfg); err != nil {
-			return fmt.Errorf("retrieving source for %s: %v", b.name, err)
+		getDuration = d
+		if len(gcfg.Stats.Fetches) != 0 {
+			getStats = gcfg.Stats
+		}
+		if bs != nil {
+			// New source invalidates everything built from the old.
+			err := r.updateState(func() {
+				*bs = benchmarkState{
+					Got:   true,
+					Built: make(map[string]string),
+					Ran:   make(map[string]string),
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	// Create the results directory for the benchmark.
 	resultsDir := r.benchmarkResultsDir(b)
 	if err := mkdirAll(resultsDir); err != nil {
-		return fmt.Errorf("creating results directory for %s: %v", b.name, err)
+		return nil, fmt.Errorf("creating results directory for %s: %v", b.name, err)
 	}
 
 	// Perform a setup step for each config for the benchmark.
 	setups := make([]common.RunConfig, 0, len(cfgs))
-	for _, pcfg := range cfgs {
+	// localAssetsDirs are the assets directories of each setup on this
+	// machine, which are only the same as the setup's when running locally.
+	localAssetsDirs := make([]string, 0, len(cfgs))
+	for ci, pcfg := range cfgs {
 		// Local copy for per-benchmark environment adjustments.
 		cfg := pcfg.Copy()
+		out := r.configOutput(b, cfg)
+		if err := mkdirAll(filepath.Dir(out.results)); err != nil {
+			return nil, fmt.Errorf("create %s results directory for %s: %v", b.name, cfg.Name, err)
+		}
 
 		// Create directory hierarchy for benchmarks.
 		workDir := filepath.Join(topDir, cfg.Name)
@@ -210,24 +676,24 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 		tmpDir := filepath.Join(workDir, "tmp")
 		assetsDir := filepath.Join(workDir, "assets")
 		if err := mkdirAll(binDir); err != nil {
-			return fmt.Errorf("create %s bin for %s: %v", b.name, cfg.Name, err)
+			return nil, fmt.Errorf("create %s bin for %s: %v", b.name, cfg.Name, err)
 		}
 		if err := mkdirAll(srcDir); err != nil {
-			return fmt.Errorf("create %s src for %s: %v", b.name, cfg.Name, err)
+			return nil, fmt.Errorf("create %s src for %s: %v", b.name, cfg.Name, err)
 		}
 		if err := mkdirAll(tmpDir); err != nil {
-			return fmt.Errorf("create %s tmp for %s: %v", b.name, cfg.Name, err)
+			return nil, fmt.Errorf("create %s tmp for %s: %v", b.name, cfg.Name, err)
 		}
 		if hasAssets {
 			if err := mkdirAll(assetsDir); err != nil {
-				return fmt.Errorf("create %s assets dir for %s: %v", b.name, cfg.Name, err)
+				return nil, fmt.Errorf("create %s assets dir for %s: %v", b.name, cfg.Name, err)
 			}
 		}
 
 		// Add PGO if profile specified for this benchmark, otherwise
 		// explicitly disable it to avoid default.pgo files.
-		pgo, ok := cfg.PGOFiles[b.name]
-		if !ok {
+		pgo, hasPGO := cfg.PGOFiles[b.name]
+		if !hasPGO {
 			pgo = "off"
 		}
 		goflags, ok := cfg.BuildEnv.Lookup("GOFLAGS")
@@ -243,9 +709,48 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 			SrcDir:   srcDir,
 			BenchDir: benchDir,
 			Short:    r.short,
+			CacheDir: r.buildCache,
+			Timeout:  r.buildTimeout,
+			Static:   r.static,
+			Ctx:      r.ctx,
+
+			ForceCDeps:   r.forceCDeps,
+			GoExperiment: cfg.GoExperiment,
+			GoFlags:      cfg.GoFlags,
 		}
-		if err := b.harness.Build(cfg, &bcfg); err != nil {
-			return fmt.Errorf("build %s for %s: %v", b.name, cfg.Name, err)
+		if hasPGO {
+			bcfg.PGOProfile = pgo
+		}
+		var buildDuration phaseTime
+		if r.resume && bs != nil && bs.Built[cfg.Name] == fps[ci] {
+			log.Printf("Skipping build of %s for %s: already built (-resume)", b.name, cfg.Name)
+		} else {
+			// Stream output from the build to a log in the results
+			// directory, and to the activity log so long builds still
+			// show progress.
+			buildLog, err := os.Create(out.buildLog)
+			if err != nil {
+				return nil, fmt.Errorf("create %s build log for %s: %v", b.name, cfg.Name, err)
+			}
+			activity := log.ActivityWriter(b.name)
+			bcfg.Output = io.MultiWriter(buildLog, activity)
+			d, err := r.timePhase(common.Event{Benchmark: b.name, Config: cfg.Name, Phase: "build"}, r.buildParallelism <= 1, func() error { return b.harness.Build(cfg, &bcfg) })
+			activity.Flush()
+			buildLog.Close()
+			if err != nil {
+				return nil, fmt.Errorf("build %s for %s: %v", b.name, cfg.Name, err)
+			}
+			log.Printf("Built %s for %s in %s", b.name, cfg.Name, d)
+			buildDuration = d
+			if bs != nil {
+				err := r.updateState(func() {
+					bs.Built[cfg.Name] = fps[ci]
+					delete(bs.Ran, cfg.Name)
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 
 		// Generate any args to funnel through to benchmarks.
@@ -265,7 +770,7 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 		}
 		if !cfg.Diagnostics.Empty() {
 			// Create a directory for any profile files to live in.
-			resultsProfilesDir := r.runProfilesDir(b, cfg)
+			resultsProfilesDir := out.diagnostics
 			mkdirAll(resultsProfilesDir)
 
 			// We need to pass arguments to the benchmark binary to generate
@@ -274,31 +779,294 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 				args = append(args, d.DriverArgs(resultsProfilesDir)...)
 			}
 		}
+		// Pass through any extra arguments for this benchmark. These were
+		// validated when parsing flags.
+		extraArgs, err := shellquote.Split(r.benchArgs[b.name])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, extraArgs...)
 
-		results, err := os.Create(filepath.Join(resultsDir, fmt.Sprintf("%s.results", cfg.Name)))
+		timeout := b.timeout
+		if r.duration != 0 {
+			// The default timeouts are sized for how long the benchmarks
+			// usually run, not for however long they're asked to.
+			timeout = 0
+		}
+		if r.timeout.set {
+			timeout = r.timeout.d
+		}
+		warmup := b.warmup
+		if r.short {
+			warmup = 0
+		}
+		if r.warmup >= 0 {
+			warmup = r.warmup
+		}
+		var container *common.ContainerSpec
+		if r.container.Image != "" {
+			spec := r.container
+			container = &spec
+		}
+		var cgroup *common.CgroupSpec
+		if r.cgroup.MemoryMax != "" || r.cgroup.CPUMax != "" {
+			spec := r.cgroup
+			cgroup = &spec
+		}
+		var traceDir string
+		if r.traceDir != "" {
+			traceDir = filepath.Join(r.traceDir, b.name, cfg.Name)
+		}
+		var failureDir string
+		if r.failureDir != "" {
+			failureDir = filepath.Join(r.failureDir, b.name, cfg.Name)
+		}
+		var artifactDir string
+		if r.artifactDir != "" {
+			artifactDir = filepath.Join(r.artifactDir, b.name, cfg.Name)
+		}
+		var pgoProfile string
+		if r.generatePGO {
+			// Harnesses that support it merge into any existing profile,
+			// so start from scratch.
+			pgoProfile = r.pgoProfilePath(b, cfg)
+			if err := mkdirAll(filepath.Dir(pgoProfile)); err != nil {
+				return nil, err
+			}
+			if err := os.Remove(pgoProfile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+
+		// Record the environment the results are produced in, so they
+		// describe themselves when archived.
+		manifest, err := os.Create(out.manifest)
+		if err != nil {
+			return nil, fmt.Errorf("create %s manifest for %s: %v", b.name, cfg.Name, err)
+		}
+		err = cfg.WriteManifest(manifest, getStats)
+		manifest.Close()
 		if err != nil {
-			return fmt.Errorf("create %s results file for %s: %v", b.name, cfg.Name, err)
+			return nil, fmt.Errorf("write %s manifest for %s: %v", b.name, cfg.Name, err)
+		}
+
+		results, err := os.Create(out.results)
+		if err != nil {
+			return nil, fmt.Errorf("create %s results file for %s: %v", b.name, cfg.Name, err)
+		}
+		p.files = append(p.files, results)
+		if !r.profileOnly {
+			header := common.ResultsHeader{
+				Schema:     common.ResultsSchemaVersion,
+				Benchmark:  b.name,
+				Config:     cfg.Name,
+				ConfigHash: fps[ci],
+			}
+			if l, ok := b.harness.(common.MetricLister); ok {
+				header.Metrics = l.Metrics()
+			}
+			if err := header.Write(results); err != nil {
+				return nil, fmt.Errorf("write %s results header for %s: %v", b.name, cfg.Name, err)
+			}
+		}
+		var jsonResults io.Writer
+		if r.jsonResults {
+			f, err := os.Create(out.jsonResults)
+			if err != nil {
+				return nil, fmt.Errorf("create %s JSON results file for %s: %v", b.name, cfg.Name, err)
+			}
+			p.files = append(p.files, f)
+			jsonResults = f
+
+			// Record how long it took to get here, so build time
+			// regressions can be tracked alongside the results. Phases
+			// that were skipped have no duration.
+			enc := json.NewEncoder(f)
+			for _, p := range []struct {
+				name string
+				d    phaseTime
+			}{{"GetDuration", getDuration}, {"BuildDuration", buildDuration}} {
+				if p.d.wall == 0 {
+					continue
+				}
+				if err := enc.Encode(common.Result{
+					Benchmark:  b.name,
+					Config:     cfg.Name,
+					Name:       p.name,
+					Iterations: 1,
+					Metrics:    p.d.metrics(),
+				}); err != nil {
+					return nil, fmt.Errorf("write %s JSON results for %s: %v", b.name, cfg.Name, err)
+				}
+			}
+			// Break down the fetching of source, e.g. into
+			// GetFetch and GetFetch/<submodule>.
+			if getStats != nil {
+				for _, fetch := range getStats.Fetches {
+					name := "GetFetch"
+					if fetch.Path != "" {
+						name += "/" + fetch.Path
+					}
+					if err := enc.Encode(common.Result{
+						Benchmark:  b.name,
+						Config:     cfg.Name,
+						Name:       name,
+						Iterations: 1,
+						Metrics:    map[string]float64{"sec": fetch.Seconds, "bytes": float64(fetch.Bytes)},
+					}); err != nil {
+						return nil, fmt.Errorf("write %s JSON results for %s: %v", b.name, cfg.Name, err)
+					}
+				}
+			}
+		}
+		// If running on a remote host, ship the binaries there, to a
+		// directory laid out like the local one.
+		runBinDir, runTmpDir, runAssetsDir := binDir, tmpDir, assetsDir
+		var remote *common.RemoteSpec
+		if r.remote.Host != "" {
+			spec := r.remote
+			remote = &spec
+			remoteDir := path.Join(remote.WorkDir, b.name, cfg.Name)
+			runBinDir = path.Join(remoteDir, "bin")
+			runTmpDir = path.Join(remoteDir, "tmp")
+			runAssetsDir = path.Join(remoteDir, "assets")
+			if err := remote.Sync(binDir, runBinDir); err != nil {
+				return nil, fmt.Errorf("copy %s binaries for %s: %v", b.name, cfg.Name, err)
+			}
+			if err := remote.MkdirAll(runTmpDir); err != nil {
+				return nil, fmt.Errorf("create %s tmp for %s: %v", b.name, cfg.Name, err)
+			}
 		}
-		defer results.Close()
+		var teeResults io.Writer
+		if r.teeResults {
+			teeResults = os.Stdout
+		}
+		var hooks []common.RunHook
+		if r.hookCmd != "" {
+			// Validated when parsing flags.
+			hookArgs, _ := shellquote.Split(r.hookCmd)
+			hooks = append(hooks, &common.CommandHook{
+				Path:   hookArgs[0],
+				Args:   hookArgs[1:],
+				Output: results,
+			})
+		}
+		localAssetsDirs = append(localAssetsDirs, assetsDir)
 		setups = append(setups, common.RunConfig{
-			BinDir:    binDir,
-			TmpDir:    tmpDir,
-			AssetsDir: assetsDir,
-			Args:      args,
-			Results:   results,
-			Short:     r.short,
+			BinDir:      runBinDir,
+			TmpDir:      runTmpDir,
+			AssetsDir:   runAssetsDir,
+			Args:        args,
+			Results:     results,
+			JSONResults: jsonResults,
+			Benchmark:   b.name,
+			Short:       r.short,
+			Smoke:       r.smoke,
+			Timeout:     timeout,
+			Warmup:      warmup,
+			ProfileDir:  out.profiles,
+			TraceDir:    traceDir,
+			FailureDir:  failureDir,
+			PGOProfile:  pgoProfile,
+			BenchFilter: r.benchFilter,
+			MemLimits:   r.memLimits,
+			GOGCValues:  r.gogcs,
+			Secure:      r.secure,
+			PerfStat:    r.perfStat,
+			CPUList:     r.cpuList,
+			Container:   container,
+			Cgroup:      cgroup,
+			Remote:      remote,
+
+			GOMAXPROCSValues: r.gomaxprocs,
+			NodeCounts:       r.nodeCounts,
+			ReadPercents:     r.readPercents,
+			Concurrency:      r.concurrency,
+			ReadyTimeout:     r.readyTimeout,
+			TimeoutGrace:     r.timeoutGrace,
+			Seed:             r.seed,
+			KeepTmp:          r.keepTmp,
+			ArtifactDir:      artifactDir,
+			Hooks:            hooks,
+			Ctx:              r.ctx,
+
+			MutexProfileFraction: r.mutexProfileFraction,
+			BlockProfileRate:     r.blockProfileRate,
+			ProfileSummary:       r.profileSummary,
+			TeeResults:           teeResults,
+			EventsWriter:         r.events,
+			StoreMode:            r.storeMode,
+			GCTrace:              r.gcTrace,
+			Force:                r.force,
+			RuntimeMetrics:       r.runtimeMetrics,
+			Duration:             r.duration,
 		})
 	}
+	p.cfgs = cfgs
+	p.fps = fps
+	p.bs = bs
+	p.setups = setups
+	p.localAssetsDirs = localAssetsDirs
+	p.hasAssets = hasAssets
+	p.assetsFSDir = assetsFSDir
+	return p, nil
+}
+
+// cleanInterruptedTmp empties the tmp directory of an interrupted run.
+func cleanInterruptedTmp(setup *common.RunConfig) error {
+	if setup.Remote != nil {
+		return setup.Remote.RmDirContents(setup.TmpDir)
+	}
+	return rmDirContents(setup.TmpDir)
+}
+
+// run runs the prepared benchmark for each of its configurations, in
+// turn, r.count times.
+func (p *preparedBenchmark) run(r *runCfg) error {
+	defer p.close()
+	b, cfgs, setups := p.b, p.cfgs, p.setups
+	localAssetsDirs, hasAssets, assetsFSDir := p.localAssetsDirs, p.hasAssets, p.assetsFSDir
+
+	// Keep each configuration's tmp directory in memory for the runs, if
+	// asked to. Without the privileges to mount a tmpfs, the runs go
+	// ahead on disk.
+	if r.tmpfsSizeMB != 0 {
+		for i := range setups {
+			dir := setups[i].TmpDir
+			if cfgs[i].DryRun {
+				log.DryRunPrintf("mount -t tmpfs -o nosuid,nodev,size=%dm tmpfs %s", r.tmpfsSizeMB, dir)
+				continue
+			}
+			if err := mountTmpfs(dir, r.tmpfsSizeMB); err != nil {
+				log.Warnf("failed to mount a tmpfs on %s, running %s for %s on disk instead: %v", dir, b.name, cfgs[i].Name, err)
+				continue
+			}
+			setups[i].TmpfsSizeMB = r.tmpfsSizeMB
+			defer func() {
+				if err := unmountTmpfs(dir); err != nil {
+					log.Warnf("failed to unmount the tmpfs on %s: %v", dir, err)
+				}
+			}()
+		}
+	}
 
 	for j := 0; j < r.count; j++ {
 		// Execute the benchmark for each configuration.
 		for i, setup := range setups {
+			if r.interrupted() {
+				return fmt.Errorf("run benchmark %s for config %s: interrupted", b.name, cfgs[i].Name)
+			}
 			if hasAssets {
 				// Set up assets directory for test run.
-				r.logCopyDirCommand(b.name, setup.AssetsDir)
-				if err := fileutil.CopyDir(setup.AssetsDir, assetsFSDir, r.assetsFS); err != nil {
+				r.logCopyDirCommand(b.name, localAssetsDirs[i])
+				if err := fileutil.CopyDir(localAssetsDirs[i], assetsFSDir, r.assetsFS); err != nil {
 					return err
 				}
+				if setup.Remote != nil {
+					if err := setup.Remote.Sync(localAssetsDirs[i], setup.AssetsDir); err != nil {
+						return err
+					}
+				}
 			}
 
 			log.Printf("Running benchmark %s for %s: run %d", b.name, cfgs[i].Name, j+1)
@@ -308,24 +1076,80 @@ func (b *benchmark) execute(cfgs []*common.Config, r *runCfg) error {
 			// run so that the suite's GC doesn't start blasting on all Ps,
 			// introducing undue noise into the experiments.
 			gogc := debug.SetGCPercent(-1)
-			if err := b.harness.Run(cfgs[i], &setup); err != nil {
+			// Note where this run's results start and when it started, to
+			// check them afterwards.
+			offset, err := setup.Results.Seek(0, io.SeekCurrent)
+			if err != nil {
+				debug.SetGCPercent(gogc)
+				return err
+			}
+			start := time.Now()
+			d, err := r.timePhase(common.Event{Benchmark: b.name, Config: cfgs[i].Name, Phase: "run"}, true, func() error { return b.harness.Run(cfgs[i], &setup) })
+			if err != nil {
 				debug.SetGCPercent(gogc)
 				setup.Results.Close()
-				return fmt.Errorf("run benchmark %s for config %s: %v", b.name, cfgs[i].Name, err)
+				err = fmt.Errorf("run benchmark %s for config %s: %v", b.name, cfgs[i].Name, err)
+				if r.interrupted() {
+					// The harness has killed the benchmark, so nothing's
+					// using tmp anymore, and partial runs aren't worth
+					// keeping.
+					log.Printf("Interrupted, cleaning up %s", setup.TmpDir)
+					return errors.Join(err, cleanInterruptedTmp(&setup))
+				}
+				return err
 			}
 			debug.SetGCPercent(gogc)
+			log.Printf("Ran %s for %s in %s", b.name, cfgs[i].Name, d)
+			if setup.JSONResults != nil {
+				err := json.NewEncoder(setup.JSONResults).Encode(common.Result{
+					Benchmark:  b.name,
+					Config:     cfgs[i].Name,
+					Name:       "RunDuration",
+					Iterations: 1,
+					Metrics:    d.metrics(),
+				})
+				if err != nil {
+					return fmt.Errorf("write %s JSON results for %s: %v", b.name, cfgs[i].Name, err)
+				}
+			}
+			if r.checkResults && !cfgs[i].DryRun {
+				if err := b.checkResults(setup.Results, offset, time.Since(start)); err != nil {
+					setup.Results.Close()
+					return fmt.Errorf("run benchmark %s for config %s: %w", b.name, cfgs[i].Name, err)
+				}
+			}
 
 			// Clean up tmp directory so benchmarks may assume it's empty.
-			if err := rmDirContents(setup.TmpDir); err != nil {
+			if setup.Remote != nil {
+				if err := setup.Remote.RmDirContents(setup.TmpDir); err != nil {
+					return err
+				}
+			} else if setup.KeepTmp {
+				if err := setup.SaveTmp(); err != nil {
+					return err
+				}
+			} else if err := rmDirContents(setup.TmpDir); err != nil {
 				return err
 			}
 			if hasAssets {
 				// Clean up assets directory just in case any of the files were written to.
-				if err := rmDirContents(setup.AssetsDir); err != nil {
+				if err := rmDirContents(localAssetsDirs[i]); err != nil {
 					return err
 				}
+				if setup.Remote != nil {
+					if err := setup.Remote.RmDirContents(setup.AssetsDir); err != nil {
+						return err
+					}
+				}
 			}
 		}
 	}
+	if p.bs != nil {
+		return r.updateState(func() {
+			for i, cfg := range cfgs {
+				p.bs.Ran[cfg.Name] = p.fps[i]
+			}
+		})
+	}
 	return nil
 }
@@ -0,0 +1,101 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestCheckResult(t *testing.T) {
+	kv := func(metrics map[string]float64) *common.Result {
+		return &common.Result{Name: "BenchmarkCockroachDBkv0/nodes=1-8", Iterations: 1, Metrics: metrics}
+	}
+	for _, tc := range []struct {
+		name    string
+		check   func(*common.Result, time.Duration) error
+		r       *common.Result
+		elapsed time.Duration
+		ok      bool
+	}{
+		{
+			name:    "plausible",
+			check:   plausibleResult,
+			r:       &common.Result{Name: "BenchmarkBleveIndexBatch100", Iterations: 10, NsPerOp: 1e9},
+			elapsed: 15 * time.Second,
+			ok:      true,
+		},
+		{
+			name:    "no iterations",
+			check:   plausibleResult,
+			r:       &common.Result{Name: "BenchmarkBleveIndexBatch100", NsPerOp: 1e9},
+			elapsed: 15 * time.Second,
+		},
+		{
+			name:    "negative ns/op",
+			check:   plausibleResult,
+			r:       &common.Result{Name: "BenchmarkBleveIndexBatch100", Iterations: 10, NsPerOp: -1},
+			elapsed: 15 * time.Second,
+		},
+		{
+			name:    "longer than the run",
+			check:   plausibleResult,
+			r:       &common.Result{Name: "BenchmarkBleveIndexBatch100", Iterations: 10, NsPerOp: 2e9},
+			elapsed: 15 * time.Second,
+		},
+		{
+			// tile38 reports the latency of each request, averaged over
+			// its clients, which adds up to more than the run took.
+			name:    "concurrent",
+			check:   checkConcurrentResult,
+			r:       &common.Result{Name: "BenchmarkTile38QueryLoad", Iterations: 1000, NsPerOp: 80e6},
+			elapsed: 15 * time.Second,
+			ok:      true,
+		},
+		{
+			name:    "concurrent checked as sequential",
+			check:   plausibleResult,
+			r:       &common.Result{Name: "BenchmarkTile38QueryLoad", Iterations: 1000, NsPerOp: 80e6},
+			elapsed: 15 * time.Second,
+		},
+		{
+			name:    "concurrent without iterations",
+			check:   checkConcurrentResult,
+			r:       &common.Result{Name: "BenchmarkTile38QueryLoad", NsPerOp: 80e6},
+			elapsed: 15 * time.Second,
+		},
+		{
+			name:    "cockroachdb",
+			check:   checkCockroachDBResult,
+			r:       kv(map[string]float64{"write-ops": 150000}),
+			elapsed: time.Minute,
+			ok:      true,
+		},
+		{
+			name:    "cockroachdb without operations",
+			check:   checkCockroachDBResult,
+			r:       kv(map[string]float64{"peak-RSS-bytes": 1 << 30}),
+			elapsed: time.Minute,
+		},
+		{
+			name:    "cockroachdb startup",
+			check:   checkCockroachDBResult,
+			r:       &common.Result{Name: "BenchmarkCockroachDBClusterStartup/nodes=1-8", Iterations: 1, NsPerOp: 5e9},
+			elapsed: time.Minute,
+			ok:      true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.check(tc.r, tc.elapsed)
+			if tc.ok && err != nil {
+				t.Errorf("got error %v for %+v, expected none", err, tc.r)
+			} else if !tc.ok && err == nil {
+				t.Errorf("got no error for %+v, expected one", tc.r)
+			}
+		})
+	}
+}
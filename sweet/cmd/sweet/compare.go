@@ -0,0 +1,471 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+const (
+	compareUsage = `Compares two sets of benchmark results, reporting the change
+in each metric and whether it's statistically significant.
+
+Each of old and new is either a results file or a results
+directory, as written by "sweet run" to -results or -output-dir.
+Directories are compared file by file, pairing up the results
+files with the same path relative to each directory.
+
+Results are grouped by harness and then by the configuration
+suffix of their names, e.g. "/nodes=3". Every metric is compared,
+including the custom ones harnesses report. A change is reported
+as "~" if its p-value, from a Mann-Whitney U test over the runs
+of each benchmark, isn't below -alpha. Use -count with "sweet run"
+to get enough runs for a change to be significant.
+
+Usage: %s compare [flags] <old> <new>
+`
+)
+
+type compareCmd struct {
+	alpha float64
+}
+
+func (*compareCmd) Name() string { return "compare" }
+func (*compareCmd) Synopsis() string {
+	return "Compares two sets of benchmark results."
+}
+func (*compareCmd) PrintUsage(w io.Writer, base string) {
+	fmt.Fprintf(w, compareUsage, base)
+}
+
+func (c *compareCmd) SetFlags(f *flag.FlagSet) {
+	f.Float64Var(&c.alpha, "alpha", 0.05, "the significance level below which a p-value indicates a change")
+}
+
+func (c *compareCmd) Run(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected two sets of results to compare, got %d arguments", len(args))
+	}
+	if c.alpha <= 0 || c.alpha >= 1 {
+		return fmt.Errorf("-alpha must be between 0 and 1, got %v", c.alpha)
+	}
+	pairs, err := pairResultsFiles(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	var groups []*compareGroup
+	for _, p := range pairs {
+		old, err := readResultsFile(p.old)
+		if err != nil {
+			return err
+		}
+		new, err := readResultsFile(p.new)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, compareResults(p.harness, old, new)...)
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("no benchmarks in common between %s and %s", args[0], args[1])
+	}
+	return printComparison(os.Stdout, groups, c.alpha)
+}
+
+// resultsPair is a pair of results files to compare.
+type resultsPair struct {
+	// harness is the name of the harness that produced the results,
+	// taken from the name of the directory containing them.
+	harness  string
+	old, new string
+}
+
+// pairResultsFiles returns the results files to compare for old and new,
+// which must either both be files or both be directories.
+func pairResultsFiles(old, new string) ([]resultsPair, error) {
+	oldInfo, err := os.Stat(old)
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := os.Stat(new)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo.IsDir() != newInfo.IsDir() {
+		return nil, fmt.Errorf("%s and %s must both be results files or both be results directories", old, new)
+	}
+	if !oldInfo.IsDir() {
+		harness, err := harnessOf(old)
+		if err != nil {
+			return nil, err
+		}
+		return []resultsPair{{harness: harness, old: old, new: new}}, nil
+	}
+	oldFiles, _, err := findResultsFiles(old)
+	if err != nil {
+		return nil, err
+	}
+	_, newFiles, err := findResultsFiles(new)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []resultsPair
+	for _, rel := range oldFiles {
+		if !newFiles[rel] {
+			continue
+		}
+		harness, err := harnessOf(filepath.Join(old, rel))
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, resultsPair{
+			harness: harness,
+			old:     filepath.Join(old, rel),
+			new:     filepath.Join(new, rel),
+		})
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no results files in common between %s and %s", old, new)
+	}
+	return pairs, nil
+}
+
+// findResultsFiles returns the paths, relative to dir, of the results
+// files under dir, in order. It also returns them as a set.
+func findResultsFiles(dir string) (files []string, set map[string]bool, err error) {
+	set = make(map[string]bool)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isResultsFile(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		set[rel] = true
+		return nil
+	})
+	return files, set, err
+}
+
+// isResultsFile reports whether path is a results file in the layout of
+// either -results or -output-dir.
+func isResultsFile(path string) bool {
+	return strings.HasSuffix(path, ".results") || filepath.Base(path) == "results.txt"
+}
+
+// harnessOf returns the name of the harness that produced the results
+// file at path, which is the name of the directory containing it, or
+// of its parent in the -output-dir layout.
+func harnessOf(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(abs)
+	if filepath.Base(abs) == "results.txt" {
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Base(dir), nil
+}
+
+func readResultsFile(path string) ([]*common.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	results, err := common.ReadResults(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading results from %s: %w", path, err)
+	}
+	return results, nil
+}
+
+// compareGroup is the comparison of the results of one harness sharing
+// a configuration suffix.
+type compareGroup struct {
+	harness string
+	suffix  string
+	rows    []*compareRow
+}
+
+// compareRow is the comparison of one metric of one benchmark.
+type compareRow struct {
+	name     string
+	unit     string
+	old, new []float64
+}
+
+// gomaxprocsSuffix matches the GOMAXPROCS suffix the Go benchmark format
+// appends to benchmark names, e.g. the "-8" in "BenchmarkKV0/nodes=1-8".
+var gomaxprocsSuffix = regexp.MustCompile(`-[0-9]+$`)
+
+// splitBenchmarkName splits the name of a result, e.g.
+// "BenchmarkCockroachDBkv0/nodes=3-8", into the name of the benchmark,
+// "CockroachDBkv0", and its configuration suffix, "/nodes=3".
+func splitBenchmarkName(name string) (base, suffix string) {
+	name = strings.TrimPrefix(name, "Benchmark")
+	name = gomaxprocsSuffix.ReplaceAllString(name, "")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// compareResults returns the comparison of old and new, the results of
+// harness, grouped by configuration suffix. Only the benchmarks and
+// metrics present in both are compared. Groups and their rows are in the
+// order the benchmarks first appear in old.
+func compareResults(harness string, old, new []*common.Result) []*compareGroup {
+	type key struct{ base, suffix, unit string }
+	samples := func(results []*common.Result) (map[key][]float64, []key) {
+		m := make(map[key][]float64)
+		var order []key
+		add := func(k key, v float64) {
+			if _, ok := m[k]; !ok {
+				order = append(order, k)
+			}
+			m[k] = append(m[k], v)
+		}
+		for _, r := range results {
+			base, suffix := splitBenchmarkName(r.Name)
+			if r.NsPerOp != 0 {
+				add(key{base, suffix, "ns/op"}, r.NsPerOp)
+			}
+			units := make([]string, 0, len(r.Metrics))
+			for unit := range r.Metrics {
+				units = append(units, unit)
+			}
+			sort.Strings(units)
+			for _, unit := range units {
+				add(key{base, suffix, unit}, r.Metrics[unit])
+			}
+		}
+		return m, order
+	}
+	oldSamples, order := samples(old)
+	newSamples, _ := samples(new)
+
+	var groups []*compareGroup
+	bySuffix := make(map[string]*compareGroup)
+	for _, k := range order {
+		n, ok := newSamples[k]
+		if !ok {
+			continue
+		}
+		g, ok := bySuffix[k.suffix]
+		if !ok {
+			g = &compareGroup{harness: harness, suffix: k.suffix}
+			bySuffix[k.suffix] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, &compareRow{
+			name: k.base,
+			unit: k.unit,
+			old:  oldSamples[k],
+			new:  n,
+		})
+	}
+	return groups
+}
+
+// printComparison writes a table of the comparisons in groups to w,
+// treating p-values below alpha as significant.
+func printComparison(w io.Writer, groups []*compareGroup, alpha float64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	harness := ""
+	for i, g := range groups {
+		if i == 0 || g.harness != harness {
+			if i != 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "%s\n", g.harness)
+			harness = g.harness
+		}
+		suffix := g.suffix
+		if suffix == "" {
+			suffix = "(no configuration suffix)"
+		}
+		fmt.Fprintf(tw, "  %s\n", suffix)
+		fmt.Fprintf(tw, "    name\tunit\told\tnew\tdelta\tp\tn\n")
+		for _, r := range g.rows {
+			oldMed, newMed := median(r.old), median(r.new)
+			p := mannWhitneyU(r.old, r.new)
+			delta := "~"
+			if p < alpha {
+				if oldMed == 0 {
+					delta = "?"
+				} else {
+					delta = fmt.Sprintf("%+.2f%%", (newMed-oldMed)/math.Abs(oldMed)*100)
+				}
+			}
+			fmt.Fprintf(tw, "    %s\t%s\t%s\t%s\t%s\tp=%.3f\tn=%d+%d\n",
+				r.name, r.unit, summarize(r.old), summarize(r.new), delta, p, len(r.old), len(r.new))
+		}
+	}
+	return tw.Flush()
+}
+
+// summarize returns the median of xs along with how far the furthest
+// value is from it, as a percentage of the median.
+func summarize(xs []float64) string {
+	med := median(xs)
+	spread := 0.0
+	for _, x := range xs {
+		spread = math.Max(spread, math.Abs(x-med))
+	}
+	if med == 0 {
+		return formatValue(med)
+	}
+	return fmt.Sprintf("%s ±%.0f%%", formatValue(med), spread/math.Abs(med)*100)
+}
+
+// formatValue formats v with four significant digits, plainly rather
+// than in exponent form for all but very large or small values.
+func formatValue(v float64) string {
+	if v == 0 || (math.Abs(v) >= 1e-3 && math.Abs(v) < 1e12) {
+		digits := 3 - int(math.Floor(math.Log10(math.Abs(v))))
+		if v == 0 || digits < 0 {
+			digits = 0
+		}
+		return fmt.Sprintf("%.*f", digits, v)
+	}
+	return fmt.Sprintf("%.3e", v)
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n == 0 {
+		return math.NaN()
+	}
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// mannWhitneyU returns the two-sided p-value of a Mann-Whitney U test of
+// whether xs and ys are drawn from the same distribution.
+//
+// The p-value is exact if there are no ties between the samples and
+// they're small, and otherwise comes from the normal approximation of U,
+// corrected for ties.
+func mannWhitneyU(xs, ys []float64) float64 {
+	n1, n2 := len(xs), len(ys)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	// Rank the combined samples, giving tied values the mean of the
+	// ranks they span.
+	type value struct {
+		v float64
+		x bool
+	}
+	all := make([]value, 0, n1+n2)
+	for _, x := range xs {
+		all = append(all, value{x, true})
+	}
+	for _, y := range ys {
+		all = append(all, value{y, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var r1, tieTerm float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].x {
+				r1 += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	u1 := r1 - float64(n1*(n1+1))/2
+	u := math.Min(u1, float64(n1*n2)-u1)
+
+	if !ties && n1*n2 <= 2500 {
+		return math.Min(1, 2*uCDF(n1, n2, int(u)))
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		// Every value is the same.
+		return 1
+	}
+	// u is at most mu, so this is the lower tail, with a continuity
+	// correction.
+	z := (u - mu + 0.5) / sigma
+	return math.Min(1, math.Erfc(-z/math.Sqrt2))
+}
+
+// uCDF returns the probability that the Mann-Whitney U statistic of
+// samples of sizes n1 and n2 with no ties is at most u, when they're
+// drawn from the same distribution.
+func uCDF(n1, n2, u int) float64 {
+	// counts[i][j][k] is the number of the orderings of samples of sizes
+	// i and j with U = k, where counts[i][j] depends only on
+	// counts[i-1][j], for orderings ending with a value from the first
+	// sample, and counts[i][j-1], for those ending with the second.
+	// Only the previous row of i is kept.
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = []float64{1}
+	}
+	for i := 1; i <= n1; i++ {
+		cur := make([][]float64, n2+1)
+		cur[0] = []float64{1}
+		for j := 1; j <= n2; j++ {
+			c := make([]float64, i*j+1)
+			// A value from the first sample at the end is greater
+			// than all j values of the second.
+			for k, v := range prev[j] {
+				c[k+j] += v
+			}
+			for k, v := range cur[j-1] {
+				c[k] += v
+			}
+			cur[j] = c
+		}
+		prev = cur
+	}
+	dist := prev[n2]
+	var total, below float64
+	for k, v := range dist {
+		total += v
+		if k <= u {
+			below += v
+		}
+	}
+	return below / total
+}
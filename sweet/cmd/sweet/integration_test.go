@@ -194,14 +194,20 @@ func TestSweetEndToEnd(t *testing.T) {
 	for i, shard := range []shard{
 		{"tile38", 2},
 		{"go-build", 4},
+		{"biogo-alignment", 1},
 		{"biogo-igor", 1},
 		{"biogo-krishna", 1},
+		{"caddy", 1},
 		{"cockroachdb", 1},
 		{"etcd", 1},
 		{"bleve-index", 1},
 		{"gopher-lua", 1},
+		{"grpc", 1},
 		{"markdown", 1},
 		{"gvisor", 1},
+		{"kube-apiserver", 1},
+		{"nats", 1},
+		{"prometheus", 1},
 	} {
 		sema.Acquire(context.Background(), shard.weight)
 		wg.Add(1)
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+const (
+	listUsage = `Lists the benchmarks in the suite.
+
+For each benchmark, prints its description, whether its
+prerequisites are met on this machine (and if not, why not),
+and, for benchmarks made up of several, the names -bench-filter
+selects from.
+
+Usage: %s list
+`
+)
+
+type listCmd struct{}
+
+func (*listCmd) Name() string     { return "list" }
+func (*listCmd) Synopsis() string { return "Lists the benchmarks in the suite." }
+func (*listCmd) PrintUsage(w io.Writer, base string) {
+	fmt.Fprintf(w, listUsage, base)
+}
+
+func (*listCmd) SetFlags(_ *flag.FlagSet) {}
+
+func (*listCmd) Run(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	listBenchmarks(os.Stdout, allBenchmarks)
+	return nil
+}
+
+// listBenchmarks writes the listing of benchmarks to w.
+func listBenchmarks(w io.Writer, benchmarks []benchmark) {
+	for i, b := range benchmarks {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %s\n", b.name, b.description)
+		if err := b.harness.CheckPrerequisites(); err != nil {
+			fmt.Fprintf(w, "  prerequisites: not met: %v\n", err)
+		} else {
+			fmt.Fprintln(w, "  prerequisites: met")
+		}
+		if l, ok := b.harness.(common.BenchmarkLister); ok {
+			if names := l.Benchmarks(); len(names) != 0 {
+				fmt.Fprintf(w, "  benchmarks: %s\n", strings.Join(names, ", "))
+			}
+		}
+	}
+}
@@ -15,5 +15,8 @@ func main() {
 	subcommands.Register(&putCmd{})
 	subcommands.Register(&runCmd{})
 	subcommands.Register(&genCmd{})
+	subcommands.Register(&compareCmd{})
+	subcommands.Register(&listCmd{})
+	subcommands.Register(&selftestCmd{})
 	os.Exit(subcommands.Run())
 }
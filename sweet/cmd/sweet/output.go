@@ -0,0 +1,132 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+// outputLayoutVersion is the version of the -output-dir layout, recorded
+// in its manifest. It changes only if the layout changes incompatibly.
+const outputLayoutVersion = 1
+
+const outputDirHelp = `
+Output directory:
+  With -output-dir, results are written to the given directory in
+  the following layout, which tooling may rely on:
+
+    manifest.json                    the run: layout version, Sweet
+                                     version, start time, benchmarks
+                                     and configurations
+    <benchmark>/<config>/
+      results.txt                    results in the Go benchmark format,
+                                     as "sweet compare" reads them
+      results.jsonl                  results as JSON, with -json-results
+      output.log                     output of the benchmark instead
+                                     of results.txt, with -profile-only
+      manifest.json                  the configuration's environment
+      build.log                      output of the build
+      profiles/                      CPU and memory profiles, for
+                                     benchmarks that support them
+      diagnostics/                   diagnostics the configuration
+                                     asks for, and PGO profiles
+    <benchmark>/core/, <benchmark>/bin/
+                                     core files and binaries, with
+                                     -dump-core
+
+  Files are added to the layout without changing its version.
+`
+
+// configOutput is where the output of one benchmark under one
+// configuration is written.
+type configOutput struct {
+	// results is the results file, or with -profile-only, where the
+	// benchmark's output goes instead.
+	results     string
+	jsonResults string
+	manifest    string
+	buildLog    string
+
+	// profiles is the directory for RunConfig.ProfileDir, or empty if
+	// profiles weren't asked for.
+	profiles string
+
+	// diagnostics is the directory the benchmark driver writes the
+	// configuration's diagnostics to.
+	diagnostics string
+}
+
+// configOutput returns where the output of b under c is written, which
+// is in the layout described by outputDirHelp with -output-dir.
+func (r *runCfg) configOutput(b *benchmark, c *common.Config) configOutput {
+	if r.outputDir != "" {
+		dir := filepath.Join(r.benchmarkResultsDir(b), c.Name)
+		o := configOutput{
+			results:     filepath.Join(dir, "results.txt"),
+			jsonResults: filepath.Join(dir, "results.jsonl"),
+			manifest:    filepath.Join(dir, "manifest.json"),
+			buildLog:    filepath.Join(dir, "build.log"),
+			profiles:    filepath.Join(dir, "profiles"),
+			diagnostics: filepath.Join(dir, "diagnostics"),
+		}
+		if r.profileOnly {
+			o.results = filepath.Join(dir, "output.log")
+		}
+		return o
+	}
+	dir := r.benchmarkResultsDir(b)
+	o := configOutput{
+		results:     filepath.Join(dir, fmt.Sprintf("%s.results", c.Name)),
+		jsonResults: filepath.Join(dir, fmt.Sprintf("%s.results.jsonl", c.Name)),
+		manifest:    filepath.Join(dir, fmt.Sprintf("%s.manifest.json", c.Name)),
+		buildLog:    filepath.Join(dir, fmt.Sprintf("%s.build.log", c.Name)),
+		diagnostics: filepath.Join(dir, fmt.Sprintf("%s.debug", c.Name)),
+	}
+	if r.profileOnly {
+		o.results = filepath.Join(dir, fmt.Sprintf("%s.profile.log", c.Name))
+	}
+	if r.profileDir != "" {
+		o.profiles = filepath.Join(r.profileDir, b.name, c.Name)
+	}
+	return o
+}
+
+// outputManifest is the manifest at the top of an -output-dir.
+type outputManifest struct {
+	Layout       int       `json:"layout"`
+	SweetVersion string    `json:"sweet_version"`
+	StartTime    time.Time `json:"start_time"`
+	Benchmarks   []string  `json:"benchmarks"`
+	Configs      []string  `json:"configs"`
+}
+
+// writeOutputManifest writes the manifest of a run of benchmarks under
+// configs to the top of the -output-dir.
+func (r *runCfg) writeOutputManifest(configs []*common.Config, benchmarks []*benchmark, start time.Time) error {
+	m := outputManifest{
+		Layout:       outputLayoutVersion,
+		SweetVersion: common.Version,
+		StartTime:    start.UTC(),
+		Benchmarks:   benchmarkNames(benchmarks),
+		Configs:      make([]string, 0, len(configs)),
+	}
+	for _, c := range configs {
+		m.Configs = append(m.Configs, c.Name)
+	}
+	b, err := json.MarshalIndent(&m, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := mkdirAll(r.outputDir); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.outputDir, "manifest.json"), append(b, '\n'), 0644)
+}
@@ -6,16 +6,24 @@ package main
 
 import (
 	"archive/zip"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 	"unicode/utf8"
 
 	"golang.org/x/benchmarks/sweet/cli/bootstrap"
@@ -26,6 +34,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/google/pprof/profile"
+	shellquote "github.com/kballard/go-shellquote"
 )
 
 type csvFlag []string
@@ -39,6 +48,98 @@ func (c *csvFlag) Set(input string) error {
 	return nil
 }
 
+// intListFlag is a flag that takes a comma-separated list of integers
+// from min to max, inclusive, and stores them in *values. If off is set,
+// it also takes "off", stored as -1, as GOGC does.
+type intListFlag struct {
+	values   *[]int
+	min, max int
+	off      bool
+	what     string // what each value is, for errors
+}
+
+func (f *intListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	var s []string
+	for _, v := range *f.values {
+		if f.off && v < 0 {
+			s = append(s, "off")
+		} else {
+			s = append(s, strconv.Itoa(v))
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *intListFlag) Set(input string) error {
+	*f.values = nil
+	for _, s := range strings.Split(input, ",") {
+		if f.off && s == "off" {
+			*f.values = append(*f.values, -1)
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < f.min || v > f.max {
+			return fmt.Errorf("invalid %s %q", f.what, s)
+		}
+		*f.values = append(*f.values, v)
+	}
+	return nil
+}
+
+// benchmarkMapFlag is a flag that maps benchmark names to values,
+// of the form name=value[,name=value...].
+type benchmarkMapFlag map[string]string
+
+func (m *benchmarkMapFlag) String() string {
+	var s []string
+	for k, v := range *m {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (m *benchmarkMapFlag) Set(input string) error {
+	*m = make(benchmarkMapFlag)
+	for _, kv := range strings.Split(input, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" || v == "" {
+			return fmt.Errorf("expected name=value, got %q", kv)
+		}
+		if _, ok := allBenchmarksMap[k]; !ok {
+			return fmt.Errorf("unknown benchmark %q", k)
+		}
+		(*m)[k] = v
+	}
+	return nil
+}
+
+// durationFlag is a time.Duration flag that records whether
+// it was set explicitly.
+type durationFlag struct {
+	d   time.Duration
+	set bool
+}
+
+func (d *durationFlag) String() string {
+	if !d.set {
+		return ""
+	}
+	return d.d.String()
+}
+
+func (d *durationFlag) Set(input string) error {
+	v, err := time.ParseDuration(input)
+	if err != nil {
+		return err
+	}
+	d.d, d.set = v, true
+	return nil
+}
+
 const (
 	runLongDesc = `Execute benchmarks in the suite against GOROOTs provided in TOML configuration
 files. Note: by default, this command expects to run from /path/to/x/benchmarks/sweet.`
@@ -49,6 +150,7 @@ files. Note: by default, this command expects to run from /path/to/x/benchmarks/
 const (
 	countDefault       = 10
 	pgoCountDefaultMax = 5
+	getRetriesDefault  = 3
 )
 
 type runCfg struct {
@@ -62,8 +164,149 @@ type runCfg struct {
 	pgo         bool
 	pgoCount    int
 	short       bool
+	timeout     durationFlag
+	profileDir  string
+	traceDir    string
+	failureDir  string
+	getRetries  int
+	localSrc    benchmarkMapFlag
+	treeHashes  benchmarkMapFlag
+	commits     benchmarkMapFlag
+	shallow     bool
+	gitMirror   string
+	benchArgs   benchmarkMapFlag
+	buildCache  string
+	benchFilter string
+	memLimits   csvFlag
+	gogcs       []int
+	gomaxprocs  []int
+	secure      bool
+	jsonResults bool
+	generatePGO bool
+	perfStat    bool
+	cpuList     string
+	warmup      int
+	resume      bool
+	container   common.ContainerSpec
+	remote      common.RemoteSpec
+	keepTmp     bool
+	artifactDir string
+
+	// state records the progress of the run for -resume. It's nil in a
+	// dry run, since nothing is actually done.
+	state *runState
 
 	assetsFS fs.FS
+
+	// readyTimeout is the value of -ready-timeout, or zero to leave it to
+	// each benchmark.
+	readyTimeout time.Duration
+
+	// buildTimeout is the value of -build-timeout.
+	buildTimeout time.Duration
+
+	// timeoutGrace is the value of -timeout-grace.
+	timeoutGrace time.Duration
+
+	// seed is the value of -seed.
+	seed int64
+
+	// buildParallelism is the value of -build-parallelism.
+	buildParallelism int
+
+	// checkDisk is the value of -check-disk, except in a dry run, which
+	// builds nothing.
+	checkDisk bool
+
+	// tmpfsSizeMB is the value of -tmpfs-size.
+	tmpfsSizeMB int
+
+	// hookCmd is the value of -hook-cmd.
+	hookCmd string
+
+	// outputDir is the value of -output-dir, or empty to write results
+	// to -results and profiles to -profile-dir instead.
+	outputDir string
+
+	// checkResults is the value of -check-results.
+	checkResults bool
+
+	// nodeCounts is the value of -node-counts.
+	nodeCounts []int
+
+	// readPercents is the value of -read-percents.
+	readPercents []int
+
+	// concurrency is the value of -concurrency.
+	concurrency []int
+
+	// static is the value of -static.
+	static bool
+
+	// forceCDeps is the value of -force-cdeps.
+	forceCDeps bool
+
+	// cgroup holds the values of -cgroup-parent, -cgroup-memory-max and
+	// -cgroup-cpu-max. It's only used if a limit is set.
+	cgroup common.CgroupSpec
+
+	// smoke is set by sweet selftest, which runs as little of each
+	// benchmark as it can. It implies short.
+	smoke bool
+
+	// profileOnly is the value of -profile-only.
+	profileOnly bool
+
+	// mutexProfileFraction is the value of -mutex-profile-fraction.
+	mutexProfileFraction int
+
+	// blockProfileRate is the value of -block-profile-rate.
+	blockProfileRate int
+
+	// profileSummary is the value of -profile-summary.
+	profileSummary bool
+
+	// teeResults is the value of -tee-results.
+	teeResults bool
+
+	// storeMode is the value of -store-mode.
+	storeMode string
+
+	// gcTrace is the value of -gc-trace.
+	gcTrace bool
+
+	// force is the value of -force.
+	force bool
+
+	// runtimeMetrics is the value of -runtime-metrics.
+	runtimeMetrics csvFlag
+
+	// duration is the value of -duration.
+	duration time.Duration
+
+	// eventsPath is the value of -events, and events is the file it
+	// names, opened for the run, or nil if it's empty.
+	eventsPath string
+	events     io.Writer
+
+	// ctx is canceled once the run is interrupted by SIGINT or SIGTERM.
+	ctx context.Context
+}
+
+// writeEvent writes e to r.events, if set. Tools following the run
+// shouldn't be able to fail it, so errors are only logged.
+func (r *runCfg) writeEvent(e common.Event) {
+	if r.events == nil {
+		return
+	}
+	if err := common.WriteEvent(r.events, e); err != nil {
+		log.Warnf("writing event to %s: %v", r.eventsPath, err)
+	}
+}
+
+// interrupted reports whether the run was interrupted.
+func (r *runCfg) interrupted() bool {
+	return r.ctx != nil && r.ctx.Err() != nil
 }
 
 func (r *runCfg) logCopyDirCommand(fromRelDir, toDir string) {
@@ -75,20 +318,41 @@ func (r *runCfg) logCopyDirCommand(fromRelDir, toDir string) {
 	}
 }
 
+// updateState calls f to change the state of the run, and saves it.
+func (r *runCfg) updateState(f func()) error {
+	if err := r.state.update(f); err != nil {
+		return fmt.Errorf("saving run state: %w", err)
+	}
+	return nil
+}
+
 func (r *runCfg) benchmarkResultsDir(b *benchmark) string {
 	return filepath.Join(r.resultsDir, b.name)
 }
 
 func (r *runCfg) runProfilesDir(b *benchmark, c *common.Config) string {
-	return filepath.Join(r.benchmarkResultsDir(b), fmt.Sprintf("%s.debug", c.Name))
+	return r.configOutput(b, c).diagnostics
+}
+
+// pgoProfilePath is where harnesses that support it write a PGO profile
+// for b under c, when generatePGO is set.
+func (r *runCfg) pgoProfilePath(b *benchmark, c *common.Config) string {
+	return filepath.Join(r.runProfilesDir(b, c), "default.pgo")
 }
 
 type runCmd struct {
 	runCfg
 	quiet       bool
+	verbose     bool
 	printCmd    bool
+	dryRun      bool
 	stopOnError bool
+	keepGoing   bool
 	toRun       csvFlag
+	toSkip      csvFlag
+
+	// sum is the outcome of each benchmark, once Run returns.
+	sum runSummary
 }
 
 func (*runCmd) Name() string     { return "run" }
@@ -134,6 +398,7 @@ func (*runCmd) PrintUsage(w io.Writer, base string) {
 
 	// Print configuration format information.
 	fmt.Fprintf(w, common.ConfigHelp)
+	fmt.Fprint(w, outputDirHelp)
 	fmt.Fprintln(w)
 
 	// Print usage line. Flags will automatically be added after.
@@ -142,6 +407,7 @@ func (*runCmd) PrintUsage(w io.Writer, base string) {
 
 func (c *runCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.runCfg.resultsDir, "results", "./results", "location to write benchmark results to")
+	f.StringVar(&c.runCfg.outputDir, "output-dir", "", "a directory to write results, manifests, build logs and profiles to in a stable layout, described below, instead of -results and -profile-dir")
 	f.StringVar(&c.runCfg.benchDir, "bench-dir", "./benchmarks", "the benchmarks directory in the sweet source")
 	f.StringVar(&c.runCfg.assetsDir, "assets-dir", "", "a directory containing uncompressed assets for sweet benchmarks, usually for debugging Sweet (overrides -cache)")
 	f.StringVar(&c.runCfg.workDir, "work-dir", "", "work directory for benchmarks (default: temporary directory)")
@@ -149,13 +415,78 @@ func (c *runCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.runCfg.dumpCore, "dump-core", false, "whether to dump core files for each benchmark process when it completes a benchmark")
 	f.BoolVar(&c.pgo, "pgo", false, "perform PGO testing; for each config, collect profiles from a baseline run which are used to feed into a generated PGO config")
 	f.IntVar(&c.runCfg.pgoCount, "pgo-count", 0, "the number of times to run profiling runs for -pgo; defaults to the value of -count if <=5, or 5 if higher")
-	f.IntVar(&c.runCfg.count, "count", 0, fmt.Sprintf("the number of times to run each benchmark (default %d)", countDefault))
+	f.IntVar(&c.runCfg.count, "count", 0, fmt.Sprintf("the number of times to run each benchmark, each producing one sample of its results for benchstat (default %d)", countDefault))
+	f.IntVar(&c.runCfg.getRetries, "get-retries", getRetriesDefault, "the number of times to retry fetching benchmark source code if it fails, for benchmarks that support it")
+	f.IntVar(&c.runCfg.buildParallelism, "build-parallelism", 1, "the number of benchmarks to build at once; if more than 1, every benchmark is built before any are run, and runs are still one at a time")
+	f.BoolVar(&c.runCfg.checkResults, "check-results", true, "whether to fail a benchmark whose results are implausible, e.g. with zero iterations, which may mean it's broken even though it ran without error")
+	f.BoolVar(&c.runCfg.checkDisk, "check-disk", true, "whether to check that the file systems each benchmark is built on have the free space it needs, which varies by benchmark, before building it")
+	f.DurationVar(&c.runCfg.buildTimeout, "build-timeout", 0, "the maximum duration of each benchmark's build, after which it's killed, where 0 means no timeout, for benchmarks that support it")
+	f.BoolVar(&c.runCfg.static, "static", false, "whether to link benchmarked applications statically, e.g. for -container-image, for benchmarks that support it; fails the build if static linking isn't possible")
+	f.BoolVar(&c.runCfg.forceCDeps, "force-cdeps", false, "whether to build benchmarks' C dependencies again even if those built for the same source by an earlier build, which are kept for -local-src checkouts, are still there, for benchmarks that support it")
+	f.StringVar(&c.runCfg.buildCache, "build-cache", "", "a directory in which to cache expensive build artifacts across runs, for benchmarks that support it")
+	f.Var(&c.runCfg.localSrc, "local-src", "comma-separated list of benchmark=path pairs to build from existing source checkouts instead of fetching source, for benchmarks that support it")
+	f.StringVar(&c.runCfg.gitMirror, "git-mirror", "", "base URL of a mirror of github.com to fetch benchmark source from, e.g. https://mirror.example.com/github; https://github.com/org/repo is fetched from <mirror>/org/repo")
+	f.Var(&c.runCfg.treeHashes, "tree-hash", "comma-separated list of benchmark=hash pairs giving the git tree hash fetched source must have, for benchmarks that support it")
+	f.Var(&c.runCfg.commits, "commit", "comma-separated list of benchmark=commit pairs giving a commit to fetch instead of the one the benchmark pins, as a full commit hash or a branch or tag, for benchmarks that support it")
+	f.BoolVar(&c.runCfg.shallow, "shallow", true, "whether to fetch only the commit of a benchmark's source that's built, rather than its whole history, where the git server allows it, for benchmarks that support it")
+	f.Var(&c.runCfg.benchArgs, "bench-args", "comma-separated list of benchmark=args pairs of extra shell-quoted arguments to pass to each benchmark's binary, for benchmarks that support it")
+	f.StringVar(&c.runCfg.benchFilter, "bench-filter", "", "a regular expression selecting which of each benchmark's sub-benchmarks to run, for benchmarks that support it")
+	f.Var(&c.runCfg.memLimits, "memlimits", "comma-separated list of GOMEMLIMIT values to run each benchmark with, for benchmarks that support it")
+	f.Var(&intListFlag{values: &c.runCfg.gogcs, max: math.MaxInt, off: true, what: "GOGC value"}, "gogcs", "comma-separated list of GOGC values (or off) to run each benchmark with, for benchmarks that support it")
+	f.Var(&intListFlag{values: &c.runCfg.gomaxprocs, min: 1, max: math.MaxInt, what: "GOMAXPROCS value"}, "gomaxprocs", "comma-separated list of GOMAXPROCS values to run each benchmark with, for benchmarks that support it")
+	f.Var(&intListFlag{values: &c.runCfg.nodeCounts, min: 1, max: math.MaxInt, what: "node count"}, "node-counts", "comma-separated list of cluster sizes to run each benchmark against, for benchmarks that run clusters (default 1,3 for cockroachdb)")
+	f.Var(&intListFlag{values: &c.runCfg.readPercents, max: 100, what: "read percentage"}, "read-percents", "comma-separated list of percentages of reads to run each benchmark's workload with, for benchmarks that support it (default 0,50,95 for cockroachdb)")
+	f.Var(&intListFlag{values: &c.runCfg.concurrency, min: 1, max: math.MaxInt, what: "concurrency"}, "concurrency", "comma-separated list of numbers of concurrent workers to run each benchmark's load generator with, for benchmarks that support it (default 10000 for cockroachdb)")
+	f.StringVar(&c.runCfg.cpuList, "cpu-list", "", "a set of CPUs in taskset -c format (e.g. 0-3,8) to pin benchmark processes to, with servers and load generators pinned to disjoint halves, for benchmarks that support it (Linux only)")
+	f.BoolVar(&c.runCfg.perfStat, "perf-stat", false, "whether to report hardware counters from Linux perf stat as additional metrics, for benchmarks that support it")
+	f.StringVar(&c.runCfg.container.Image, "container-image", "", "a container image to run benchmarks in, for benchmarks that support it; binaries built on the host must be able to run in it")
+	f.StringVar(&c.runCfg.container.Runtime, "container-runtime", "docker", "the container CLI to run benchmarks with for -container-image, e.g. docker or podman")
+	f.StringVar(&c.runCfg.container.CPUs, "container-cpus", "", "the maximum number of CPUs a benchmark's container may use, for -container-image")
+	f.StringVar(&c.runCfg.container.Memory, "container-memory", "", "the maximum amount of memory a benchmark's container may use, e.g. 16g, for -container-image")
+	f.Var((*csvFlag)(&c.runCfg.container.Sysctls), "container-sysctls", "comma-separated list of key=value kernel parameters to set in each benchmark's container, for -container-image")
+	f.Var((*csvFlag)(&c.runCfg.container.Mounts), "container-mounts", "comma-separated list of additional host:container paths to mount into each benchmark's container, for -container-image")
+	f.StringVar(&c.runCfg.cgroup.MemoryMax, "cgroup-memory-max", "", "a hard limit on the memory each benchmark may use, e.g. 4G, enforced by running it in a transient cgroup v2, for benchmarks that support it (Linux only, ignored with a warning without cgroup v2)")
+	f.StringVar(&c.runCfg.cgroup.CPUMax, "cgroup-cpu-max", "", "a limit on the CPU time each benchmark may use, as a quota and period in microseconds, e.g. \"200000 100000\" for two CPUs' worth, enforced like -cgroup-memory-max")
+	f.StringVar(&c.runCfg.cgroup.Parent, "cgroup-parent", "", "the cgroup v2 directory to create the cgroups for -cgroup-memory-max and -cgroup-cpu-max in, which must be writable and have no processes of its own (default: the root cgroup, which requires root)")
+	f.StringVar(&c.runCfg.remote.Host, "remote-host", "", "a host to run benchmarks on over ssh after building them locally, for benchmarks that support it; binaries and assets are copied there with rsync")
+	f.StringVar(&c.runCfg.remote.User, "remote-user", "", "the user to log in to -remote-host as (default: ssh's default)")
+	f.StringVar(&c.runCfg.remote.WorkDir, "remote-work-dir", "", "an absolute path to a work directory on -remote-host (required with -remote-host)")
+	f.Int64Var(&c.runCfg.seed, "seed", 0, "the seed for the random operations load generators issue, so that runs with the same seed issue the same ones, for benchmarks that support it; 0 means a random seed, which is logged with the results")
+	f.StringVar(&c.runCfg.hookCmd, "hook-cmd", "", "a shell-quoted command to run before and after each of a benchmark's sub-benchmarks, with the arguments \"before <name>\" or \"after <name>\", for collecting metrics of one's own; the output of \"after\" is added to the results, so it may report metrics in the Go benchmark format, for benchmarks that support it")
+	f.BoolVar(&c.runCfg.secure, "secure", false, "whether to run benchmarks over TLS-encrypted connections, for benchmarks that support it")
+	f.BoolVar(&c.runCfg.force, "force", false, "whether to run benchmark configurations the machine is too small for to produce meaningful results, e.g. cockroachdb clusters with fewer than 2 CPUs for each node and the load generator, rather than skip them")
+	f.BoolVar(&c.runCfg.gcTrace, "gc-trace", false, "whether to run the servers benchmarks run with GODEBUG=gctrace=1, to report the fraction of their CPU time GC used as gc-cpu-fraction, for benchmarks that support it; the trace perturbs the other results slightly, so they're tagged /gctrace")
+	f.StringVar(&c.runCfg.storeMode, "store-mode", "", "where the servers benchmarks run keep their data: disk, or mem to take disk I/O out of the results, for benchmarks that support it (default disk)")
+	f.BoolVar(&c.runCfg.teeResults, "tee-results", false, "whether to also copy the output of each benchmark to stdout as it's written to its results file, to watch the run's progress")
+	f.StringVar(&c.runCfg.eventsPath, "events", "", "a file to write the progress of the run to as it happens, as JSON objects, one per line, for tools that follow it: the start and end of fetching, building and running each benchmark, the start of each of its sub-benchmarks, each result, and each failure")
+	f.BoolVar(&c.runCfg.jsonResults, "json-results", false, "whether to also write each benchmark result as a JSON object, one per line, to a .results.jsonl file alongside each .results file, along with the wall-clock and, where known, CPU time it took to fetch, build and run each benchmark")
+	f.StringVar(&c.runCfg.profileDir, "profile-dir", "", "a directory to write per-benchmark CPU and memory profiles to, for benchmarks that support it")
+	f.BoolVar(&c.runCfg.profileOnly, "profile-only", false, "whether to run the benchmarks only to collect profiles, rather than to report results: each benchmark runs once, and its output goes to a log file instead of a results file; requires -profile-dir or -output-dir, and only benchmarks that support them write profiles")
+	f.IntVar(&c.runCfg.mutexProfileFraction, "mutex-profile-fraction", 0, "with profiles, sample on average 1 in this many mutex contention events for mutex profiles, for benchmarks that support it, where 0 leaves it to the benchmarked application; sampling more adds overhead")
+	f.IntVar(&c.runCfg.blockProfileRate, "block-profile-rate", 0, "with profiles, sample on average one blocking event per this many nanoseconds spent blocked for block profiles, for benchmarks that support it, where 0 leaves it to the benchmarked application; sampling more adds overhead")
+	f.BoolVar(&c.runCfg.profileSummary, "profile-summary", false, "whether to write a summary of the top 20 functions of each profile, as \"go tool pprof -top\" lists them, to a .top.txt file alongside it, for benchmarks that support it; requires -profile-dir or -output-dir")
+	f.Var(&c.runCfg.runtimeMetrics, "runtime-metrics", "comma-separated list of runtime/metrics to snapshot into a .metrics.json file per benchmark at the end of each run, or \"default\" for a selection of GC and scheduler metrics, or \"all\", for benchmarks that run in the benchmark binary itself; requires -profile-dir or -output-dir")
+	f.StringVar(&c.runCfg.failureDir, "failure-dir", "", "a directory to preserve server logs of failed benchmarks in, for benchmarks that support it")
+	f.IntVar(&c.runCfg.tmpfsSizeMB, "tmpfs-size", 0, "the size in MiB of a tmpfs to mount on each benchmark's tmp directory while it runs, to keep disk I/O out of the measurements, where 0 means none; requires root on Linux, and runs on disk with a warning otherwise")
+	f.BoolVar(&c.runCfg.keepTmp, "keep-tmp", false, "whether to keep each benchmark run's tmp directory for inspection, moving it under -artifact-dir instead of deleting it")
+	f.StringVar(&c.runCfg.artifactDir, "artifact-dir", "", "a directory to move tmp directories into with -keep-tmp, in a timestamped subdirectory per run")
+	f.StringVar(&c.runCfg.traceDir, "trace-dir", "", "a directory to write per-benchmark execution traces to, for benchmarks that support it (traces may take tens of MiB per process per second of benchmark)")
+	f.IntVar(&c.runCfg.warmup, "warmup", -1, "the number of times to run each benchmark, discarding the results, before each measured run, for benchmarks that support it (default: benchmark-specific, or 0 with -short)")
+	f.DurationVar(&c.runCfg.duration, "duration", 0, "how long to run each benchmark for by wall-clock time, e.g. 1h for a soak test, reporting samples of memory use and GC pauses over the run alongside the usual results, for benchmarks that support it; lifts the default timeouts, so pass -timeout to bound the run")
+	f.Var(&c.runCfg.timeout, "timeout", "the maximum duration of each benchmark run, where 0 means no timeout (default: benchmark-specific)")
+	f.DurationVar(&c.runCfg.timeoutGrace, "timeout-grace", 30*time.Second, "how long to give a benchmark that timed out to exit cleanly after asking it to, so it can write out partial results and profiles, before killing it, where 0 means killing it straight away")
+	f.DurationVar(&c.runCfg.readyTimeout, "ready-timeout", 0, "how long to wait for a benchmark's servers to become ready before failing the run, for benchmarks that support it (default: benchmark-specific)")
 
 	f.BoolVar(&c.quiet, "quiet", false, "whether to suppress activity output on stderr (no effect on -shell)")
+	f.BoolVar(&c.verbose, "v", false, "whether to include debugging detail in the activity output, such as each command run when not using -shell")
 	f.BoolVar(&c.printCmd, "shell", false, "whether to print the commands being executed to stdout")
+	f.BoolVar(&c.dryRun, "dry-run", false, "whether to print the commands that build and run benchmarks, with their working directory and environment, instead of executing them (benchmark source is still fetched)")
+	f.BoolVar(&c.runCfg.resume, "resume", false, "whether to skip fetching, building and running benchmarks that a previous run with the same -work-dir already did for the same configs, as recorded in "+stateFileName+" in the work directory; requires -work-dir")
 	f.BoolVar(&c.stopOnError, "stop-on-error", false, "whether to stop running benchmarks if an error occurs or a benchmark fails")
+	f.BoolVar(&c.keepGoing, "keep-going", false, "whether to skip benchmarks whose prerequisites aren't met, rather than running none, and keep running the rest when one fails; the run still fails if any benchmark did")
 	f.BoolVar(&c.short, "short", false, "whether to run a short version of the benchmarks for testing (changes -count to 1)")
 	f.Var(&c.toRun, "run", "benchmark group or comma-separated list of benchmarks to run")
+	f.Var(&c.toSkip, "skip", "comma-separated list of benchmarks not to run, out of those selected by -run")
 }
 
 func (c *runCmd) Run(args []string) error {
@@ -163,9 +494,56 @@ func (c *runCmd) Run(args []string) error {
 		return fmt.Errorf("at least one configuration is required")
 	}
 	checkPlatform()
+	start := time.Now()
+
+	// Stop the run on SIGINT or SIGTERM, killing the benchmark that's
+	// running and cleaning up after it rather than leaving its servers
+	// and tmp directory behind. A second signal terminates sweet at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	c.runCfg.ctx = ctx
+	if c.dryRun && c.pgo {
+		return fmt.Errorf("-pgo requires profiles from real runs, so it may not be used with -dry-run")
+	}
+	if c.mutexProfileFraction < 0 || c.blockProfileRate < 0 {
+		return fmt.Errorf("-mutex-profile-fraction and -block-profile-rate must not be negative")
+	}
+	if (c.mutexProfileFraction != 0 || c.blockProfileRate != 0) && c.profileDir == "" && c.outputDir == "" {
+		return fmt.Errorf("-mutex-profile-fraction and -block-profile-rate require -profile-dir or -output-dir to write the profiles to")
+	}
+	if c.profileSummary && c.profileDir == "" && c.outputDir == "" {
+		return fmt.Errorf("-profile-summary requires -profile-dir or -output-dir to write the profiles to")
+	}
+	if c.duration < 0 {
+		return fmt.Errorf("-duration must not be negative")
+	}
+	if len(c.runtimeMetrics) != 0 && c.profileDir == "" && c.outputDir == "" {
+		return fmt.Errorf("-runtime-metrics requires -profile-dir or -output-dir to write the snapshots to")
+	}
+	if c.profileOnly {
+		switch {
+		case c.profileDir == "" && c.outputDir == "":
+			return fmt.Errorf("-profile-only requires -profile-dir or -output-dir to write the profiles to")
+		case c.pgo:
+			return fmt.Errorf("-profile-only and -pgo are mutually exclusive")
+		case c.jsonResults:
+			return fmt.Errorf("-profile-only reports no results, so it may not be used with -json-results")
+		}
+		// Profiles are named after the benchmark, so later runs would
+		// only overwrite them, and the output isn't results to check.
+		c.runCfg.count = 1
+		c.checkResults = false
+	}
 
 	log.SetCommandTrace(c.printCmd)
 	log.SetActivityLog(!c.quiet)
+	if c.verbose {
+		log.SetLevel(log.LevelDebug)
+	}
 
 	if c.runCfg.count == 0 {
 		if c.short {
@@ -182,6 +560,39 @@ func (c *runCmd) Run(args []string) error {
 	}
 
 	var err error
+	if c.container.Image == "" && (c.container.CPUs != "" || c.container.Memory != "" || len(c.container.Sysctls) != 0 || len(c.container.Mounts) != 0) {
+		return fmt.Errorf("container limits, sysctls and mounts require a -container-image")
+	}
+	if c.cgroup.MemoryMax != "" || c.cgroup.CPUMax != "" {
+		if c.container.Image != "" || c.remote.Host != "" {
+			return fmt.Errorf("-cgroup-memory-max and -cgroup-cpu-max are not supported with -container-image or -remote-host; use -container-memory and -container-cpus to limit a container")
+		}
+		if !common.CgroupsSupported() {
+			log.Warnf("cgroup v2 isn't available, ignoring -cgroup-memory-max and -cgroup-cpu-max")
+			c.cgroup = common.CgroupSpec{}
+		}
+	} else if c.cgroup.Parent != "" {
+		return fmt.Errorf("-cgroup-parent requires -cgroup-memory-max or -cgroup-cpu-max")
+	}
+	if c.remote.Host == "" && (c.remote.User != "" || c.remote.WorkDir != "") {
+		return fmt.Errorf("-remote-user and -remote-work-dir require a -remote-host")
+	}
+	if c.remote.Host != "" && !path.IsAbs(c.remote.WorkDir) {
+		return fmt.Errorf("-remote-host requires an absolute -remote-work-dir")
+	}
+	c.remote.DryRun = c.dryRun
+	if c.dryRun {
+		c.checkDisk = false
+	}
+	if c.buildParallelism < 1 {
+		return fmt.Errorf("-build-parallelism must be at least 1, got %d", c.buildParallelism)
+	}
+	if c.keepGoing && c.stopOnError {
+		return fmt.Errorf("-keep-going and -stop-on-error are mutually exclusive")
+	}
+	if c.resume && c.workDir == "" {
+		return fmt.Errorf("-resume requires the -work-dir of the run to resume")
+	}
 	if c.workDir == "" {
 		// Create a temporary work tree for running the benchmarks.
 		c.workDir, err = os.MkdirTemp("", "gosweet")
@@ -199,10 +610,96 @@ func (c *runCmd) Run(args []string) error {
 	if err != nil {
 		return fmt.Errorf("creating absolute path from benchmarks path (-bench-dir): %w", err)
 	}
+	if c.outputDir != "" {
+		if c.profileDir != "" {
+			return fmt.Errorf("-output-dir and -profile-dir are mutually exclusive: profiles are written to -output-dir")
+		}
+		// Per-benchmark output goes in the same place as it does under
+		// -results, so -output-dir stands in for it.
+		c.resultsDir = c.outputDir
+	}
 	c.resultsDir, err = filepath.Abs(c.resultsDir)
 	if err != nil {
 		return fmt.Errorf("creating absolute path from results path (-results): %w", err)
 	}
+	if c.outputDir != "" {
+		c.outputDir = c.resultsDir
+	}
+	if _, err := regexp.Compile(c.benchFilter); err != nil {
+		return fmt.Errorf("invalid benchmark filter (-bench-filter): %w", err)
+	}
+	if hookArgs, err := shellquote.Split(c.hookCmd); err != nil {
+		return fmt.Errorf("invalid hook command (-hook-cmd): %w", err)
+	} else if c.hookCmd != "" && len(hookArgs) == 0 {
+		return fmt.Errorf("empty hook command (-hook-cmd)")
+	}
+	for name, commit := range c.commits {
+		if _, ok := c.localSrc[name]; ok {
+			return fmt.Errorf("-commit and -local-src are mutually exclusive for %s", name)
+		}
+		if !validCommit(commit) {
+			return fmt.Errorf("invalid commit %q for %s (-commit): must be a full commit hash or a branch or tag name", commit, name)
+		}
+	}
+	for name, args := range c.benchArgs {
+		if _, err := shellquote.Split(args); err != nil {
+			return fmt.Errorf("invalid arguments for %s (-bench-args): %w", name, err)
+		}
+	}
+	switch c.storeMode {
+	case "", "disk", "mem":
+	default:
+		return fmt.Errorf("invalid store mode %q (-store-mode): must be disk or mem", c.storeMode)
+	}
+	for _, l := range c.memLimits {
+		if !memLimitRe.MatchString(l) {
+			return fmt.Errorf("invalid GOMEMLIMIT value %q (-memlimits)", l)
+		}
+	}
+	if c.buildCache != "" {
+		c.buildCache, err = filepath.Abs(c.buildCache)
+		if err != nil {
+			return fmt.Errorf("creating absolute path from build cache path (-build-cache): %w", err)
+		}
+	}
+	if c.profileDir != "" {
+		c.profileDir, err = filepath.Abs(c.profileDir)
+		if err != nil {
+			return fmt.Errorf("creating absolute path from profile path (-profile-dir): %w", err)
+		}
+	}
+	if c.traceDir != "" {
+		c.traceDir, err = filepath.Abs(c.traceDir)
+		if err != nil {
+			return fmt.Errorf("creating absolute path from trace path (-trace-dir): %w", err)
+		}
+	}
+	if c.failureDir != "" {
+		c.failureDir, err = filepath.Abs(c.failureDir)
+		if err != nil {
+			return fmt.Errorf("creating absolute path from failure path (-failure-dir): %w", err)
+		}
+	}
+	if c.tmpfsSizeMB < 0 {
+		return fmt.Errorf("-tmpfs-size must not be negative, got %d", c.tmpfsSizeMB)
+	}
+	if c.tmpfsSizeMB != 0 && c.remote.Host != "" {
+		return fmt.Errorf("-tmpfs-size is not supported with -remote-host")
+	}
+	if c.keepTmp {
+		if c.artifactDir == "" {
+			return fmt.Errorf("-keep-tmp requires -artifact-dir")
+		}
+		if c.remote.Host != "" {
+			return fmt.Errorf("-keep-tmp is not supported with -remote-host")
+		}
+	}
+	if c.artifactDir != "" {
+		c.artifactDir, err = filepath.Abs(c.artifactDir)
+		if err != nil {
+			return fmt.Errorf("creating absolute path from artifact path (-artifact-dir): %w", err)
+		}
+	}
 	if c.assetsDir != "" {
 		c.assetsDir, err = filepath.Abs(c.assetsDir)
 		if err != nil {
@@ -260,8 +757,10 @@ func (c *runCmd) Run(args []string) error {
 		if !fi.IsDir() {
 			return fmt.Errorf("-bench-dir is not a directory; did you mean to run this command from x/benchmarks/sweet?")
 		}
+		// Benchmarks from outside this repository have their code
+		// elsewhere.
 		var missing []string
-		for _, b := range allBenchmarks {
+		for _, b := range builtinBenchmarks {
 			fi, err := os.Stat(filepath.Join(c.benchDir, b.name))
 			if err != nil || !fi.IsDir() {
 				missing = append(missing, b.name)
@@ -272,6 +771,24 @@ func (c *runCmd) Run(args []string) error {
 		}
 	}
 	log.Printf("Work directory: %s", c.workDir)
+	if !c.dryRun {
+		if err := mkdirAll(c.workDir); err != nil {
+			return err
+		}
+		c.state, err = loadRunState(filepath.Join(c.workDir, stateFileName), c.resume)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.eventsPath != "" {
+		f, err := os.Create(c.eventsPath)
+		if err != nil {
+			return fmt.Errorf("creating events file (-events): %w", err)
+		}
+		defer f.Close()
+		c.events = f
+	}
 
 	// Parse and validate all input TOML configs.
 	configs := make([]*common.Config, 0, len(args))
@@ -313,12 +830,30 @@ func (c *runCmd) Run(args []string) error {
 				return fmt.Errorf("path containing ~ found in config %q; feature not supported since v0.1.0", config.Name)
 			}
 			config.GoRoot = canonicalizePath(config.GoRoot, configDir)
+			for _, goroot := range []*string{&config.BuildGoRoot, &config.BenchGoRoot} {
+				if *goroot == "" {
+					continue
+				}
+				if strings.Contains(*goroot, "~") {
+					return fmt.Errorf("path containing ~ found in config %q; feature not supported since v0.1.0", config.Name)
+				}
+				*goroot = canonicalizePath(*goroot, configDir)
+			}
 			if config.BuildEnv.Env == nil {
 				config.BuildEnv.Env = common.NewEnvFromEnviron()
 			}
 			if config.ExecEnv.Env == nil {
 				config.ExecEnv.Env = common.NewEnvFromEnviron()
 			}
+			for _, flag := range config.GoFlags {
+				if !strings.HasPrefix(flag, "-") || strings.ContainsAny(flag, " \t\n") {
+					return fmt.Errorf("config %q in %q has invalid goflags entry %q: must be a flag without spaces, e.g. -flag=value", config.Name, configFile, flag)
+				}
+			}
+			if len(config.EnvPassthrough) != 0 {
+				config.ExecEnv.Env = config.ExecEnv.Passthrough(config.EnvPassthrough...)
+			}
+			config.DryRun = c.dryRun
 			if config.PGOFiles == nil {
 				config.PGOFiles = make(map[string]string)
 			}
@@ -355,6 +890,28 @@ func (c *runCmd) Run(args []string) error {
 	if len(unknown) != 0 {
 		return fmt.Errorf("unknown benchmarks: %s", strings.Join(unknown, ", "))
 	}
+	if len(c.toSkip) != 0 {
+		skip := make(map[string]bool)
+		for _, name := range c.toSkip {
+			if _, ok := allBenchmarksMap[name]; !ok {
+				unknown = append(unknown, name)
+			}
+			skip[name] = true
+		}
+		if len(unknown) != 0 {
+			return fmt.Errorf("unknown benchmarks in -skip: %s", strings.Join(unknown, ", "))
+		}
+		var kept []*benchmark
+		for _, b := range benchmarks {
+			if !skip[b.name] {
+				kept = append(kept, b)
+			}
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("-skip leaves no benchmarks to run")
+		}
+		benchmarks = kept
+	}
 
 	// Print an indication of how many runs will be done.
 	countString := fmt.Sprintf("%d runs", c.runCfg.count*len(configs))
@@ -364,11 +921,21 @@ func (c *runCmd) Run(args []string) error {
 	log.Printf("Benchmarks: %s (%s)", strings.Join(benchmarkNames(benchmarks), " "), countString)
 
 	// Check prerequisites for each benchmark.
+	sum := &c.sum
+	runnable := benchmarks[:0:0]
 	for _, b := range benchmarks {
 		if err := b.harness.CheckPrerequisites(); err != nil {
-			return fmt.Errorf("failed to meet prerequisites for %s: %v", b.name, err)
+			err = fmt.Errorf("failed to meet prerequisites for %s: %v", b.name, err)
+			if !c.keepGoing {
+				return err
+			}
+			log.Error(err)
+			sum.skipped = append(sum.skipped, b.name)
+			continue
 		}
+		runnable = append(runnable, b)
 	}
+	benchmarks = runnable
 
 	// Collect profiles from baseline runs and create new PGO'd configs.
 	if c.pgo {
@@ -378,23 +945,92 @@ func (c *runCmd) Run(args []string) error {
 		}
 	}
 
+	if c.outputDir != "" {
+		if err := c.writeOutputManifest(configs, benchmarks, start); err != nil {
+			return fmt.Errorf("writing output manifest: %w", err)
+		}
+	}
+
+	// With -build-parallelism, build every benchmark up front, so that
+	// builds don't disturb the runs, which are still one at a time.
+	var prepared []*preparedBenchmark
+	var prepareErrs []error
+	if c.buildParallelism > 1 {
+		log.Printf("Building benchmarks, up to %d at a time", c.buildParallelism)
+		prepared, prepareErrs = prepareAll(benchmarks, configs, &c.runCfg, c.buildParallelism, c.stopOnError)
+	}
+
 	// Execute each benchmark for all configs.
-	var errEncountered bool
-	for _, b := range benchmarks {
-		if err := b.execute(configs, &c.runCfg); err != nil {
-			if c.stopOnError {
+	for i, b := range benchmarks {
+		if c.interrupted() {
+			closePrepared(prepared, i)
+			sum.skipped = append(sum.skipped, benchmarkNames(benchmarks[i:])...)
+			sum.log()
+			return fmt.Errorf("interrupted")
+		}
+		var err error
+		if prepared == nil {
+			err = b.execute(configs, &c.runCfg)
+		} else if err = prepareErrs[i]; err == nil {
+			err = prepared[i].run(&c.runCfg)
+		}
+		if errors.Is(err, errNotPrepared) {
+			sum.skipped = append(sum.skipped, b.name)
+			continue
+		}
+		if err != nil {
+			sum.failed = append(sum.failed, b.name)
+			c.writeEvent(common.Event{Type: common.EventError, Benchmark: b.name, Error: err.Error()})
+			if c.stopOnError || c.interrupted() {
+				closePrepared(prepared, i+1)
+				sum.skipped = append(sum.skipped, benchmarkNames(benchmarks[i+1:])...)
+				sum.log()
 				return err
 			}
-			errEncountered = true
 			log.Error(err)
+			continue
 		}
+		sum.passed = append(sum.passed, b.name)
 	}
-	if errEncountered {
+	sum.log()
+	if len(sum.failed) != 0 || len(sum.skipped) != 0 {
 		return fmt.Errorf("failed to execute benchmarks, see log for details")
 	}
 	return nil
 }
 
+// closePrepared closes the benchmarks in prepared from index i on, which
+// won't be run. prepared may be nil, if nothing was prepared up front.
+func closePrepared(prepared []*preparedBenchmark, i int) {
+	if prepared == nil {
+		return
+	}
+	for _, p := range prepared[i:] {
+		if p != nil {
+			p.close()
+		}
+	}
+}
+
+// runSummary records the outcome of each benchmark in a run.
+type runSummary struct {
+	passed, failed, skipped []string
+}
+
+// log logs which benchmarks passed, failed and were skipped.
+func (s *runSummary) log() {
+	list := func(names []string) string {
+		if len(names) == 0 {
+			return "none"
+		}
+		return strings.Join(names, " ")
+	}
+	log.Printf("Summary:")
+	log.Printf("  passed:  %s", list(s.passed))
+	log.Printf("  failed:  %s", list(s.failed))
+	log.Printf("  skipped: %s", list(s.skipped))
+}
+
 func (c *runCmd) preparePGO(configs []*common.Config, benchmarks []*benchmark) ([]*common.Config, error) {
 	profileConfigs := make([]*common.Config, 0, len(configs))
 	for _, c := range configs {
@@ -406,6 +1042,7 @@ func (c *runCmd) preparePGO(configs []*common.Config, benchmarks []*benchmark) (
 
 	profileRunCfg := c.runCfg
 	profileRunCfg.count = profileRunCfg.pgoCount
+	profileRunCfg.generatePGO = true
 
 	log.Printf("Running profile collection runs")
 
@@ -413,7 +1050,7 @@ func (c *runCmd) preparePGO(configs []*common.Config, benchmarks []*benchmark) (
 	var errEncountered bool
 	for _, b := range benchmarks {
 		if err := b.execute(profileConfigs, &profileRunCfg); err != nil {
-			if c.stopOnError {
+			if c.stopOnError || c.interrupted() {
 				return nil, err
 			}
 			errEncountered = true
@@ -434,10 +1071,17 @@ func (c *runCmd) preparePGO(configs []*common.Config, benchmarks []*benchmark) (
 		pgoConfig.PGOFiles = make(map[string]string)
 
 		for _, b := range benchmarks {
-			p, err := mergeCPUProfiles(profileRunCfg.runProfilesDir(b, profileConfig))
-			if err != nil {
-				return nil, fmt.Errorf("error merging profiles for %s/%s: %w", b.name, profileConfig.Name, err)
+			// Prefer a profile produced by the harness itself, which
+			// knows which processes are worth profiling. Otherwise, merge
+			// the profiles the benchmark driver collected.
+			p := profileRunCfg.pgoProfilePath(b, profileConfig)
+			if _, err := os.Stat(p); err != nil {
+				p, err = mergeCPUProfiles(profileRunCfg.runProfilesDir(b, profileConfig))
+				if err != nil {
+					return nil, fmt.Errorf("error merging profiles for %s/%s: %w", b.name, profileConfig.Name, err)
+				}
 			}
+			log.Printf("Using PGO profile for %s/%s: %s", b.name, pgoConfig.Name, p)
 			pgoConfig.PGOFiles[b.name] = p
 		}
 
@@ -447,6 +1091,22 @@ func (c *runCmd) preparePGO(configs []*common.Config, benchmarks []*benchmark) (
 	return newConfigs, nil
 }
 
+var commitHashRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// validCommit reports whether commit is a full commit hash, or could be
+// the name of a branch or tag, which is only resolved once fetched.
+func validCommit(commit string) bool {
+	if commitHashRe.MatchString(commit) {
+		return true
+	}
+	if strings.HasPrefix(commit, "-") {
+		return false
+	}
+	return exec.Command("git", "check-ref-format", "--allow-onelevel", commit).Run() == nil
+}
+
+var memLimitRe = regexp.MustCompile(`^([0-9]+(B|KiB|MiB|GiB|TiB)?|off)$`)
+
 var cpuProfileRe = regexp.MustCompile(`^.*\.cpuprofile[0-9]+$`)
 
 func mergeCPUProfiles(dir string) (string, error) {
@@ -497,6 +1157,6 @@ func checkPlatform() {
 		}
 	}
 	if !platformOK {
-		log.Printf("warning: %s is an unsupported platform, use at your own risk!", currentPlatform)
+		log.Warnf("%s is an unsupported platform, use at your own risk!", currentPlatform)
 	}
 }
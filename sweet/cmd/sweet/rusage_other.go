@@ -0,0 +1,15 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "time"
+
+// childrenCPUTime returns the CPU time used by sweet's child processes,
+// which isn't known on this platform.
+func childrenCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+const (
+	selftestUsage = `Checks that each benchmark in the suite builds and runs.
+
+Runs as little of each benchmark as shows it works, e.g. one of its
+benchmarks rather than all of them, once, with the tiny inputs of
+-short, and reports which passed, failed or were skipped because
+their prerequisites weren't met. The results aren't meant to be
+compared.
+
+If no configuration is given, the benchmarks are built and run with
+the Go toolchain on the PATH.
+
+Usage: %s selftest [flags] [config]
+`
+)
+
+// selftestFlags are the flags of sweet run that sweet selftest takes too.
+// The rest decide what's run and measured, which selftest decides itself.
+var selftestFlags = []string{
+	"results",
+	"bench-dir",
+	"assets-dir",
+	"cache",
+	"work-dir",
+	"local-src",
+	"git-mirror",
+	"build-cache",
+	"build-parallelism",
+	"build-timeout",
+	"timeout",
+	"run",
+	"skip",
+	"quiet",
+	"v",
+	"shell",
+	"force",
+}
+
+type selftestCmd struct {
+	runCmd
+}
+
+func (*selftestCmd) Name() string { return "selftest" }
+func (*selftestCmd) Synopsis() string {
+	return "Checks that each benchmark in the suite builds and runs."
+}
+func (*selftestCmd) PrintUsage(w io.Writer, base string) {
+	fmt.Fprintf(w, selftestUsage, base)
+}
+
+func (c *selftestCmd) SetFlags(f *flag.FlagSet) {
+	// Take the flags from sweet run, with their defaults, but keep
+	// selftest's results apart from those of real runs.
+	var run flag.FlagSet
+	c.runCmd.SetFlags(&run)
+	c.resultsDir = "./selftest-results"
+	c.toRun = csvFlag{"all"}
+	for _, name := range selftestFlags {
+		fl := run.Lookup(name)
+		f.Var(fl.Value, fl.Name, fl.Usage)
+	}
+}
+
+func (c *selftestCmd) Run(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("at most one configuration may be given")
+	}
+	if len(args) == 0 {
+		config, err := systemGoConfig()
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(config))
+		args = []string{config}
+	}
+	c.short = true
+	c.smoke = true
+	c.count = 1
+	c.warmup = 0
+	c.keepGoing = true
+	err := c.runCmd.Run(args)
+	for _, r := range []struct {
+		status string
+		names  []string
+	}{
+		{"PASS", c.sum.passed},
+		{"FAIL", c.sum.failed},
+		{"SKIP", c.sum.skipped},
+	} {
+		for _, name := range r.names {
+			fmt.Printf("%s\t%s\n", r.status, name)
+		}
+	}
+	return err
+}
+
+// systemGoConfig writes a configuration file for the Go toolchain on the
+// PATH to a new temporary directory, returning its path.
+func systemGoConfig() (string, error) {
+	goTool, err := common.SystemGoTool()
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "sweet-selftest")
+	if err != nil {
+		return "", err
+	}
+	config := filepath.Join(dir, "config.toml")
+	data := fmt.Sprintf("[[config]]\n  name = \"selftest\"\n  goroot = %q\n", goTool.GOROOT())
+	if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return config, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+// stateFileName is the name of the file in the work directory that
+// records the progress of a run, for -resume.
+const stateFileName = "sweet-state.json"
+
+// runState records which phases of each benchmark a run has completed, so
+// that a later run with -resume can skip them. It's written to the work
+// directory after every phase.
+type runState struct {
+	path string
+
+	// mu guards Benchmarks, since benchmarks may be built concurrently.
+	mu         sync.Mutex
+	Benchmarks map[string]*benchmarkState `json:"benchmarks"`
+}
+
+type benchmarkState struct {
+	// Got indicates that the benchmark's source was fetched.
+	Got bool `json:"get"`
+
+	// Built and Ran map the names of the configurations the benchmark
+	// was built and run for to the fingerprint of each configuration at
+	// the time. See configFingerprint.
+	Built map[string]string `json:"build"`
+	Ran   map[string]string `json:"run"`
+}
+
+// loadRunState returns the state recorded at path if resume is true, or
+// else fresh state, which it persists immediately so that stale state
+// from an earlier run can't be resumed from.
+func loadRunState(path string, resume bool) (*runState, error) {
+	s := &runState{path: path, Benchmarks: make(map[string]*benchmarkState)}
+	if !resume {
+		return s, s.save()
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing to resume.
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("reading run state from %s: %w", path, err)
+	}
+	if s.Benchmarks == nil {
+		s.Benchmarks = make(map[string]*benchmarkState)
+	}
+	return s, nil
+}
+
+// benchmark returns the state of the benchmark named name. Changes to it
+// must be made with update.
+func (s *runState) benchmark(name string) *benchmarkState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs, ok := s.Benchmarks[name]
+	if !ok {
+		bs = new(benchmarkState)
+		s.Benchmarks[name] = bs
+	}
+	if bs.Built == nil {
+		bs.Built = make(map[string]string)
+	}
+	if bs.Ran == nil {
+		bs.Ran = make(map[string]string)
+	}
+	return bs
+}
+
+// update calls f to change the state of one or more benchmarks, and
+// then saves s.
+func (s *runState) update(f func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+	return s.write()
+}
+
+// save writes s to its file.
+func (s *runState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write()
+}
+
+// write writes s to its file. The file is replaced atomically, so an
+// interrupted write doesn't lose the progress already recorded.
+func (s *runState) write() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// configFingerprint returns a hash of everything about cfg, and about
+// the run's -short and -static flags, that affects how a benchmark is built and run, so that resuming doesn't reuse the
+// results of a configuration that has since changed.
+//
+// Configuration environments inherit the whole of sweet's environment,
+// which is bound to differ in small ways between the run that failed and
+// the one resuming it, e.g. in terminal variables. Only the variables
+// the configuration changes are part of the fingerprint.
+func configFingerprint(cfg *common.Config, short, static bool) (string, error) {
+	environ := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		environ[kv] = true
+	}
+	changed := func(s []string) []string {
+		var d []string
+		for _, kv := range s {
+			if !environ[kv] {
+				d = append(d, kv)
+			}
+		}
+		sort.Strings(d)
+		return d
+	}
+	diags := cfg.Diagnostics.Strings()
+	sort.Strings(diags)
+	b, err := json.Marshal(struct {
+		GoRoot      string
+		BuildGoRoot string
+		BenchGoRoot string
+		BuildEnv    []string
+		ExecEnv     []string
+		PGOFiles    map[string]string
+		Diagnostics []string
+		Short       bool
+		Static      bool `json:",omitempty"`
+
+		GoExperiment string   `json:",omitempty"`
+		GoFlags      []string `json:",omitempty"`
+
+		EnvPassthrough []string
+	}{
+		GoRoot:      cfg.GoRoot,
+		BuildGoRoot: cfg.BuildGoRoot,
+		BenchGoRoot: cfg.BenchGoRoot,
+		BuildEnv:    changed(cfg.BuildEnv.Collapse()),
+		ExecEnv:     changed(cfg.ExecEnv.Collapse()),
+		PGOFiles:    cfg.PGOFiles,
+		Diagnostics: diags,
+		Short:       short,
+		Static:      static,
+
+		GoExperiment: cfg.GoExperiment,
+		GoFlags:      cfg.GoFlags,
+
+		EnvPassthrough: cfg.EnvPassthrough,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// mountTmpfs mounts a tmpfs of sizeMB MiB on dir, hiding anything
+// already in dir until it's unmounted. It typically requires root.
+func mountTmpfs(dir string, sizeMB int) error {
+	log.CommandPrintf("mount -t tmpfs -o nosuid,nodev,size=%dm tmpfs %s", sizeMB, dir)
+	return syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, fmt.Sprintf("size=%dm", sizeMB))
+}
+
+// unmountTmpfs unmounts the tmpfs mountTmpfs mounted on dir, discarding
+// its contents.
+func unmountTmpfs(dir string) error {
+	log.CommandPrintf("umount %s", dir)
+	return syscall.Unmount(dir, 0)
+}
@@ -0,0 +1,17 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package main
+
+import "errors"
+
+func mountTmpfs(dir string, sizeMB int) error {
+	return errors.New("tmpfs is only supported on Linux")
+}
+
+func unmountTmpfs(dir string) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+// CgroupSpec describes a cgroup v2 to run a benchmark in, to limit the
+// memory and CPU it may use without running it in a container. See
+// RunConfig.Cgroup.
+type CgroupSpec struct {
+	// Parent is the cgroup to create the benchmark's cgroup in, as a
+	// path in the cgroup v2 file system. Sweet enables the controllers
+	// the limits need in it, so it must be writable and, unless it's the
+	// root cgroup, have no processes of its own. If empty, it's the root
+	// cgroup, /sys/fs/cgroup, which requires root.
+	Parent string
+
+	// MemoryMax, if non-empty, is the hard limit on the memory the
+	// benchmark may use, as written to memory.max, e.g. "4G". The
+	// benchmark is OOM-killed if it needs more.
+	MemoryMax string
+
+	// CPUMax, if non-empty, is the limit on the CPU time the benchmark
+	// may use, as written to cpu.max: a quota and a period, in
+	// microseconds, e.g. "200000 100000" for two CPUs' worth.
+	CPUMax string
+}
+
+// Cgroup is a transient cgroup created for a benchmark by
+// CgroupSpec.Create.
+type Cgroup struct {
+	path string
+	fd   int
+}
+
+// Path returns the path of c in the cgroup file system.
+func (c *Cgroup) Path() string {
+	return c.path
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupRoot is where the cgroup v2 file system is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupSeq numbers the cgroups sweet creates, to keep their names
+// unique.
+var cgroupSeq atomic.Int64
+
+// CgroupsSupported reports whether benchmarks can be run in a cgroup,
+// which requires cgroup v2.
+func CgroupsSupported() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// Create creates a new cgroup with the limits s describes. It must be
+// removed with Remove once it's no longer needed.
+func (s *CgroupSpec) Create() (*Cgroup, error) {
+	parent := s.Parent
+	if parent == "" {
+		parent = cgroupRoot
+	}
+	var controllers, enable []string
+	if s.MemoryMax != "" {
+		controllers = append(controllers, "memory")
+	}
+	if s.CPUMax != "" {
+		controllers = append(controllers, "cpu")
+	}
+	for _, c := range controllers {
+		enable = append(enable, "+"+c)
+	}
+	if len(enable) != 0 {
+		if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0644); err != nil {
+			return nil, fmt.Errorf("enabling the %s controllers in cgroup %s: %w", strings.Join(controllers, " and "), parent, err)
+		}
+	}
+	path := filepath.Join(parent, fmt.Sprintf("sweet-%d-%d", os.Getpid(), cgroupSeq.Add(1)))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("creating cgroup: %w", err)
+	}
+	c := &Cgroup{path: path, fd: -1}
+	for _, limit := range []struct{ file, value string }{
+		{"memory.max", s.MemoryMax},
+		{"cpu.max", s.CPUMax},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(path, limit.file), []byte(limit.value), 0644); err != nil {
+			c.Remove()
+			return nil, fmt.Errorf("setting %s of cgroup %s to %q: %w", limit.file, path, limit.value, err)
+		}
+	}
+	fd, err := syscall.Open(path, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		c.Remove()
+		return nil, fmt.Errorf("opening cgroup %s: %w", path, err)
+	}
+	c.fd = fd
+	return c, nil
+}
+
+// Apply arranges for cmd, which must not have started yet, to start in
+// c, so that it and every process it starts are subject to c's limits
+// from the outset.
+func (c *Cgroup) Apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = c.fd
+}
+
+// MemoryEvents returns the path of c's memory.events file, which counts
+// the processes in it that were OOM-killed.
+func (c *Cgroup) MemoryEvents() string {
+	return filepath.Join(c.path, "memory.events")
+}
+
+// Remove kills any processes left in c and removes it.
+func (c *Cgroup) Remove() error {
+	if c.fd >= 0 {
+		syscall.Close(c.fd)
+		c.fd = -1
+	}
+	// cgroup.kill is only there as of Linux 5.14. Without it, anything
+	// left keeps the cgroup from being removed.
+	_ = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	// The cgroup can't be removed until the processes in it are gone,
+	// which takes a moment after they're killed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := syscall.Rmdir(c.path)
+		if err == nil || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("removing cgroup %s: %w", c.path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
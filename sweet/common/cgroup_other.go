@@ -0,0 +1,33 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package common
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// CgroupsSupported reports false, since cgroups are only supported on
+// Linux.
+func CgroupsSupported() bool {
+	return false
+}
+
+func (s *CgroupSpec) Create() (*Cgroup, error) {
+	return nil, fmt.Errorf("cgroups aren't supported on %s", runtime.GOOS)
+}
+
+func (c *Cgroup) Apply(cmd *exec.Cmd) {}
+
+func (c *Cgroup) MemoryEvents() string {
+	return ""
+}
+
+func (c *Cgroup) Remove() error {
+	return nil
+}
@@ -18,10 +18,27 @@ The input configuration format is TOML consisting of a single array field
 called 'config'. Each element of the array consists of the following fields:
          name: a unique name for the configuration (required)
        goroot: path to a GOROOT representing the toolchain to run (required)
+  gorootbuild: path to a GOROOT used instead of goroot to build the
+               benchmarked application itself, for benchmarks that support
+               it (optional)
+  gorootbench: path to a GOROOT used instead of goroot to build the
+               benchmark's driver or wrapper, for benchmarks that support
+               it (optional)
      envbuild: additional environment variables that should be used for
                compilation each variable should take the form "X=Y" (optional)
       envexec: additional environment variables that should be used for execution
                each variable should take the form "X=Y" (optional)
+  envpassthrough: names of variables in sweet's environment to pass on to
+               benchmarks, such as TMPDIR or LANG, where a trailing * matches
+               a prefix, e.g. COCKROACH_*; if set, benchmarks only inherit
+               these, plus envexec, rather than the whole environment
+               (optional)
+ goexperiment: the GOEXPERIMENT to build the benchmarked application with,
+               e.g. "greenteagc", for benchmarks that support it; takes
+               precedence over any GOEXPERIMENT in envbuild (optional)
+      goflags: flags to add to GOFLAGS when building the benchmarked
+               application, for benchmarks that support it, each of the
+               form "-flag=value" (optional)
      pgofiles: a map of benchmark names (see 'sweet help run') to profile files
                to be passed to the Go compiler for optimization (optional)
   diagnostics: profile types to collect for each benchmark run of this
@@ -57,18 +74,71 @@ type ConfigFile struct {
 type Config struct {
 	Name        string                `toml:"name"`
 	GoRoot      string                `toml:"goroot"`
+	BuildGoRoot string                `toml:"gorootbuild"`
+	BenchGoRoot string                `toml:"gorootbench"`
 	BuildEnv    ConfigEnv             `toml:"envbuild"`
 	ExecEnv     ConfigEnv             `toml:"envexec"`
 	PGOFiles    map[string]string     `toml:"pgofiles"`
 	Diagnostics diagnostics.ConfigSet `toml:"diagnostics"`
+
+	// EnvPassthrough, if non-empty, limits what ExecEnv inherits from
+	// sweet's environment to the variables it names. See Env.Passthrough.
+	EnvPassthrough []string `toml:"envpassthrough"`
+
+	// GoExperiment, if non-empty, is the GOEXPERIMENT to build the
+	// benchmarked application with, and GoFlags are flags to add to
+	// GOFLAGS for the build. See BuildConfig.GoEnv.
+	GoExperiment string   `toml:"goexperiment"`
+	GoFlags      []string `toml:"goflags"`
+
+	// DryRun indicates that commands which build or run benchmarks
+	// should be printed, along with their working directory and
+	// environment, instead of executed.
+	//
+	// Commands that only query state, like "go env", still run, since
+	// later commands may depend on their output.
+	DryRun bool `toml:"-"`
 }
 
 func (c *Config) GoTool() *Go {
+	return c.goTool(c.GoRoot)
+}
+
+// BuildGoTool returns the toolchain to build the benchmarked application
+// with: BuildGoRoot's, or GoRoot's if unset.
+func (c *Config) BuildGoTool() *Go {
+	return c.goTool(c.BuildGoRootOrDefault())
+}
+
+// BenchGoTool returns the toolchain to build benchmark drivers and
+// wrappers with: BenchGoRoot's, or GoRoot's if unset.
+func (c *Config) BenchGoTool() *Go {
+	return c.goTool(c.BenchGoRootOrDefault())
+}
+
+// BuildGoRootOrDefault returns BuildGoRoot, or GoRoot if it's unset.
+func (c *Config) BuildGoRootOrDefault() string {
+	if c.BuildGoRoot != "" {
+		return c.BuildGoRoot
+	}
+	return c.GoRoot
+}
+
+// BenchGoRootOrDefault returns BenchGoRoot, or GoRoot if it's unset.
+func (c *Config) BenchGoRootOrDefault() string {
+	if c.BenchGoRoot != "" {
+		return c.BenchGoRoot
+	}
+	return c.GoRoot
+}
+
+func (c *Config) goTool(goroot string) *Go {
 	return &Go{
-		Tool: filepath.Join(c.GoRoot, "bin", "go"),
+		Tool: filepath.Join(goroot, "bin", "go"),
 		// Update the GOROOT so the wrong one doesn't propagate from
 		// the environment.
-		Env: c.BuildEnv.Env.MustSet("GOROOT=" + c.GoRoot),
+		Env:    c.BuildEnv.Env.MustSet("GOROOT=" + goroot),
+		DryRun: c.DryRun,
 	}
 }
 
@@ -80,6 +150,7 @@ func (c *Config) Copy() *Config {
 		cc.PGOFiles[k] = v
 	}
 	cc.Diagnostics = c.Diagnostics.Copy()
+	cc.GoFlags = append([]string(nil), c.GoFlags...)
 	return &cc
 }
 
@@ -94,10 +165,16 @@ func ConfigFileMarshalTOML(c *ConfigFile) ([]byte, error) {
 	type config struct {
 		Name        string            `toml:"name"`
 		GoRoot      string            `toml:"goroot"`
+		BuildGoRoot string            `toml:"gorootbuild,omitempty"`
+		BenchGoRoot string            `toml:"gorootbench,omitempty"`
 		BuildEnv    []string          `toml:"envbuild"`
 		ExecEnv     []string          `toml:"envexec"`
 		PGOFiles    map[string]string `toml:"pgofiles"`
 		Diagnostics []string          `toml:"diagnostics"`
+
+		EnvPassthrough []string `toml:"envpassthrough,omitempty"`
+		GoExperiment   string   `toml:"goexperiment,omitempty"`
+		GoFlags        []string `toml:"goflags,omitempty"`
 	}
 	type configFile struct {
 		Configs []*config `toml:"config"`
@@ -107,10 +184,15 @@ func ConfigFileMarshalTOML(c *ConfigFile) ([]byte, error) {
 		var cfg config
 		cfg.Name = c.Name
 		cfg.GoRoot = c.GoRoot
+		cfg.BuildGoRoot = c.BuildGoRoot
+		cfg.BenchGoRoot = c.BenchGoRoot
 		cfg.BuildEnv = c.BuildEnv.Collapse()
 		cfg.ExecEnv = c.ExecEnv.Collapse()
 		cfg.PGOFiles = c.PGOFiles
 		cfg.Diagnostics = c.Diagnostics.Strings()
+		cfg.EnvPassthrough = c.EnvPassthrough
+		cfg.GoExperiment = c.GoExperiment
+		cfg.GoFlags = c.GoFlags
 
 		cfgs.Configs = append(cfgs.Configs, &cfg)
 	}
@@ -5,6 +5,7 @@
 package common_test
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -16,8 +17,11 @@ func TestConfigMarshalTOML(t *testing.T) {
 	cfgsBefore := common.ConfigFile{
 		Configs: []*common.Config{
 			&common.Config{
-				Name:   "go",
-				GoRoot: "/path/to/my/goroot",
+				Name:         "go",
+				GoRoot:       "/path/to/my/goroot",
+				BuildGoRoot:  "/path/to/my/other/goroot",
+				GoExperiment: "greenteagc",
+				GoFlags:      []string{"-gcflags=all=-d=checkptr", "-trimpath"},
 				// The unmarashaler propagates the environment,
 				// so to make sure this works, let's also seed
 				// from the environment.
@@ -47,6 +51,18 @@ func TestConfigMarshalTOML(t *testing.T) {
 		if cfgBefore.GoRoot != cfgAfter.GoRoot {
 			t.Fatalf("unexpected GOROOT: got %s, want %s", cfgAfter.GoRoot, cfgBefore.GoRoot)
 		}
+		if cfgBefore.BuildGoRoot != cfgAfter.BuildGoRoot {
+			t.Fatalf("unexpected build GOROOT: got %s, want %s", cfgAfter.BuildGoRoot, cfgBefore.BuildGoRoot)
+		}
+		if cfgBefore.BenchGoRoot != cfgAfter.BenchGoRoot {
+			t.Fatalf("unexpected bench GOROOT: got %s, want %s", cfgAfter.BenchGoRoot, cfgBefore.BenchGoRoot)
+		}
+		if cfgBefore.GoExperiment != cfgAfter.GoExperiment {
+			t.Fatalf("unexpected GOEXPERIMENT: got %s, want %s", cfgAfter.GoExperiment, cfgBefore.GoExperiment)
+		}
+		if !reflect.DeepEqual(cfgBefore.GoFlags, cfgAfter.GoFlags) {
+			t.Fatalf("unexpected GOFLAGS: got %q, want %q", cfgAfter.GoFlags, cfgBefore.GoFlags)
+		}
 		compareEnvs(t, cfgBefore.BuildEnv.Env, cfgAfter.BuildEnv.Env)
 		compareEnvs(t, cfgBefore.ExecEnv.Env, cfgAfter.ExecEnv.Env)
 	}
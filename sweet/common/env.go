@@ -7,12 +7,25 @@ package common
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 type Env struct {
 	parent *Env
 	data   map[string]string
+
+	// environ indicates that data is a snapshot of the process's
+	// environment, from NewEnvFromEnviron.
+	environ bool
+
+	// passthrough, if non-nil, replaces data with the variables in the
+	// process's environment that it names, as of each Lookup or Collapse.
+	// See Passthrough.
+	passthrough []string
+
+	// unset is the set of variables removed by this layer. See Unset.
+	unset map[string]bool
 }
 
 func varsToMap(vars ...string) (map[string]string, error) {
@@ -32,6 +45,7 @@ func NewEnvFromEnviron() *Env {
 	if err != nil {
 		panic(err)
 	}
+	env.environ = true
 	return env
 }
 
@@ -43,6 +57,10 @@ func NewEnv(vars ...string) (*Env, error) {
 	return &Env{data: m}, nil
 }
 
+// Set returns a new Env with vars, each of the form "X=Y", set on top of
+// e, which is left unchanged. A variable that's already set in e is
+// overwritten, and if vars sets the same variable more than once, the
+// last value wins.
 func (e *Env) Set(vars ...string) (*Env, error) {
 	m, err := varsToMap(vars...)
 	if err != nil {
@@ -54,6 +72,7 @@ func (e *Env) Set(vars ...string) (*Env, error) {
 	}, nil
 }
 
+// MustSet is like Set, but panics if any of vars isn't of the form "X=Y".
 func (e *Env) MustSet(vars ...string) *Env {
 	env, err := e.Set(vars...)
 	if err != nil {
@@ -62,17 +81,80 @@ func (e *Env) MustSet(vars ...string) *Env {
 	return env
 }
 
+// Unset returns a new Env without the variables named in names, even if
+// they're set in e, which is left unchanged. They may be set again on
+// the returned Env.
+func (e *Env) Unset(names ...string) *Env {
+	unset := make(map[string]bool)
+	for _, name := range names {
+		unset[name] = true
+	}
+	return &Env{parent: e, unset: unset}
+}
+
+// Passthrough returns a copy of e that, instead of everything in the
+// process's environment as inherited through NewEnvFromEnviron, only
+// includes the variables named in names, e.g. TMPDIR. A name ending in *
+// matches every variable with that prefix, e.g. COCKROACH_*. They're read
+// from the process's environment whenever the returned Env is looked up
+// or collapsed, and variables set on e still take precedence.
+func (e *Env) Passthrough(names ...string) *Env {
+	if e == nil {
+		return nil
+	}
+	if e.environ {
+		return &Env{passthrough: append([]string{}, names...)}
+	}
+	return &Env{
+		parent:      e.parent.Passthrough(names...),
+		data:        e.data,
+		passthrough: e.passthrough,
+		unset:       e.unset,
+	}
+}
+
+// vars returns the variables set by e itself, excluding its parents.
+func (e *Env) vars() map[string]string {
+	if e.passthrough == nil {
+		return e.data
+	}
+	m := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		for _, name := range e.passthrough {
+			if k == name || strings.HasSuffix(name, "*") && strings.HasPrefix(k, strings.TrimSuffix(name, "*")) {
+				m[k] = v
+				break
+			}
+		}
+	}
+	return m
+}
+
 func (e *Env) Lookup(name string) (string, bool) {
 	t := e
 	for t != nil {
-		if v, ok := t.data[name]; ok {
+		if v, ok := t.vars()[name]; ok {
 			return v, true
 		}
+		if t.unset[name] {
+			break
+		}
 		t = t.parent
 	}
 	return "", false
 }
 
+// Has reports whether the variable name is set in e, even if to the
+// empty string.
+func (e *Env) Has(name string) bool {
+	_, ok := e.Lookup(name)
+	return ok
+}
+
 func (e *Env) Prefix(name, prefix string) *Env {
 	var (
 		n   *Env
@@ -91,15 +173,38 @@ func (e *Env) Prefix(name, prefix string) *Env {
 	return n
 }
 
+// PrependPath returns a new Env with dir at the front of the list of paths
+// in the variable name, e.g. PATH, removing any other occurrences of dir
+// from the list, so that prepending the same directory again doesn't
+// change anything. If name isn't set, or is empty, it's set to just dir.
+func (e *Env) PrependPath(name, dir string) *Env {
+	paths := []string{dir}
+	if v, ok := e.Lookup(name); ok && v != "" {
+		for _, p := range filepath.SplitList(v) {
+			if filepath.Clean(p) != filepath.Clean(dir) {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return e.MustSet(name + "=" + strings.Join(paths, string(os.PathListSeparator)))
+}
+
+// Collapse returns the variables in e, each of the form "X=Y", in no
+// particular order. Each variable appears once, with the value from
+// whichever Set last set it.
 func (e *Env) Collapse() []string {
 	t := e
 	c := make(map[string]string)
+	unset := make(map[string]bool)
 	for t != nil {
-		for k, v := range t.data {
-			if _, ok := c[k]; !ok {
+		for k, v := range t.vars() {
+			if _, ok := c[k]; !ok && !unset[k] {
 				c[k] = v
 			}
 		}
+		for k := range t.unset {
+			unset[k] = true
+		}
 		t = t.parent
 	}
 	env := make([]string, 0, len(c))
@@ -5,7 +5,9 @@
 package common_test
 
 import (
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"golang.org/x/benchmarks/sweet/common"
@@ -101,4 +103,98 @@ func TestEnv(t *testing.T) {
 		tryLookup(t, env2, "MYVAR", "32")
 		tryLookup(t, env, "MYVAR", "2")
 	})
+	t.Run("Has", func(t *testing.T) {
+		if !env.Has("MYVAR") {
+			t.Fatalf("expected to find variable %q", "MYVAR")
+		}
+		if env.Has("NOVAR") {
+			t.Fatalf("expected to not find variable %q", "NOVAR")
+		}
+		if env.Unset("MYVAR").Has("MYVAR") {
+			t.Fatalf("expected to not find unset variable %q", "MYVAR")
+		}
+	})
+	t.Run("Overwrite", func(t *testing.T) {
+		env2 := trySet(t, env, "MYVAR=3", "OTHERVAR=5", "OTHERVAR=6")
+		tryLookup(t, env2, "MYVAR", "3")
+		tryLookup(t, env2, "OTHERVAR", "6")
+		l := stringSliceToSet(env2.Collapse())
+		if !reflect.DeepEqual(l, exp) {
+			t.Fatalf("on collapse got %v, expected %v", l, exp)
+		}
+	})
+	t.Run("Unset", func(t *testing.T) {
+		env2 := trySet(t, env, "OTHERVAR=6").Unset("MYVAR", "OTHERVAR")
+		tryBadLookup(t, env2, "MYVAR")
+		tryBadLookup(t, env2, "OTHERVAR")
+		tryLookup(t, env2, "MYVAR2", "100")
+		tryLookup(t, env, "MYVAR", "2")
+		exp := stringSliceToSet([]string{"MYVAR2=100"})
+		if l := stringSliceToSet(env2.Collapse()); !reflect.DeepEqual(l, exp) {
+			t.Fatalf("on collapse got %v, expected %v", l, exp)
+		}
+	})
+	t.Run("SetAfterUnset", func(t *testing.T) {
+		env2 := trySet(t, env.Unset("MYVAR"), "MYVAR=3", "OTHERVAR=6")
+		tryLookup(t, env2, "MYVAR", "3")
+		l := stringSliceToSet(env2.Collapse())
+		if !reflect.DeepEqual(l, exp) {
+			t.Fatalf("on collapse got %v, expected %v", l, exp)
+		}
+	})
+}
+
+func TestEnvPassthrough(t *testing.T) {
+	t.Setenv("SWEET_TEST_KEPT", "1")
+	t.Setenv("SWEET_TEST_PREFIX_A", "a")
+	t.Setenv("SWEET_TEST_PREFIX_B", "b")
+	t.Setenv("SWEET_TEST_DROPPED", "x")
+
+	env := common.NewEnvFromEnviron().MustSet("MYVAR=2", "SWEET_TEST_PREFIX_B=override")
+	env = env.Passthrough("SWEET_TEST_KEPT", "SWEET_TEST_PREFIX_*").MustSet("OTHERVAR=6")
+
+	// Variables are read from the environment when they're used.
+	t.Setenv("SWEET_TEST_KEPT", "2")
+	exp := stringSliceToSet([]string{
+		"SWEET_TEST_KEPT=2",
+		"SWEET_TEST_PREFIX_A=a",
+		"SWEET_TEST_PREFIX_B=override",
+		"MYVAR=2",
+		"OTHERVAR=6",
+	})
+	if l := stringSliceToSet(env.Collapse()); !reflect.DeepEqual(l, exp) {
+		t.Fatalf("on collapse got %v, expected %v", l, exp)
+	}
+	if v, ok := env.Lookup("SWEET_TEST_DROPPED"); ok {
+		t.Fatalf("expected to not find variable %q, got %q", "SWEET_TEST_DROPPED", v)
+	}
+}
+
+func TestEnvPrependPath(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	join := func(paths ...string) string {
+		return strings.Join(paths, sep)
+	}
+	env, err := common.NewEnv("PATH=" + join("/usr/bin", "/go/bin", "/bin"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	for _, test := range []struct {
+		name string
+		env  *common.Env
+		want string
+	}{
+		{"New", env.PrependPath("PATH", "/new/bin"), join("/new/bin", "/usr/bin", "/go/bin", "/bin")},
+		{"Existing", env.PrependPath("PATH", "/go/bin"), join("/go/bin", "/usr/bin", "/bin")},
+		{"Unclean", env.PrependPath("PATH", "/go/bin/"), join("/go/bin/", "/usr/bin", "/bin")},
+		{"Twice", env.PrependPath("PATH", "/new/bin").PrependPath("PATH", "/new/bin"), join("/new/bin", "/usr/bin", "/go/bin", "/bin")},
+		{"Unset", env.Unset("PATH").PrependPath("PATH", "/new/bin"), "/new/bin"},
+		{"Empty", env.MustSet("PATH=").PrependPath("PATH", "/new/bin"), "/new/bin"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got, _ := test.env.Lookup("PATH"); got != test.want {
+				t.Errorf("got PATH=%q, expected %q", got, test.want)
+			}
+		})
+	}
 }
@@ -0,0 +1,87 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of an Event.
+type EventType string
+
+const (
+	// EventPhaseStart and EventPhaseEnd bracket a phase of a benchmark
+	// under a configuration: fetching its source, building it, or
+	// running it. See Event.Phase.
+	EventPhaseStart EventType = "phase-start"
+	EventPhaseEnd   EventType = "phase-end"
+
+	// EventBenchmarkStart is emitted by harnesses as they start each
+	// of the benchmarks they run, e.g. "kv95/nodes=3" for cockroachdb.
+	EventBenchmarkStart EventType = "benchmark-start"
+
+	// EventBenchmarkResult carries a single result, as it's written to
+	// the results file.
+	EventBenchmarkResult EventType = "benchmark-result"
+
+	// EventError reports that a benchmark failed.
+	EventError EventType = "error"
+)
+
+// Event is a single event in the progress of a run, in the form written
+// to RunConfig.EventsWriter, one JSON object per line.
+type Event struct {
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+
+	Type EventType `json:"type"`
+
+	// Benchmark is the name of the Sweet benchmark the event is about,
+	// e.g. "cockroachdb", and Config is that of the configuration.
+	Benchmark string `json:"benchmark,omitempty"`
+	Config    string `json:"config,omitempty"`
+
+	// Phase is the phase that started or ended: "get", "build" or
+	// "run".
+	Phase string `json:"phase,omitempty"`
+
+	// Name is the harness's name for the benchmark that started, e.g.
+	// "kv95/nodes=3", for EventBenchmarkStart.
+	Name string `json:"name,omitempty"`
+
+	// Seconds is how long the phase took, for EventPhaseEnd.
+	Seconds float64 `json:"seconds,omitempty"`
+
+	// Result is the result, for EventBenchmarkResult.
+	Result *Result `json:"result,omitempty"`
+
+	// Error is the error a phase ended with, for EventPhaseEnd, or the
+	// benchmark failed with, for EventError.
+	Error string `json:"error,omitempty"`
+}
+
+// eventsMu serializes writes of events, which may come from benchmarks
+// being built in parallel, so that their lines don't interleave.
+var eventsMu sync.Mutex
+
+// WriteEvent writes e to w as a single line of JSON, setting its Time to
+// now if it's zero. It's safe to call concurrently with the same w,
+// since the line is written with one call to w.Write, under a lock.
+func WriteEvent(w io.Writer, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
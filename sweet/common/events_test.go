@@ -0,0 +1,55 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestResultEventsWriter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := common.WriteEvent(&buf, common.Event{Type: common.EventPhaseStart, Benchmark: "cockroachdb", Phase: "run"}); err != nil {
+		t.Fatal(err)
+	}
+	w := common.NewResultEventsWriter(&buf, "cockroachdb", "base")
+	if _, err := w.Write([]byte("goos: linux\nBenchmarkCockroachDBkv0/nodes=1-8 1 52000 ns/op 1500 write-ops/sec\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []common.Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e common.Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event %+v has no time", e)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d events, expected 2: %+v", len(got), got)
+	}
+	if got[0].Type != common.EventPhaseStart || got[0].Phase != "run" {
+		t.Errorf("got first event %+v, expected the start of the run phase", got[0])
+	}
+	want := &common.Result{
+		Benchmark:  "cockroachdb",
+		Config:     "base",
+		Name:       "BenchmarkCockroachDBkv0/nodes=1-8",
+		Iterations: 1,
+		NsPerOp:    52000,
+		Metrics:    map[string]float64{"write-ops/sec": 1500},
+	}
+	if e := got[1]; e.Type != common.EventBenchmarkResult || e.Benchmark != "cockroachdb" || e.Config != "base" || !reflect.DeepEqual(e.Result, want) {
+		t.Errorf("got second event %+v with result %+v, expected a result event with %+v", e, e.Result, want)
+	}
+}
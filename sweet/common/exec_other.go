@@ -0,0 +1,19 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows || plan9 || wasm
+
+package common
+
+import "os/exec"
+
+// SetProcessGroup has no effect on this platform, which doesn't have
+// process groups.
+func SetProcessGroup(cmd *exec.Cmd) {}
+
+// KillProcessGroup kills cmd's process. Processes it started are not
+// killed.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !wasm
+
+package common
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// SetProcessGroup arranges for cmd, which must not have started yet, to
+// run in a new process group of its own, which the processes it starts
+// join too, so that KillProcessGroup can kill them all at once. It has no
+// effect on platforms without process groups.
+//
+// Note that processes in their own group aren't sent the terminal's
+// signals, like SIGINT on ^C.
+func SetProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// KillProcessGroup kills cmd's process and, if cmd runs in its own
+// process group, every other process in the group, even if cmd's process
+// has already exited.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Kill()
+	}
+	// The group's ID is the ID of its leader, cmd's process, and a
+	// negative PID signals the whole group.
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
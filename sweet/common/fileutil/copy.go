@@ -120,3 +120,67 @@ func CopyDir(dst, src string, srcFS fs.FS) error {
 	}
 	return nil
 }
+
+// CopyTree recursively copies the directory at path src to dst, like
+// CopyDir, except that symbolic links are copied verbatim rather than
+// rejected.
+func CopyTree(dst, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return CopyFile(target, path, nil, nil)
+	})
+}
+
+// MoveDirContents moves everything in the directory src into the
+// directory dst, creating dst if necessary and leaving src empty. Each
+// entry is renamed if possible, or else, e.g. if dst is on a different
+// file system, copied with CopyTree and then removed.
+func MoveDirContents(dst, src string) error {
+	des, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, de := range des {
+		d, s := filepath.Join(dst, de.Name()), filepath.Join(src, de.Name())
+		if err := os.Rename(s, d); err == nil {
+			continue
+		}
+		if de.IsDir() {
+			err = CopyTree(d, s)
+		} else if de.Type()&fs.ModeSymlink != 0 {
+			var link string
+			if link, err = os.Readlink(s); err == nil {
+				err = os.Symlink(link, d)
+			}
+		} else {
+			err = CopyFile(d, s, nil, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("moving %s to %s: %w", s, d, err)
+		}
+		if err := os.RemoveAll(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
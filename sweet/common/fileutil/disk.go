@@ -0,0 +1,37 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fileutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrFreeSpaceUnsupported is returned by FreeSpace on platforms where the
+// free space of a file system can't be determined.
+var ErrFreeSpaceUnsupported = errors.New("free disk space unavailable on this platform")
+
+// FreeSpace returns the number of bytes available to unprivileged users
+// on the file system containing path. If path doesn't exist yet, it
+// reports on the file system of its nearest ancestor that does, where
+// path would be created.
+func FreeSpace(path string) (uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return freeSpace(path)
+}
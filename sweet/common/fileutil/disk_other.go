@@ -0,0 +1,11 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd
+
+package fileutil
+
+func freeSpace(path string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -5,10 +5,14 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 
 	"golang.org/x/benchmarks/sweet/common/log"
 )
@@ -17,6 +21,10 @@ type Go struct {
 	Tool       string
 	Env        *Env
 	PassOutput bool
+
+	// DryRun indicates that commands should be printed with
+	// log.DryRunCommand instead of executed. See Config.DryRun.
+	DryRun bool
 }
 
 func SystemGoTool() (*Go, error) {
@@ -30,8 +38,36 @@ func SystemGoTool() (*Go, error) {
 	}, nil
 }
 
+// CommandContext is like exec.CommandContext, except that on platforms
+// that support it, the command runs in its own process group, and when
+// ctx is done the whole group is killed. That way processes the command
+// starts, like the compiler under go build, don't outlive it.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	SetProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return KillProcessGroup(cmd)
+	}
+	return cmd
+}
+
 func (g *Go) Do(dir string, args ...string) error {
-	cmd := exec.Command(g.Tool, args...)
+	return g.DoContext(context.Background(), dir, args...)
+}
+
+// DoContext is like Do, but kills the go command, and everything it
+// started, if ctx is done before it completes. The error returned then
+// wraps ctx's error, e.g. context.DeadlineExceeded.
+func (g *Go) DoContext(ctx context.Context, dir string, args ...string) error {
+	err := g.do(ctx, dir, args...)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("go %s: %w: %v", strings.Join(args, " "), ctx.Err(), err)
+	}
+	return err
+}
+
+func (g *Go) do(ctx context.Context, dir string, args ...string) error {
+	cmd := CommandContext(ctx, g.Tool, args...)
 	if dir != "" {
 		cmd.Dir = dir
 	}
@@ -41,17 +77,13 @@ func (g *Go) Do(dir string, args ...string) error {
 		cmd.Stderr = os.Stderr
 	}
 	log.TraceCommand(cmd, false)
-	if g.PassOutput {
-		return cmd.Run()
-	}
-	// Use cmd.Output to get an ExitError with Stderr populated.
-	_, err := cmd.Output()
-	if ee, ok := err.(*exec.ExitError); ok {
-		// ExitError includes stderr, but doesn't inclue it in Error.
-		// Create a new error that does display stderr.
-		return fmt.Errorf("%w. stderr:\n%s", err, ee.Stderr)
+	if g.DryRun {
+		log.DryRunCommand(cmd)
+		return nil
 	}
-	return err
+	// Include the end of the output in the error, so that the reason the
+	// command failed is at hand even if the output was passed through.
+	return RunCommand(cmd)
 }
 
 func (g *Go) List(args ...string) ([]byte, error) {
@@ -61,6 +93,95 @@ func (g *Go) List(args ...string) ([]byte, error) {
 	return cmd.Output()
 }
 
+// Version returns the output of "go version" for g, e.g.
+// "go version go1.22.0 linux/amd64".
+func (g *Go) Version() (string, error) {
+	cmd := exec.Command(g.Tool, "version")
+	cmd.Env = g.Env.Collapse()
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// releaseVersions caches the result of Go.ReleaseVersion for each
+// toolchain, by path.
+var releaseVersions sync.Map // string -> GoVersion
+
+// ReleaseVersion returns the release of Go that g is, e.g. 1.22.0, for
+// deciding which features the toolchain supports. Development toolchains
+// report the release they're developing. The result is cached, so
+// asking again doesn't run "go version" again.
+func (g *Go) ReleaseVersion() (GoVersion, error) {
+	if v, ok := releaseVersions.Load(g.Tool); ok {
+		return v.(GoVersion), nil
+	}
+	out, err := g.Version()
+	if err != nil {
+		return GoVersion{}, err
+	}
+	// e.g. "go version go1.22.0 linux/amd64", or for a development
+	// toolchain, "go version devel go1.23-5e4e3b7 Tue Jan 2 ... linux/amd64".
+	fields := strings.Fields(out)
+	if len(fields) > 3 && fields[2] == "devel" {
+		fields = append(fields[:2], fields[3:]...)
+		fields[2], _, _ = strings.Cut(fields[2], "-")
+	}
+	if len(fields) < 3 {
+		return GoVersion{}, fmt.Errorf("unexpected output from go version: %q", out)
+	}
+	v, err := ParseGoVersion(fields[2])
+	if err != nil {
+		return GoVersion{}, fmt.Errorf("unexpected output from go version: %q: %w", out, err)
+	}
+	releaseVersions.Store(g.Tool, v)
+	return v, nil
+}
+
+// GoVersion is a Go release version.
+type GoVersion struct {
+	Major, Minor, Patch int
+}
+
+// ParseGoVersion parses a Go version like "go1.22.0" or "go1.22". The
+// patch version of a prerelease, like "go1.22rc1", is zero.
+func ParseGoVersion(s string) (GoVersion, error) {
+	rest, ok := strings.CutPrefix(s, "go")
+	if !ok {
+		return GoVersion{}, fmt.Errorf("malformed Go version %q", s)
+	}
+	if i := strings.IndexAny(rest, "abcdefghijklmnopqrstuvwxyz"); i >= 0 {
+		rest = rest[:i]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return GoVersion{}, fmt.Errorf("malformed Go version %q", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return GoVersion{}, fmt.Errorf("malformed Go version %q", s)
+		}
+		nums[i] = n
+	}
+	return GoVersion{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// AtLeast reports whether v is release major.minor or later.
+func (v GoVersion) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+func (v GoVersion) String() string {
+	return fmt.Sprintf("go%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
 func (g *Go) GOROOT() string {
 	return filepath.Dir(filepath.Dir(g.Tool))
 }
@@ -73,9 +194,21 @@ func (g *Go) BuildPackage(pkg, out string) error {
 }
 
 func (g *Go) BuildPath(path, out string, args ...string) error {
+	return g.BuildPathContext(context.Background(), path, out, args...)
+}
+
+// BuildPathContext is like BuildPath, but gives up on the build if ctx is
+// done before it completes, as DoContext does.
+func (g *Go) BuildPathContext(ctx context.Context, path, out string, args ...string) error {
 	if path[0] != '/' && path[0] != '.' {
 		path = "./" + path
 	}
+	args = append([]string{"build", "-o", out}, args...)
+	if g.DryRun {
+		// Don't change directory, but make sure it shows up as the
+		// working directory of the printed command.
+		return g.DoContext(ctx, path, args...)
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current working directory: %w", err)
@@ -84,8 +217,54 @@ func (g *Go) BuildPath(path, out string, args ...string) error {
 	if err := chdir(path); err != nil {
 		return fmt.Errorf("failed to enter build directory: %w", err)
 	}
-	args = append([]string{"build", "-o", out}, args...)
-	return g.Do("", args...)
+	return g.DoContext(ctx, "", args...)
+}
+
+// BuildOptions are options for go build, for Go.BuildPathOpts.
+type BuildOptions struct {
+	// Tags are the build tags to build with, for -tags.
+	Tags []string
+
+	// Ldflags are the flags to pass to the linker, for -ldflags. Flags
+	// must not contain spaces.
+	Ldflags []string
+
+	// PGO, if non-empty, is the path to a profile to build with, for
+	// -pgo.
+	PGO string
+
+	// Trimpath indicates whether to build with -trimpath.
+	Trimpath bool
+
+	// Args are any further arguments to go build, passed after the
+	// flags for the options above.
+	Args []string
+}
+
+// Flags returns the arguments to go build for o, in a fixed order: -tags,
+// -ldflags, -pgo, -trimpath and then Args. Options that aren't set
+// are omitted.
+func (o *BuildOptions) Flags() []string {
+	var flags []string
+	if len(o.Tags) != 0 {
+		flags = append(flags, "-tags="+strings.Join(o.Tags, ","))
+	}
+	if len(o.Ldflags) != 0 {
+		flags = append(flags, "-ldflags="+strings.Join(o.Ldflags, " "))
+	}
+	if o.PGO != "" {
+		flags = append(flags, "-pgo="+o.PGO)
+	}
+	if o.Trimpath {
+		flags = append(flags, "-trimpath")
+	}
+	return append(flags, o.Args...)
+}
+
+// BuildPathOpts is like BuildPathContext, but takes its arguments to go
+// build as options.
+func (g *Go) BuildPathOpts(ctx context.Context, path, out string, opts *BuildOptions) error {
+	return g.BuildPathContext(ctx, path, out, opts.Flags()...)
 }
 
 func chdir(path string) error {
@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestBuildOptionsFlags(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts common.BuildOptions
+		want []string
+	}{
+		{"Empty", common.BuildOptions{}, nil},
+		{
+			"All",
+			common.BuildOptions{
+				Args:     []string{"-modfile=go.bench.mod"},
+				Trimpath: true,
+				PGO:      "/tmp/default.pgo",
+				Ldflags:  []string{"-checklinkname=0", "-s"},
+				Tags:     []string{"netgo", "osusergo"},
+			},
+			[]string{
+				"-tags=netgo,osusergo",
+				"-ldflags=-checklinkname=0 -s",
+				"-pgo=/tmp/default.pgo",
+				"-trimpath",
+				"-modfile=go.bench.mod",
+			},
+		},
+		{
+			"Some",
+			common.BuildOptions{PGO: "/tmp/default.pgo", Ldflags: []string{"-checklinkname=0"}},
+			[]string{"-ldflags=-checklinkname=0", "-pgo=/tmp/default.pgo"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.opts.Flags(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got flags %q, expected %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseGoVersion(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want common.GoVersion
+	}{
+		{"go1.22.0", common.GoVersion{1, 22, 0}},
+		{"go1.23", common.GoVersion{1, 23, 0}},
+		{"go1.21.13", common.GoVersion{1, 21, 13}},
+		{"go1.23rc1", common.GoVersion{1, 23, 0}},
+	} {
+		got, err := common.ParseGoVersion(test.in)
+		if err != nil {
+			t.Errorf("ParseGoVersion(%q): unexpected err: %v", test.in, err)
+		} else if got != test.want {
+			t.Errorf("ParseGoVersion(%q) = %v, expected %v", test.in, got, test.want)
+		}
+	}
+	for _, in := range []string{"", "1.22.0", "go1", "go1.x", "go1.2.3.4", "devel"} {
+		if v, err := common.ParseGoVersion(in); err == nil {
+			t.Errorf("ParseGoVersion(%q) = %v, expected an error", in, v)
+		}
+	}
+}
+
+func TestGoVersionAtLeast(t *testing.T) {
+	v := common.GoVersion{1, 23, 4}
+	for _, test := range []struct {
+		major, minor int
+		want         bool
+	}{
+		{1, 22, true},
+		{1, 23, true},
+		{1, 24, false},
+		{2, 0, false},
+		{0, 99, true},
+	} {
+		if got := v.AtLeast(test.major, test.minor); got != test.want {
+			t.Errorf("%v.AtLeast(%d, %d) = %v, expected %v", v, test.major, test.minor, got, test.want)
+		}
+	}
+}
+
+func TestReleaseVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake go tool is a shell script")
+	}
+	for _, test := range []struct {
+		name, out string
+		want      common.GoVersion
+	}{
+		{"Release", "go version go1.22.3 linux/amd64", common.GoVersion{1, 22, 3}},
+		{"Devel", "go version devel go1.24-5e4e3b7 Tue Jan 2 15:04:05 2024 +0000 linux/amd64", common.GoVersion{1, 24, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tool := filepath.Join(t.TempDir(), "go")
+			if err := os.WriteFile(tool, []byte("#!/bin/sh\necho '"+test.out+"'\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			g := &common.Go{Tool: tool, Env: common.NewEnvFromEnviron()}
+			got, err := g.ReleaseVersion()
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got version %v, expected %v", got, test.want)
+			}
+		})
+	}
+}
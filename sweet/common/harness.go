@@ -4,7 +4,24 @@
 
 package common
 
-import "os"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/common/fileutil"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// ErrOOMKilled is wrapped by errors from running a benchmark whose
+// process, or a process it started, was killed by the kernel for running
+// out of memory, to tell it apart from a crash or a timeout.
+var ErrOOMKilled = errors.New("OOMKilled")
 
 type GetConfig struct {
 	// SrcDir is the path to the directory that the harness should write
@@ -19,6 +36,82 @@ type GetConfig struct {
 	// for testing. Guaranteed to be the same as BuildConfig.Short and
 	// RunConfig.Short.
 	Short bool
+
+	// Retries is the number of times the harness may retry fetching
+	// source code that failed to be retrieved, for instance due to
+	// a flaky network.
+	Retries int
+
+	// LocalSrc, if non-empty, is the path to an existing checkout of the
+	// benchmark's source code. Harnesses that support it link this
+	// checkout into SrcDir instead of fetching source from a remote
+	// source.
+	//
+	// Note that because the checkout is linked rather than copied, the
+	// build may write into it.
+	LocalSrc string
+
+	// ExpectedTreeHash, if non-empty, is the git tree hash the fetched
+	// source must have, for harnesses that support it. If the checked out
+	// tree differs, e.g. because a mirror served tampered history, the
+	// source is deleted and Get fails. Harnesses that fetch submodules
+	// also check that each is checked out at the commit the tree pins.
+	//
+	// It does not apply to LocalSrc.
+	ExpectedTreeHash string
+
+	// MirrorBase, if non-empty, is the base URL of a mirror of
+	// github.com to fetch source from instead, such that
+	// https://github.com/org/repo is available at MirrorBase/org/repo.
+	// Submodules are fetched through the mirror too.
+	MirrorBase string
+
+	// Commit, if non-empty, is the commit to check out instead of the
+	// one the harness pins, for harnesses that support it. It's a full
+	// commit hash, or a ref, like a branch or tag, that resolves to a
+	// commit in the fetched repository.
+	//
+	// It does not apply to LocalSrc.
+	Commit string
+
+	// Shallow is whether harnesses that support it may fetch only the
+	// commit they build, and not its history, which is much faster for
+	// large repositories. They fall back to fetching the history if the
+	// server doesn't allow fetching a single commit.
+	Shallow bool
+
+	// Stats, if non-nil, is where harnesses that support it record what
+	// they fetched, and how long it took.
+	Stats *GetStats
+}
+
+// GetStats records the fetches of source done by Harness.Get.
+type GetStats struct {
+	Fetches []FetchStat `json:"fetches"`
+}
+
+// FetchStat describes the fetch of a repository, or of one of its
+// submodules, including any submodules of its own.
+type FetchStat struct {
+	URL string `json:"url"`
+
+	// Path is the submodule's path in the repository, or empty for the
+	// repository itself.
+	Path string `json:"path,omitempty"`
+
+	// Bytes is the size of the objects fetched, as stored on disk, which
+	// is close to how much was transferred.
+	Bytes int64 `json:"bytes"`
+
+	// Seconds is how long the fetch took.
+	Seconds float64 `json:"seconds"`
+}
+
+// Add records f, if s is non-nil.
+func (s *GetStats) Add(f ...FetchStat) {
+	if s != nil {
+		s.Fetches = append(s.Fetches, f...)
+	}
 }
 
 type BuildConfig struct {
@@ -40,6 +133,92 @@ type BuildConfig struct {
 	// for testing. Guaranteed to be the same as GetConfig.Short and
 	// RunConfig.Short.
 	Short bool
+
+	// CacheDir, if non-empty, is the path to a directory that persists
+	// across builds, in which harnesses may cache expensive intermediate
+	// build artifacts.
+	//
+	// Harnesses must key the contents of CacheDir such that stale
+	// artifacts are never reused, e.g. by the commit being built.
+	CacheDir string
+
+	// PGOProfile, if non-empty, is the path to a CPU profile that
+	// harnesses should pass with -pgo when building the benchmarked
+	// application, making the build profile-guided.
+	PGOProfile string
+
+	// Timeout is the maximum amount of time building the benchmark may
+	// take. Harnesses kill the build, including any processes it started,
+	// if it takes longer. See BuildContext.
+	//
+	// Zero means no timeout. Not all harnesses support this field.
+	Timeout time.Duration
+
+	// Static indicates that harnesses should link the benchmarked
+	// application statically, so that it runs without the shared
+	// libraries of the host that built it, e.g. in a minimal container.
+	// Harnesses that support it but can't link statically on this
+	// platform fail the build rather than produce a dynamic binary.
+	//
+	// Not all harnesses support this field.
+	Static bool
+
+	// ForceCDeps indicates that harnesses that keep the C dependencies
+	// they build for later builds of the same source should build them
+	// again anyway.
+	//
+	// Not all harnesses support this field.
+	ForceCDeps bool
+
+	// Output is where harnesses should stream the output of long-running
+	// build steps, such as progress from external build tools, as it is
+	// produced. If nil, that output is discarded.
+	//
+	// Output is typically a per-benchmark build log, so harnesses should
+	// write to it rather than to os.Stdout or os.Stderr directly.
+	Output io.Writer
+
+	// Ctx, if non-nil, is canceled if sweet is interrupted, e.g. with
+	// Ctrl-C. The context from BuildContext derives from it.
+	Ctx context.Context
+
+	// GoExperiment and GoFlags are the configuration's Config.GoExperiment
+	// and Config.GoFlags, which harnesses that support them build the
+	// benchmarked application with. See GoEnv.
+	GoExperiment string
+	GoFlags      []string
+}
+
+// GoEnv returns env, the environment to build the benchmarked application
+// in, with GOEXPERIMENT set to b.GoExperiment, if it's non-empty, and
+// b.GoFlags added to any GOFLAGS env already has.
+func (b *BuildConfig) GoEnv(env *Env) *Env {
+	if b.GoExperiment != "" {
+		env = env.MustSet("GOEXPERIMENT=" + b.GoExperiment)
+	}
+	if len(b.GoFlags) != 0 {
+		flags := b.GoFlags
+		if v, ok := env.Lookup("GOFLAGS"); ok && v != "" {
+			flags = append([]string{v}, flags...)
+		}
+		env = env.MustSet("GOFLAGS=" + strings.Join(flags, " "))
+	}
+	return env
+}
+
+// BuildContext returns a context for the commands that build a benchmark,
+// which expires after b.Timeout, if it's non-zero, or once b.Ctx is done.
+// The returned function must be called once the build is done to release
+// its resources.
+func (b *BuildConfig) BuildContext() (context.Context, context.CancelFunc) {
+	ctx := b.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if b.Timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.Timeout)
 }
 
 type RunConfig struct {
@@ -71,10 +250,394 @@ type RunConfig struct {
 	// in the Go benchmark format.
 	Results *os.File
 
+	// JSONResults, if non-nil, is where harnesses should additionally
+	// write each benchmark result as a JSON-encoded Result, one per line.
+	// Harnesses typically do so by passing the output they would write to
+	// Results through a JSONResultsWriter as well.
+	JSONResults io.Writer
+
+	// TeeResults, if non-nil, is where harnesses should additionally copy
+	// the output they write to Results as it's written, e.g. stdout, for
+	// watching a long run's progress.
+	TeeResults io.Writer
+
+	// EventsWriter, if non-nil, is where harnesses should write Events
+	// as the run progresses, with WriteEvent, for tools that follow a
+	// run as it happens: an EventBenchmarkStart as they start each of
+	// the benchmarks they run, and an EventBenchmarkResult for each
+	// result, typically by passing the output they would write to
+	// Results through a NewResultEventsWriter as well. Sweet itself
+	// writes the rest.
+	EventsWriter io.Writer
+
+	// Benchmark is the name of the benchmark being run, e.g.
+	// "cockroachdb", for identifying the results written to JSONResults
+	// and the events written to EventsWriter.
+	Benchmark string
+
 	// Short indicates whether or not to run a short version of the benchmarks
 	// for testing. Guaranteed to be the same as GetConfig.Short and
 	// BuildConfig.Short.
 	Short bool
+
+	// Smoke indicates that the harness should do as little as it can that
+	// still shows the benchmark works, e.g. run one of its benchmarks
+	// rather than all of them, and none of the variants. It implies Short.
+	// The results are meaningless beyond that the benchmark ran.
+	Smoke bool
+
+	// Timeout is the maximum amount of time a single invocation of a
+	// benchmark binary may run before the harness kills it.
+	//
+	// Zero means no timeout.
+	Timeout time.Duration
+
+	// TimeoutGrace is how long a benchmark binary that timed out is given
+	// to exit cleanly once asked to, so that it can flush partial results
+	// and profiles, before the harness kills it.
+	//
+	// Zero means it's killed straight away.
+	TimeoutGrace time.Duration
+
+	// ReadyTimeout is how long the harness waits for the servers a
+	// benchmark runs against to become ready before giving up on the run.
+	// Measurement only starts once they're ready.
+	//
+	// Zero means a harness-specific default. Not all harnesses support
+	// this field.
+	ReadyTimeout time.Duration
+
+	// Warmup is the number of times the harness should run each
+	// benchmark, discarding its results, before the measured run, to
+	// bring the system to a steady state. Temporary state is cleaned up
+	// between every run, just like between measured runs.
+	//
+	// Not all harnesses support this field.
+	Warmup int
+
+	// ProfileDir, if non-empty, is the path to a directory into which
+	// the harness should write CPU and memory profiles for each
+	// benchmark it runs, and mutex and block profiles where it can,
+	// which describe contention. Profiles are
+	// named deterministically after the benchmark, so repeated runs
+	// overwrite earlier ones. Harnesses may also write a summary of how
+	// the heap grew over each benchmark.
+	//
+	// Not all harnesses support this field.
+	ProfileDir string
+
+	// MutexProfileFraction and BlockProfileRate, if non-zero, are the
+	// rates, as runtime.SetMutexProfileFraction and
+	// runtime.SetBlockProfileRate take them, at which the benchmarked
+	// application samples contention for the profiles written to
+	// ProfileDir. Sampling more makes the profiles more detailed but
+	// slows the application down more. If zero, the application's own
+	// rates are used. They have no effect without ProfileDir.
+	//
+	// Not all harnesses support these fields.
+	MutexProfileFraction int
+	BlockProfileRate     int
+
+	// ProfileSummary is whether the harness should also write a summary
+	// of each profile it writes to ProfileDir, listing the functions
+	// with the most samples as "go tool pprof -top" does, so that the
+	// profiles can be skimmed without pprof. It has no effect without
+	// ProfileDir.
+	//
+	// Not all harnesses support this field.
+	ProfileSummary bool
+
+	// TraceDir, if non-empty, is the path to a directory into which the
+	// harness should write Go execution traces of the benchmarked
+	// application for each benchmark it runs. Traces are named
+	// deterministically after the benchmark, so repeated runs overwrite
+	// earlier ones.
+	//
+	// Traces are large, often tens of megabytes per process for every
+	// second of benchmark, so this is off by default.
+	//
+	// Not all harnesses support this field.
+	TraceDir string
+
+	// FailureDir, if non-empty, is the path to a directory into which the
+	// harness should copy logs and other state useful for debugging a
+	// benchmark that fails, before it's cleaned up. For example, the
+	// server logs of each benchmark that fails are preserved under a
+	// directory named after the benchmark.
+	//
+	// Not all harnesses support this field.
+	FailureDir string
+
+	// KeepTmp indicates whether TmpDir's contents should be kept for
+	// inspection rather than deleted whenever the harness or the
+	// orchestrator cleans it up, e.g. after each run of a benchmark. They
+	// are moved into a fresh timestamped directory under ArtifactDir
+	// instead; see SaveTmp. ArtifactDir must be set too.
+	//
+	// KeepTmp is not supported with Remote.
+	KeepTmp bool
+
+	// ArtifactDir is the path to the directory TmpDir's contents are
+	// moved into when KeepTmp is set.
+	ArtifactDir string
+
+	// PGOProfile, if non-empty, is the path to which the harness should
+	// write a CPU profile of the benchmarked application, merged across
+	// all the benchmarks it runs, for use as a PGO profile in a later
+	// build. If a profile already exists at the path, e.g. from an earlier
+	// run, it is merged in as well.
+	//
+	// Not all harnesses support this field.
+	PGOProfile string
+
+	// BenchFilter, if non-empty, is a regular expression selecting which
+	// of the harness's benchmarks to run, matched against the benchmark
+	// names in the same way as `go test -run`.
+	//
+	// Not all harnesses support this field.
+	BenchFilter string
+
+	// MemLimits, if non-empty, is a set of GOMEMLIMIT values to sweep
+	// over. Each benchmark is run once for each value with GOMEMLIMIT set
+	// in its execution environment, and the names of reported results are
+	// tagged with the value, e.g. "/memlimit=2GiB".
+	//
+	// Not all harnesses support this field.
+	MemLimits []string
+
+	// GOGCValues, if non-empty, is a set of GOGC values to sweep over,
+	// in the same manner as MemLimits. A negative value means GOGC=off.
+	// Results are tagged with the value, e.g. "/gogc=200".
+	//
+	// Not all harnesses support this field.
+	GOGCValues []int
+
+	// GOMAXPROCSValues, if non-empty, is a set of GOMAXPROCS values to
+	// sweep over, in the same manner as MemLimits. Results are tagged
+	// with the value, e.g. "/gomaxprocs=8". Harnesses that run several
+	// server processes apply the value to every one of them, e.g. each
+	// node of a multi-node CockroachDB cluster. Harnesses that benchmark
+	// builds also use the value as the build's parallelism, i.e. go build
+	// -p, to study how build times scale.
+	//
+	// Not all harnesses support this field.
+	GOMAXPROCSValues []int
+
+	// NodeCounts, if non-empty, are the sizes of the clusters to run
+	// benchmarks against, replacing the harness's defaults, e.g. for
+	// studies of how a distributed system scales. Each is a separate
+	// benchmark, e.g. "kv95/nodes=5".
+	//
+	// Not all harnesses support this field.
+	NodeCounts []int
+
+	// ReadPercents, if non-empty, are the percentages of operations
+	// that are reads in the workloads benchmarks run, replacing the
+	// harness's defaults, e.g. to map out how performance trades off
+	// between reads and writes. Each is a separate benchmark, e.g.
+	// "kv25/nodes=1".
+	//
+	// Not all harnesses support this field.
+	ReadPercents []int
+
+	// Concurrency, if non-empty, are the numbers of concurrent workers
+	// the load generators of benchmarks run, replacing the harness's
+	// default, e.g. to study scheduler and lock contention under more or
+	// less load. Each is a separate benchmark, e.g. "kv95/nodes=3/conc=64".
+	//
+	// Not all harnesses support this field.
+	Concurrency []int
+
+	// CPUList, if non-empty, is a set of CPUs in the format accepted by
+	// taskset -c, e.g. "0-3,8", to pin benchmark processes to, reducing
+	// noise from CPU migration. Harnesses that run a server and a load
+	// generator as separate processes pin them to disjoint halves of the
+	// set. It has no effect on platforms other than Linux.
+	//
+	// Not all harnesses support this field.
+	CPUList string
+
+	// PerfStat indicates whether the harness should run each benchmark
+	// binary under Linux's perf stat and report hardware counters, such
+	// as cycles and cache misses, as additional benchmark metrics. If
+	// perf isn't available, harnesses warn and run benchmarks without it.
+	//
+	// Not all harnesses support this field.
+	PerfStat bool
+
+	// Seed, if non-zero, is the seed load generators should use for the
+	// random keys, values and queries they issue, so that runs with the
+	// same seed issue the same operations, making comparisons between
+	// configurations more stable. Zero means each benchmark picks a seed
+	// at random, which it logs so the run can be reproduced.
+	//
+	// Not all harnesses support this field.
+	Seed int64
+
+	// Secure indicates whether the benchmark should serve and send
+	// traffic over TLS-encrypted connections rather than plaintext.
+	// Results from secure runs are tagged with "/secure".
+	//
+	// Not all harnesses support this field.
+	Secure bool
+
+	// StoreMode, if non-empty, is where the servers benchmarks run keep
+	// their data: "disk", or "mem" to keep it in memory, which takes
+	// disk I/O out of the results for a cleaner signal of CPU and GC
+	// costs. Results from runs with an in-memory store are tagged with
+	// "/store=mem". If empty, the harness's default, usually "disk".
+	//
+	// Not all harnesses support this field.
+	StoreMode string
+
+	// GCTrace indicates that harnesses should run the servers benchmarks
+	// run with GODEBUG=gctrace=1, so that they can report how much of the
+	// servers' CPU time GC used, as gc-cpu-fraction. Tracing every GC
+	// cycle perturbs the other results slightly, so results from traced
+	// runs are tagged with "/gctrace".
+	//
+	// Not all harnesses support this field.
+	GCTrace bool
+
+	// Force indicates that harnesses should run benchmarks even if the
+	// machine is too small for them to produce meaningful results, e.g.
+	// a cluster with more nodes than there are CPUs to go around, rather
+	// than skip them with a warning.
+	//
+	// Not all harnesses support this field.
+	Force bool
+
+	// RuntimeMetrics, if non-empty, are the runtime/metrics, e.g.
+	// "/gc/heap/goal:bytes", of which harnesses should have benchmarks
+	// write a JSON snapshot at the end of each benchmark they run, to
+	// ProfileDir, as <benchmark>.metrics.json. "default" stands for a
+	// small set describing the GC and scheduler, and "all" for every
+	// metric the runtime supports. Only the metrics of benchmarks that
+	// run in the benchmark binary itself are captured, since those of
+	// servers it runs would need their cooperation.
+	//
+	// Not all harnesses support this field.
+	RuntimeMetrics []string
+
+	// Duration, if non-zero, is how long harnesses should run each of
+	// their benchmarks for, by wall-clock time, rather than for the
+	// amount of work they usually do, e.g. an hour to look for leaks. It
+	// overrides Short's duration. Harnesses that support it also report
+	// samples of the benchmark's memory use and GC over the run, as
+	// results of their own tagged with when each was taken, so that the
+	// trend shows and not only the aggregate. Where those samples come
+	// from GC traces, Duration implies GCTrace.
+	//
+	// Not all harnesses support this field.
+	Duration time.Duration
+
+	// Container, if non-nil, describes a container to run the benchmark
+	// in, instead of directly on the host.
+	//
+	// Not all harnesses support this field.
+	Container *ContainerSpec
+
+	// Cgroup, if non-nil, describes a cgroup v2 to run the benchmark in,
+	// limiting the memory and CPU it may use. Harnesses create a new
+	// cgroup for each invocation of a benchmark binary, and remove it,
+	// and anything left in it, once the binary exits.
+	//
+	// Not all harnesses support this field.
+	Cgroup *CgroupSpec
+
+	// Remote, if non-nil, is a host to run the benchmark on over SSH.
+	// The orchestrator copies BinDir and AssetsDir to the host before
+	// each run, so they, along with TmpDir, are paths on the host rather
+	// than locally. Results is still local: output is streamed back.
+	//
+	// Not all harnesses support this field.
+	Remote *RemoteSpec
+
+	// TmpfsSizeMB, if non-zero, is the size in MiB of the tmpfs the
+	// orchestrator mounted on TmpDir for the benchmark's runs, keeping
+	// disk I/O jitter out of the measurements of whatever the benchmark
+	// stores there, such as a database's data directory. Files in TmpDir
+	// then take up memory rather than disk.
+	//
+	// It's zero if TmpDir is an ordinary directory, including when
+	// mounting a tmpfs was asked for but failed. Harnesses need not do
+	// anything differently. Mounting a tmpfs is only supported on Linux,
+	// and not with Remote.
+	TmpfsSizeMB int
+
+	// Hooks are notified before and after each benchmark the harness
+	// runs, excluding warmups, or around the whole run for harnesses
+	// that run all of their benchmarks at once.
+	Hooks []RunHook
+
+	// Ctx, if non-nil, is canceled if sweet is interrupted, e.g. with
+	// Ctrl-C. Harnesses kill the benchmark they're running, and
+	// everything it started, once it's done. See Context.
+	Ctx context.Context
+}
+
+// Context returns r.Ctx, or a context that's never canceled if it's nil.
+func (r *RunConfig) Context() context.Context {
+	if r.Ctx == nil {
+		return context.Background()
+	}
+	return r.Ctx
+}
+
+// SaveTmp moves the contents of r.TmpDir into a new subdirectory of
+// r.ArtifactDir named after the current time, e.g.
+// 20060102T150405.000000000, leaving TmpDir empty. If TmpDir is already
+// empty, it does nothing.
+func (r *RunConfig) SaveTmp() error {
+	if r.ArtifactDir == "" {
+		return errors.New("KeepTmp requires ArtifactDir")
+	}
+	des, err := os.ReadDir(r.TmpDir)
+	if err != nil {
+		return err
+	}
+	if len(des) == 0 {
+		return nil
+	}
+	dst := filepath.Join(r.ArtifactDir, time.Now().Format("20060102T150405.000000000"))
+	log.CommandPrintf("mv %s/* %s", r.TmpDir, dst)
+	if err := fileutil.MoveDirContents(dst, r.TmpDir); err != nil {
+		return fmt.Errorf("keeping tmp directory: %w", err)
+	}
+	return nil
+}
+
+// ContainerSpec describes a container for a benchmark to run in, for
+// hermetic and reproducible environments.
+//
+// BinDir, TmpDir, AssetsDir and the directory containing Results are
+// mounted into the container at the same paths as on the host, so the
+// benchmark's command line doesn't change. Binaries run from the host's
+// BinDir, so the image must be able to run them, e.g. provide a
+// compatible libc. Tools used by the harness, like taskset for
+// RunConfig.CPUList and perf for RunConfig.PerfStat, run inside the
+// container too, so the image must provide them as well.
+type ContainerSpec struct {
+	// Runtime is the container CLI to use, e.g. "docker" or "podman".
+	// If empty, it's "docker".
+	Runtime string
+
+	// Image is the container image to run in.
+	Image string
+
+	// CPUs and Memory, if non-empty, are the limits on the number of
+	// CPUs and the amount of memory the container may use, in the
+	// formats accepted by the runtime's --cpus and --memory flags.
+	CPUs   string
+	Memory string
+
+	// Sysctls are kernel parameters to set in the container, each of the
+	// form key=value.
+	Sysctls []string
+
+	// Mounts are additional paths to mount into the container, each in
+	// the format accepted by the runtime's -v flag, e.g. /host:/container.
+	Mounts []string
 }
 
 type Harness interface {
@@ -92,5 +655,30 @@ type Harness interface {
 
 	// Run runs the given benchmark and writes Go `testing` formatted benchmark
 	// output to `results`.
+	//
+	// Run should produce one sample of each of the benchmark's results.
+	// To collect multiple samples, as benchstat needs, Run is called
+	// repeatedly (see the -count flag of sweet run), alternating between
+	// configurations, with TmpDir emptied between calls. Harnesses that
+	// run several benchmarks in one call must clean up between them so
+	// that samples remain independent.
 	Run(cfg *Config, r *RunConfig) error
 }
+
+// BenchmarkLister is implemented by harnesses that run several named
+// benchmarks, which RunConfig.BenchFilter selects from.
+type BenchmarkLister interface {
+	// Benchmarks returns the names of the benchmarks Run runs, before
+	// filtering.
+	Benchmarks() []string
+}
+
+// MetricLister is implemented by harnesses that declare the units of the
+// metrics their benchmarks may report, e.g. "ns/op" or "read-ops/sec", so
+// that they're listed in the header of each results file, for tools that
+// read them. A result needn't report every metric, and diagnostics and
+// other options may add metrics of their own.
+type MetricLister interface {
+	// Metrics returns the units of the metrics Run may report.
+	Metrics() []string
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// RunHook is notified around each benchmark a harness runs, so that
+// metrics can be collected alongside the benchmark's own, e.g. from a
+// GPU counter or another system's statistics, without changing the
+// harness. See RunConfig.Hooks.
+type RunHook interface {
+	// BeforeBenchmark is called just before the benchmark named name
+	// starts. The name is the Sweet benchmark and the harness's name for
+	// the benchmark, e.g. "cockroachdb/kv0/nodes=3", or just the former,
+	// e.g. "caddy", for harnesses that run all of theirs at once, in one
+	// invocation of the benchmark binary. If it returns an error, the
+	// benchmark isn't run, and the run fails.
+	BeforeBenchmark(name string) error
+
+	// AfterBenchmark is called once the benchmark named name has
+	// finished, whether or not it succeeded. An error fails the run.
+	AfterBenchmark(name string, result *RunResult) error
+}
+
+// RunResult is the outcome of a benchmark, as reported to
+// RunHook.AfterBenchmark.
+type RunResult struct {
+	// Results are the results the benchmark reported.
+	Results []*Result
+
+	// Duration is how long the benchmark took to run.
+	Duration time.Duration
+
+	// Err is the error the benchmark failed with, or nil if it
+	// succeeded.
+	Err error
+}
+
+// CommandHook is a RunHook that runs a command before and after each
+// benchmark, for collectors written in any language. The command is run
+// as
+//
+//	Path Args... before <name>
+//	Path Args... after <name>
+//
+// where name is as given to RunHook. The output of the "after" command
+// is written to Output, typically RunConfig.Results, so that it may
+// report metrics of its own in the Go benchmark format.
+type CommandHook struct {
+	Path string
+	Args []string
+
+	// Output is where the output of the "after" command is written. If
+	// nil, it's discarded.
+	Output io.Writer
+
+	// Env is the environment to run the command in. If nil, it inherits
+	// sweet's.
+	Env []string
+}
+
+func (h *CommandHook) BeforeBenchmark(name string) error {
+	return h.run(nil, "before", name)
+}
+
+func (h *CommandHook) AfterBenchmark(name string, _ *RunResult) error {
+	return h.run(h.Output, "after", name)
+}
+
+func (h *CommandHook) run(out io.Writer, event, name string) error {
+	args := append(h.Args[:len(h.Args):len(h.Args)], event, name)
+	cmd := exec.Command(h.Path, args...)
+	cmd.Env = h.Env
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	log.TraceCommand(cmd, false)
+	return cmd.Run()
+}
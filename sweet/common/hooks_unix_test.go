@@ -0,0 +1,55 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !wasm
+
+package common_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestCommandHook(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "collector")
+	events := filepath.Join(dir, "events")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$1 $2 $3" >> "$LOG"
+if [ "$2" = after ]; then
+	echo "Benchmark$3 1 42 gpu-joules"
+fi
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	h := &common.CommandHook{
+		Path:   script,
+		Args:   []string{"-v"},
+		Output: &out,
+		Env:    append(os.Environ(), "LOG="+events),
+	}
+	if err := h.BeforeBenchmark("etcd/put"); err != nil {
+		t.Fatalf("BeforeBenchmark: %v", err)
+	}
+	if err := h.AfterBenchmark("etcd/put", &common.RunResult{}); err != nil {
+		t.Fatalf("AfterBenchmark: %v", err)
+	}
+	b, err := os.ReadFile(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "-v before etcd/put\n-v after etcd/put\n"; got != want {
+		t.Errorf("hook ran with %q, expected %q", got, want)
+	}
+	if got, want := strings.TrimSpace(out.String()), "Benchmarketcd/put 1 42 gpu-joules"; got != want {
+		t.Errorf("after command wrote %q, expected %q", got, want)
+	}
+}
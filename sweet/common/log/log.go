@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	shellquote "github.com/kballard/go-shellquote"
@@ -16,13 +17,35 @@ import (
 
 var (
 	cmdLog, actLog *log.Logger
+	dryLog         *log.Logger
 	cmdOn, actOn   = false, false
+	level          = LevelInfo
 	envMap         map[string]string
 )
 
+// Level is the severity of a message in the activity log.
+type Level int
+
+const (
+	// LevelDebug is for detail that's only useful when diagnosing a
+	// problem, e.g. each command run.
+	LevelDebug Level = iota
+
+	// LevelInfo is for progress, e.g. the steps of a long build.
+	LevelInfo
+
+	// LevelWarn is for problems that don't stop the run, but may affect
+	// its results.
+	LevelWarn
+
+	// LevelError is for failures.
+	LevelError
+)
+
 func init() {
 	cmdLog = log.New(os.Stdout, "[shell] ", 0)
 	actLog = log.New(os.Stderr, "[sweet] ", 0)
+	dryLog = log.New(os.Stdout, "[dry-run] ", 0)
 	envMap = makeEnvironMap()
 }
 
@@ -47,6 +70,21 @@ func SetActivityLog(on bool) {
 	actOn = on
 }
 
+// SetLevel sets the least severe level of message written to the activity
+// log, LevelInfo by default. Errors are always written; messages of other
+// levels are only written while the activity log is on.
+func SetLevel(l Level) {
+	level = l
+}
+
+// enabled reports whether messages of level l are written.
+func enabled(l Level) bool {
+	if l >= LevelError {
+		return true
+	}
+	return actOn && l >= level
+}
+
 func filterEnviron(env []string) []string {
 	fenv := make([]string, 0, len(env))
 	for _, e := range env {
@@ -62,8 +100,13 @@ func filterEnviron(env []string) []string {
 	return fenv
 }
 
+// TraceCommand prints cmd as a shell command if command tracing is on,
+// and otherwise logs it at LevelDebug.
 func TraceCommand(cmd *exec.Cmd, background bool) {
 	if !cmdOn {
+		if enabled(LevelDebug) {
+			Debugf("running %s", shellquote.Join(cmd.Args...))
+		}
 		return
 	}
 	senv := ""
@@ -88,6 +131,33 @@ func TraceCommand(cmd *exec.Cmd, background bool) {
 	}
 }
 
+// DryRunCommand prints cmd in place of running it: its working
+// directory, its full environment, and its command line. Unlike
+// TraceCommand, the environment isn't filtered, and cmd is printed
+// regardless of whether command tracing is on.
+func DryRunCommand(cmd *exec.Cmd) {
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	dryLog.Printf("dir: %s", dir)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// Sort the environment so that dry runs can be diffed.
+	env = append([]string(nil), env...)
+	sort.Strings(env)
+	dryLog.Printf("env: %s", shellquote.Join(env...))
+	dryLog.Printf("cmd: %s", shellquote.Join(cmd.Args...))
+}
+
+// DryRunPrintf prints a shell-like representation of an operation
+// skipped during a dry run.
+func DryRunPrintf(format string, args ...interface{}) {
+	dryLog.Printf(format, args...)
+}
+
 func TraceKill(cmd *exec.Cmd) {
 	if !cmdOn {
 		return
@@ -102,20 +172,49 @@ func CommandPrintf(format string, args ...interface{}) {
 	cmdLog.Printf(format, args...)
 }
 
+// Printf is Infof.
 func Printf(format string, args ...interface{}) {
-	if !actOn {
-		return
-	}
-	actLog.Printf(format, args...)
+	Infof(format, args...)
 }
 
 func Print(args ...interface{}) {
-	if !actOn {
+	if !enabled(LevelInfo) {
 		return
 	}
 	actLog.Print(args...)
 }
 
+// Debugf writes a message to the activity log at LevelDebug.
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, "debug: ", format, args...)
+}
+
+// Infof writes a message to the activity log at LevelInfo.
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, "", format, args...)
+}
+
+// Warnf writes a message to the activity log at LevelWarn, prefixed
+// with "warning: ".
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, "warning: ", format, args...)
+}
+
+// Errorf writes a message to the activity log at LevelError, prefixed
+// with "error: ".
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, "error: ", format, args...)
+}
+
+func logf(l Level, prefix, format string, args ...interface{}) {
+	if !enabled(l) {
+		return
+	}
+	actLog.Printf(prefix+format, args...)
+}
+
+// Error logs err at LevelError, along with the standard error of the
+// command that failed, if err is from one.
 func Error(err error) {
 	actLog.Printf("error: %v", err)
 	if e, ok := err.(*exec.ExitError); ok {
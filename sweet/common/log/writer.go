@@ -0,0 +1,89 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// PrefixWriter is an io.Writer that writes each line written to it to an
+// underlying writer as soon as the line is complete, prefixed with a fixed
+// string.
+//
+// Each line is passed to the underlying writer in a single Write, so
+// lines from multiple PrefixWriters sharing an underlying writer don't
+// interleave. Incomplete lines are held until they're completed or the
+// PrefixWriter is flushed.
+type PrefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	on     func() bool
+	buf    []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter that writes to w. If w is nil,
+// output is discarded.
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	if w == nil {
+		w = io.Discard
+	}
+	return &PrefixWriter{w: w, prefix: prefix}
+}
+
+// ActivityWriter returns a PrefixWriter that writes to the activity log,
+// prefixing each line with "[name] ". Output is discarded while the
+// activity log is off.
+func ActivityWriter(name string) *PrefixWriter {
+	return &PrefixWriter{
+		w:      os.Stderr,
+		prefix: "[" + name + "] ",
+		on:     func() bool { return actOn },
+	}
+}
+
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	// Don't hold on to the storage for lines that have been written.
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	return len(b), nil
+}
+
+// Flush writes out any incomplete line, terminating it with a newline.
+func (p *PrefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	if p.on != nil && !p.on() {
+		return nil
+	}
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// manifest describes the environment in which a configuration was built
+// and run. See Config.WriteManifest.
+type manifest struct {
+	Name      string   `json:"name"`
+	GoRoot    string   `json:"goroot"`
+	GoVersion string   `json:"go_version"`
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	NumCPU    int      `json:"num_cpu"`
+	CPUModel  string   `json:"cpu_model,omitempty"`
+	Kernel    string   `json:"kernel,omitempty"`
+	BuildEnv  []string `json:"envbuild"`
+	ExecEnv   []string `json:"envexec"`
+
+	// BuildGoRoot and BenchGoRoot are only present if the configuration
+	// overrides GoRoot for building the application or the benchmark.
+	BuildGoRoot string `json:"goroot_build,omitempty"`
+	BenchGoRoot string `json:"goroot_bench,omitempty"`
+
+	// PGOFiles maps the benchmarks built with PGO to their profiles.
+	// Benchmarks not listed were built without PGO.
+	PGOFiles map[string]string `json:"pgofiles"`
+
+	// GoExperiment and GoFlags are only present if the configuration
+	// sets them for building the application.
+	GoExperiment string   `json:"goexperiment,omitempty"`
+	GoFlags      []string `json:"goflags,omitempty"`
+
+	// Get is only present if the benchmark's source was fetched, and
+	// the harness recorded how.
+	Get *GetStats `json:"get,omitempty"`
+}
+
+// WriteManifest writes a JSON document to w describing the environment c
+// builds and runs benchmarks in: the toolchain, the platform, and the
+// full build and execution environments, the GOEXPERIMENT and GOFLAGS
+// the application was built with, and which benchmarks were built with
+// PGO. If get is non-nil, it also describes how the benchmark's source
+// was fetched. Written next to results, it makes them self-describing.
+//
+// CPU and kernel information is only available on Linux, and is omitted
+// elsewhere.
+func (c *Config) WriteManifest(w io.Writer, get *GetStats) error {
+	version, err := c.GoTool().Version()
+	if err != nil {
+		return fmt.Errorf("determining Go version: %w", err)
+	}
+	m := manifest{
+		Name:      c.Name,
+		GoRoot:    c.GoRoot,
+		GoVersion: version,
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		CPUModel:  cpuModel(),
+		Kernel:    kernelRelease(),
+		BuildEnv:  sortedEnv(c.BuildEnv.Env),
+		ExecEnv:   sortedEnv(c.ExecEnv.Env),
+		PGOFiles:  c.PGOFiles,
+
+		BuildGoRoot: c.BuildGoRoot,
+		BenchGoRoot: c.BenchGoRoot,
+
+		GoExperiment: c.GoExperiment,
+		GoFlags:      c.GoFlags,
+
+		Get: get,
+	}
+	if m.PGOFiles == nil {
+		m.PGOFiles = map[string]string{}
+	}
+	b, err := json.MarshalIndent(&m, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func sortedEnv(e *Env) []string {
+	if e == nil {
+		return []string{}
+	}
+	env := e.Collapse()
+	sort.Strings(env)
+	return env
+}
+
+// cpuModel returns the model name of the first CPU listed in
+// /proc/cpuinfo, or the empty string if it can't be determined.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// The key varies by architecture: x86 uses "model name",
+		// while others use "Model" or "cpu model", if anything.
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "model name", "Model", "cpu model":
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// kernelRelease returns the release of the running kernel, or the empty
+// string if it can't be determined.
+func kernelRelease() string {
+	b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(b))
+}
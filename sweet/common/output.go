@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// OutputTailSize is how much of the end of a command's output RunCommand
+// keeps to explain the command's failure.
+const OutputTailSize = 8 << 10
+
+// TailBuffer is an io.Writer that keeps only the last bytes written to
+// it, in a ring buffer of fixed size. It's safe for concurrent use, so
+// that it may be both the stdout and the stderr of a command.
+type TailBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	next int  // index in buf of the next byte to write
+	full bool // whether buf has wrapped around
+}
+
+// NewTailBuffer returns a TailBuffer that keeps the last size bytes
+// written to it.
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{buf: make([]byte, size)}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if len(p) >= len(t.buf) {
+		// Only the end of p survives.
+		p = p[len(p)-len(t.buf):]
+	}
+	for len(p) > 0 {
+		c := copy(t.buf[t.next:], p)
+		p = p[c:]
+		t.next += c
+		if t.next == len(t.buf) {
+			t.next = 0
+			t.full = true
+		}
+	}
+	return n, nil
+}
+
+// Bytes returns the bytes kept, oldest first. If earlier bytes were
+// dropped, the partial line at the start is dropped too, so that the
+// result starts at the beginning of a line.
+func (t *TailBuffer) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return append([]byte(nil), t.buf[:t.next]...)
+	}
+	b := append(append([]byte(nil), t.buf[t.next:]...), t.buf[:t.next]...)
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[i+1:]
+	}
+	return b
+}
+
+// CommandError is the error RunCommand returns for a command that fails,
+// with the end of the command's output.
+type CommandError struct {
+	Err    error
+	Output []byte
+}
+
+func (e *CommandError) Error() string {
+	out := bytes.TrimRight(e.Output, "\n")
+	if len(out) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v; last output:\n%s", e.Err, out)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// RunCommand is like cmd.Run, except that it also keeps the last
+// OutputTailSize bytes of the command's stdout and stderr, combined, and
+// if the command fails, includes them in the error, as a *CommandError.
+// The output is still written to cmd.Stdout and cmd.Stderr, if they're
+// set, so that output shown as the command runs still is.
+func RunCommand(cmd *exec.Cmd) error {
+	tail := NewTailBuffer(OutputTailSize)
+	cmd.Stdout = teeWriter(cmd.Stdout, tail)
+	cmd.Stderr = teeWriter(cmd.Stderr, tail)
+	if err := cmd.Run(); err != nil {
+		return &CommandError{Err: err, Output: tail.Bytes()}
+	}
+	return nil
+}
+
+func teeWriter(w io.Writer, tail *TailBuffer) io.Writer {
+	if w == nil {
+		return tail
+	}
+	return io.MultiWriter(w, tail)
+}
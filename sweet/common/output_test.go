@@ -0,0 +1,39 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common_test
+
+import (
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestTailBuffer(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"Empty", 8, nil, ""},
+		{"Short", 8, []string{"ab", "cd"}, "abcd"},
+		{"Exact", 8, []string{"abcd", "efgh"}, "abcdefgh"},
+		{"Wrapped", 8, []string{"abc\nd", "efg\nhij"}, "hij"},
+		{"WrappedNoNewline", 8, []string{"abcdef", "ghijkl"}, "efghijkl"},
+		{"LargeWrite", 8, []string{"ab", "0123\n456789"}, "456789"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := common.NewTailBuffer(test.size)
+			for _, w := range test.writes {
+				if n, err := b.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := string(b.Bytes()); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
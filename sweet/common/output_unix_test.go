@@ -0,0 +1,44 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !wasm
+
+package common_test
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestRunCommand(t *testing.T) {
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", `echo building; echo "it broke" >&2; exit 3`)
+	cmd.Stdout = &out
+	err := common.RunCommand(cmd)
+	var ce *common.CommandError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got error %v, want a *CommandError", err)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 3 {
+		t.Errorf("got error %v, want exit status 3", err)
+	}
+	// The order of stdout and stderr in the tail isn't defined.
+	for _, want := range []string{"exit status 3; last output:\n", "building", "it broke"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", err, want)
+		}
+	}
+	if out.String() != "building\n" {
+		t.Errorf("got stdout %q, want %q", out.String(), "building\n")
+	}
+	if err := common.RunCommand(exec.Command("true")); err != nil {
+		t.Errorf("true: %v", err)
+	}
+}
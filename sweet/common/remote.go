@@ -0,0 +1,134 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/benchmarks/sweet/common/log"
+
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// RemoteSpec describes a host to run benchmarks on over SSH, after building
+// them locally.
+//
+// The host must be reachable with ssh and rsync without a password prompt,
+// e.g. with an SSH agent, and must be able to run the binaries built
+// locally.
+type RemoteSpec struct {
+	// Host is the host to run on, as passed to ssh.
+	Host string
+
+	// User, if non-empty, is the user to log in as.
+	User string
+
+	// WorkDir is the absolute path to the directory on the host to copy
+	// binaries and assets to, and to run benchmarks in.
+	WorkDir string
+
+	// DryRun indicates that commands which change the host should be
+	// printed instead of executed. See Config.DryRun.
+	DryRun bool
+}
+
+// Target returns the destination to pass to ssh, e.g. user@host.
+func (r *RemoteSpec) Target() string {
+	if r.User == "" {
+		return r.Host
+	}
+	return r.User + "@" + r.Host
+}
+
+// Command returns a command that runs name with args on the host, in
+// directory dir if it's non-empty, with the environment variables in env
+// that differ from the local process's own. The rest of the local
+// environment describes this machine rather than the host, so it isn't
+// passed on.
+//
+// The command's standard output and error are those of ssh, so they can be
+// streamed back like a local command's. Note that killing the command kills
+// ssh, but not necessarily the process on the host.
+func (r *RemoteSpec) Command(dir string, env []string, name string, args ...string) *exec.Cmd {
+	local := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		local[kv] = true
+	}
+	var words []string
+	for _, kv := range env {
+		if !local[kv] {
+			words = append(words, kv)
+		}
+	}
+	if len(words) != 0 {
+		words = append([]string{"env"}, words...)
+	}
+	words = append(words, name)
+	words = append(words, args...)
+	script := shellquote.Join(words...)
+	if dir != "" {
+		script = "cd " + shellquote.Join(dir) + " && " + script
+	}
+	return exec.Command("ssh", "-o", "BatchMode=yes", r.Target(), "--", script)
+}
+
+// run runs the shell script script on the host, returning an error with
+// its output if it fails.
+func (r *RemoteSpec) run(script string) ([]byte, error) {
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", r.Target(), "--", script)
+	log.TraceCommand(cmd, false)
+	if r.DryRun {
+		log.DryRunCommand(cmd)
+		return nil, nil
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("on %s: %s: %w: %s", r.Host, script, err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// MkdirAll creates the directories dirs on the host, if they don't exist.
+func (r *RemoteSpec) MkdirAll(dirs ...string) error {
+	_, err := r.run("mkdir -p " + shellquote.Join(dirs...))
+	return err
+}
+
+// Sync makes the directory remote on the host a copy of the local
+// directory local, deleting anything else in it.
+func (r *RemoteSpec) Sync(local, remote string) error {
+	if err := r.MkdirAll(remote); err != nil {
+		return err
+	}
+	cmd := exec.Command("rsync", "-a", "--delete", "-e", "ssh -o BatchMode=yes", local+"/", r.Target()+":"+remote+"/")
+	log.TraceCommand(cmd, false)
+	if r.DryRun {
+		log.DryRunCommand(cmd)
+		return nil
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("copying %s to %s:%s: %w: %s", local, r.Host, remote, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RmDirContents removes everything in the directory dir on the host,
+// leaving dir itself in place. Like its local counterparts, it returns an
+// error if dir isn't empty afterwards, e.g. because a process that is
+// still running created new files in it.
+func (r *RemoteSpec) RmDirContents(dir string) error {
+	q := shellquote.Join(dir)
+	out, err := r.run(fmt.Sprintf("find %s -mindepth 1 -delete; ls -A %s", q, q))
+	if err != nil {
+		return fmt.Errorf("failed to remove contents of %s:\n%w", dir, err)
+	}
+	if left := strings.Fields(string(out)); len(left) != 0 {
+		return fmt.Errorf("%s on %s is not empty after removing its contents: %s", dir, r.Host, strings.Join(left, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Result is a single benchmark result, in the form written to
+// RunConfig.JSONResults.
+//
+// Alongside the benchmark's own results, sweet records how long fetching
+// and building the benchmark took, as results named "GetDuration" and
+// "BuildDuration" with a single "sec" metric.
+type Result struct {
+	// Benchmark is the name of the Sweet benchmark that produced
+	// the result, e.g. "cockroachdb".
+	Benchmark string `json:"benchmark"`
+
+	// Config is the name of the configuration the result is for.
+	Config string `json:"config"`
+
+	// Name is the full name of the result, as it appears in the Go
+	// benchmark format, e.g. "BenchmarkCockroachDBkv0/nodes=1-8".
+	Name string `json:"name"`
+
+	// Iterations is the number of iterations the result was measured over.
+	Iterations int64 `json:"iterations"`
+
+	// NsPerOp is the value of the ns/op metric, or zero if the result
+	// doesn't have one.
+	NsPerOp float64 `json:"ns_per_op,omitempty"`
+
+	// Metrics contains the values of every other metric in the result,
+	// keyed by unit.
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// JSONResultsWriter is an io.Writer that accepts output in the Go benchmark
+// format and writes each result line in it as a JSON-encoded Result,
+// one per line, to an underlying writer. Lines that aren't results, such as
+// comments and configuration lines, are dropped, as is any incomplete
+// final line.
+type JSONResultsWriter struct {
+	mu     sync.Mutex
+	write  func(*Result) error
+	bench  string
+	config string
+	buf    []byte
+}
+
+// NewJSONResultsWriter returns a JSONResultsWriter that writes results
+// produced by benchmark under the configuration config to w.
+func NewJSONResultsWriter(w io.Writer, benchmark, config string) *JSONResultsWriter {
+	enc := json.NewEncoder(w)
+	return &JSONResultsWriter{
+		write:  func(r *Result) error { return enc.Encode(r) },
+		bench:  benchmark,
+		config: config,
+	}
+}
+
+// NewResultEventsWriter returns a JSONResultsWriter that writes each
+// result produced by benchmark under the configuration config to w as
+// an EventBenchmarkResult Event instead.
+func NewResultEventsWriter(w io.Writer, benchmark, config string) *JSONResultsWriter {
+	return &JSONResultsWriter{
+		write: func(r *Result) error {
+			return WriteEvent(w, Event{
+				Type:      EventBenchmarkResult,
+				Benchmark: benchmark,
+				Config:    config,
+				Result:    r,
+			})
+		},
+		bench:  benchmark,
+		config: config,
+	}
+}
+
+func (j *JSONResultsWriter) Write(b []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.buf = append(j.buf, b...)
+	for {
+		i := bytes.IndexByte(j.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := j.writeLine(string(j.buf[:i])); err != nil {
+			return len(b), err
+		}
+		j.buf = j.buf[i+1:]
+	}
+	if len(j.buf) == 0 {
+		j.buf = nil
+	}
+	return len(b), nil
+}
+
+func (j *JSONResultsWriter) writeLine(line string) error {
+	r, ok := parseResult(line)
+	if !ok {
+		return nil
+	}
+	r.Benchmark = j.bench
+	r.Config = j.config
+	return j.write(r)
+}
+
+// ReadResults reads the results in r, which is in the Go benchmark format,
+// like a results file sweet writes. Lines that aren't results are
+// skipped, as the JSONResultsWriter does.
+func ReadResults(r io.Reader) ([]*Result, error) {
+	var results []*Result
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		if r, ok := parseResult(s.Text()); ok {
+			results = append(results, r)
+		}
+	}
+	return results, s.Err()
+}
+
+// parseResult parses a line in the Go benchmark format of the form
+//
+//	BenchmarkName iterations value unit [value unit...]
+//
+// reporting false if line isn't a well-formed result.
+func parseResult(line string) (*Result, bool) {
+	f := strings.Fields(line)
+	if len(f) < 2 || len(f)%2 != 0 || !strings.HasPrefix(f[0], "Benchmark") {
+		return nil, false
+	}
+	iters, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	r := &Result{
+		Name:       f[0],
+		Iterations: iters,
+		Metrics:    make(map[string]float64),
+	}
+	for i := 2; i < len(f); i += 2 {
+		v, err := strconv.ParseFloat(f[i], 64)
+		if err != nil {
+			return nil, false
+		}
+		if unit := f[i+1]; unit == "ns/op" {
+			r.NsPerOp = v
+		} else {
+			r.Metrics[unit] = v
+		}
+	}
+	return r, true
+}
+
+// ResultsSchemaVersion is the version of the format of the results files
+// sweet writes, which their header records, so that tools reading them
+// can tell whether they understand them. Results files of version 1 had
+// no header.
+const ResultsSchemaVersion = 2
+
+// resultsHeaderPrefix begins each line of the header of a results file.
+// The lines are comments in the Go benchmark format, so tools that don't
+// know about them, like benchstat, skip them.
+const resultsHeaderPrefix = "# sweet-"
+
+// ResultsHeader identifies the results in a results file. It's written
+// as comment lines at the start of the file, e.g.
+//
+//	# sweet-schema: 2
+//	# sweet-benchmark: cockroachdb
+//	# sweet-config: base
+//	# sweet-config-hash: 5e884898da28...
+//	# sweet-metrics: ns/op read-ops/sec ...
+type ResultsHeader struct {
+	// Schema is the version of the format of the file. See
+	// ResultsSchemaVersion.
+	Schema int
+
+	// Benchmark is the name of the Sweet benchmark the results are for.
+	Benchmark string
+
+	// Config is the name of the configuration the results are for, and
+	// ConfigHash identifies everything about the configuration that
+	// affects them, so that configurations can be told apart even if
+	// they share a name.
+	Config     string
+	ConfigHash string
+
+	// Metrics are the units of the metrics the benchmark may report, if
+	// its harness declares them. See MetricLister.
+	Metrics []string
+}
+
+// Write writes h to w, omitting any fields that aren't set.
+func (h *ResultsHeader) Write(w io.Writer) error {
+	var b strings.Builder
+	field := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s%s: %s\n", resultsHeaderPrefix, key, value)
+		}
+	}
+	field("schema", strconv.Itoa(h.Schema))
+	field("benchmark", h.Benchmark)
+	field("config", h.Config)
+	field("config-hash", h.ConfigHash)
+	field("metrics", strings.Join(h.Metrics, " "))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ReadResultsHeader reads the header at the start of r, a results file
+// sweet wrote. A file without a header is of version 1 of the format.
+// Header fields that aren't known are skipped, so that readers need only
+// reject a file if its Schema is newer than they understand.
+func ReadResultsHeader(r io.Reader) (*ResultsHeader, error) {
+	h := &ResultsHeader{Schema: 1}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line, ok := strings.CutPrefix(s.Text(), resultsHeaderPrefix)
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("malformed results header line %q", s.Text())
+		}
+		switch key {
+		case "schema":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed results schema version %q", value)
+			}
+			h.Schema = v
+		case "benchmark":
+			h.Benchmark = value
+		case "config":
+			h.Config = value
+		case "config-hash":
+			h.ConfigHash = value
+		case "metrics":
+			h.Metrics = strings.Fields(value)
+		}
+	}
+	return h, s.Err()
+}
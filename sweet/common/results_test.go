@@ -0,0 +1,136 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package common_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestJSONResultsWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := common.NewJSONResultsWriter(&buf, "cockroachdb", "base")
+
+	// Write in pieces that split lines, as output from a pipe might.
+	input := "# warning: some noise\n" +
+		"BenchmarkKV0/nodes=1-8 1000 52000 ns/op 1500 p50-latency-ns\n" +
+		"goos: linux\n" +
+		"BenchmarkKV95/nodes=1-8 2000 13.5 ops/s\n" +
+		"BenchmarkBroken 10 abc ns/op\n" +
+		"BenchmarkIncomplete 10"
+	for i := 0; i < len(input); i += 7 {
+		end := i + 7
+		if end > len(input) {
+			end = len(input)
+		}
+		if _, err := w.Write([]byte(input[i:end])); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var got []common.Result
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r common.Result
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		got = append(got, r)
+	}
+	want := []common.Result{
+		{
+			Benchmark:  "cockroachdb",
+			Config:     "base",
+			Name:       "BenchmarkKV0/nodes=1-8",
+			Iterations: 1000,
+			NsPerOp:    52000,
+			Metrics:    map[string]float64{"p50-latency-ns": 1500},
+		},
+		{
+			Benchmark:  "cockroachdb",
+			Config:     "base",
+			Name:       "BenchmarkKV95/nodes=1-8",
+			Iterations: 2000,
+			Metrics:    map[string]float64{"ops/s": 13.5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReadResults(t *testing.T) {
+	input := "goos: linux\n" +
+		"BenchmarkKV0/nodes=1-8 1000 52000 ns/op 1500 p50-latency-ns\n" +
+		"# cockroachdb-bench timed out after 10m0s\n" +
+		"BenchmarkBroken 10 abc ns/op\n" +
+		"BenchmarkKV95/nodes=1-8 2000 13.5 ops/s"
+	got, err := common.ReadResults(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*common.Result{
+		{
+			Name:       "BenchmarkKV0/nodes=1-8",
+			Iterations: 1000,
+			NsPerOp:    52000,
+			Metrics:    map[string]float64{"p50-latency-ns": 1500},
+		},
+		{
+			Name:       "BenchmarkKV95/nodes=1-8",
+			Iterations: 2000,
+			Metrics:    map[string]float64{"ops/s": 13.5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, expected %+v", got, want)
+	}
+}
+
+func TestResultsHeader(t *testing.T) {
+	h := &common.ResultsHeader{
+		Schema:     common.ResultsSchemaVersion,
+		Benchmark:  "cockroachdb",
+		Config:     "base",
+		ConfigHash: "abc123",
+		Metrics:    []string{"ns/op", "read-ops/sec"},
+	}
+	var b bytes.Buffer
+	if err := h.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	b.WriteString("# sweet-future-field: x\n")
+	b.WriteString("BenchmarkCockroachDBkv0/nodes=1 1 5000 ns/op\n")
+	b.WriteString("# sweet-config: not-the-header\n")
+	got, err := common.ReadResultsHeader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("got header %+v, want %+v", got, h)
+	}
+
+	// The results aren't disturbed by the header.
+	b.Reset()
+	h.Write(&b)
+	b.WriteString("BenchmarkCockroachDBkv0/nodes=1 1 5000 ns/op\n")
+	results, err := common.ReadResults(&b)
+	if err != nil || len(results) != 1 {
+		t.Errorf("got results %+v, %v, want one result", results, err)
+	}
+
+	got, err = common.ReadResultsHeader(strings.NewReader("BenchmarkX 1 5 ns/op\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&common.ResultsHeader{Schema: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got header %+v for a file without one, want %+v", got, want)
+	}
+}
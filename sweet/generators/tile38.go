@@ -85,7 +85,7 @@ func (Tile38) Generate(cfg *common.GenConfig) error {
 	// Add the Go tool to PATH, since tile38's Makefile doesn't provide enough
 	// visibility into how tile38 is built to allow us to pass this information
 	// directly.
-	env := cfg.GoTool.Env.Prefix("PATH", filepath.Join(filepath.Dir(cfg.GoTool.Tool))+":")
+	env := cfg.GoTool.Env.PrependPath("PATH", filepath.Dir(cfg.GoTool.Tool))
 
 	// Build Tile38.
 	cmd := exec.Command("make", "-C", srcDir)
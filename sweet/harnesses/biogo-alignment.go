@@ -0,0 +1,88 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+const (
+	biogoAlignmentBin   = "biogo-alignment-bench"
+	biogoAlignmentFASTA = "seqs.fa"
+)
+
+// BiogoAlignment implements the Harness interface.
+//
+// Unlike the other biogo benchmarks, which build against the biogo
+// module listed in go.mod, this one builds its driver against a
+// pinned checkout of biogo fetched in Get.
+type BiogoAlignment struct{}
+
+func init() {
+	Register("biogo-alignment", BiogoAlignment{})
+}
+
+func (h BiogoAlignment) CheckPrerequisites() error {
+	if runtime.GOARCH != "arm64" && runtime.GOARCH != "amd64" {
+		return fmt.Errorf("requires amd64 or arm64")
+	}
+	return nil
+}
+
+func (h BiogoAlignment) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
+	return retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitShallowClone(
+			gcfg.SrcDir,
+			"https://github.com/biogo/biogo",
+			"v1.0.4",
+			gcfg.MirrorBase,
+		)
+	})
+}
+
+func (h BiogoAlignment) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// Point the driver at the checkout in SrcDir using a copy of the
+	// module's go.mod, so the repository's own go.mod stays untouched.
+	modFile, err := replaceModule(cfg, bcfg, "github.com/biogo/biogo", bcfg.SrcDir)
+	if err != nil {
+		return err
+	}
+	if err := cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, biogoAlignmentBin), "-modfile="+modFile); err != nil {
+		return err
+	}
+	// The input is small enough to live alongside the driver, so it's
+	// bundled with the binary instead of being generated as an asset.
+	return copyFile(
+		filepath.Join(bcfg.BinDir, biogoAlignmentFASTA),
+		filepath.Join(bcfg.BenchDir, "testdata", biogoAlignmentFASTA),
+	)
+}
+
+func (h BiogoAlignment) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	args := append(rcfg.Args[:len(rcfg.Args):len(rcfg.Args)], runtimeMetricsArgs(rcfg)...)
+	args = append(args, filepath.Join(rcfg.BinDir, biogoAlignmentFASTA))
+	if rcfg.Short {
+		args = append([]string{"-short"}, args...)
+	}
+	cmd := exec.Command(filepath.Join(rcfg.BinDir, biogoAlignmentBin), args...)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	return runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+}
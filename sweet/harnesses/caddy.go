@@ -0,0 +1,86 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// Caddy implements the Harness interface.
+type Caddy struct{}
+
+func init() {
+	Register("caddy", Caddy{})
+}
+
+func (h Caddy) CheckPrerequisites() error {
+	if runtime.GOARCH != "arm64" && runtime.GOARCH != "amd64" {
+		return fmt.Errorf("requires amd64 or arm64")
+	}
+	return nil
+}
+
+func (h Caddy) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
+	return retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitShallowClone(
+			gcfg.SrcDir,
+			"https://github.com/caddyserver/caddy",
+			"v2.7.6",
+			gcfg.MirrorBase,
+		)
+	})
+}
+
+func (h Caddy) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// Build the stock caddy binary, which includes the file server and
+	// everything needed to terminate TLS.
+	serverPkg := filepath.Join(bcfg.SrcDir, "cmd", "caddy")
+	if err := cfg.GoTool().BuildPath(serverPkg, filepath.Join(bcfg.BinDir, "caddy")); err != nil {
+		return err
+	}
+	// Build the benchmark wrapper, which doubles as the load generator.
+	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "caddy-bench"))
+}
+
+func (h Caddy) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	args := append(rcfg.Args, []string{
+		"-caddy-bin", filepath.Join(rcfg.BinDir, "caddy"),
+		"-tmp", rcfg.TmpDir,
+	}...)
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	if rcfg.Secure {
+		args = append(args, "-secure")
+	}
+	cmd := exec.Command(
+		filepath.Join(rcfg.BinDir, "caddy-bench"),
+		args...,
+	)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	err := runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+	if err != nil {
+		return err
+	}
+	// Delete tmp because the benchmark writes its config, fixture, and
+	// certificates there, and caddy writes its own state there too.
+	return cleanTmpDir(rcfg)
+}
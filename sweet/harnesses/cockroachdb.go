@@ -5,21 +5,40 @@
 package harnesses
 
 import (
+	"bytes"
+	"context"
+	"debug/elf"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
+	"golang.org/x/benchmarks/sweet/common/fileutil"
 	"golang.org/x/benchmarks/sweet/common/log"
+	sprofile "golang.org/x/benchmarks/sweet/common/profile"
+
+	"github.com/google/pprof/profile"
+	"golang.org/x/sync/errgroup"
 )
 
 // CockroachDB implements the Harness interface.
 type CockroachDB struct{}
 
+func init() {
+	Register("cockroachdb", CockroachDB{})
+}
+
 func (h CockroachDB) CheckPrerequisites() error {
 	// Cockroachdb is only supported on arm64 and amd64 architectures.
 	if runtime.GOARCH != "arm64" && runtime.GOARCH != "amd64" {
@@ -28,16 +47,83 @@ func (h CockroachDB) CheckPrerequisites() error {
 	return nil
 }
 
+const (
+	// cockroachdbCommit is the commit of cockroach to build.
+	cockroachdbCommit = "c4a0d997e0da6ba3ebede61b791607aa452b9bbc"
+
+	// cockroachdbRequiredPR is a pull request that cockroachdbCommit
+	// must include for the build to work.
+	cockroachdbRequiredPR = 125588
+)
+
+// cockroachdbSubmodules are the submodules of the cockroach repository
+// that must be populated for the build to work.
+var cockroachdbSubmodules = []string{
+	"c-deps/proj",
+}
+
 func (h CockroachDB) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		// Build against a local checkout, which may contain arbitrary
+		// changes. We can't check for any specific commit, but we can at
+		// least make sure the required submodules were checked out.
+		for _, sm := range cockroachdbSubmodules {
+			des, err := os.ReadDir(filepath.Join(gcfg.LocalSrc, sm))
+			if err != nil || len(des) == 0 {
+				return fmt.Errorf("local source %q is missing submodule %s; try `git submodule update --init --recursive`", gcfg.LocalSrc, sm)
+			}
+		}
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
 	// Build against a commit that includes https://github.com/cockroachdb/cockroach/pull/125588.
 	// Recursive clone the repo as we need certain submodules, i.e.
-	// PROJ, for the build to work.
-	return gitRecursiveCloneToCommit(
-		gcfg.SrcDir,
-		"https://github.com/cockroachdb/cockroach",
-		"master",
-		"c4a0d997e0da6ba3ebede61b791607aa452b9bbc",
-	)
+	// PROJ, for the build to work. The clone is large and prone to
+	// failing on flaky networks, so retry it if necessary.
+	//
+	// Checking that a commit includes the PR takes its history, so only
+	// the pinned commit, which does, may be fetched without it.
+	commit, shallow := cockroachdbCommit, gcfg.Shallow
+	if gcfg.Commit != "" {
+		commit, shallow = gcfg.Commit, false
+		log.Infof("cockroachdb: fetching %s instead of the pinned commit %s", commit, cockroachdbCommit)
+	}
+	if err := retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitRecursiveCloneToCommit(
+			gcfg.SrcDir,
+			"https://github.com/cockroachdb/cockroach",
+			"master",
+			commit,
+			gcfg.MirrorBase,
+			shallow,
+			gcfg.Stats,
+		)
+	}); err != nil {
+		return err
+	}
+	if err := verifyClone(gcfg.SrcDir, gcfg.ExpectedTreeHash); err != nil {
+		return err
+	}
+	if shallow {
+		if partial, err := gitIsShallow(gcfg.SrcDir); err != nil {
+			return err
+		} else if partial {
+			log.Debugf("cockroachdb: fetched only commit %s, not checking it for PR #%d", commit, cockroachdbRequiredPR)
+			return nil
+		}
+	}
+	// Make sure the pinned commit actually includes the PR, so that an
+	// accidental change to the commit fails here rather than with a
+	// confusing build failure much later. PRs are merged by a bot whose
+	// merge commits are titled "Merge #<pr> [#<pr>...]", so look for one
+	// among the pinned commit's ancestors.
+	merge, err := gitLogGrep(gcfg.SrcDir, fmt.Sprintf("^Merge (#[0-9]+ )*#%d( |$)", cockroachdbRequiredPR))
+	if err != nil {
+		return fmt.Errorf("checking cockroachdb commit %s for PR #%d: %v", commit, cockroachdbRequiredPR, err)
+	}
+	if merge == "" {
+		return fmt.Errorf("cockroachdb commit %s predates https://github.com/cockroachdb/cockroach/pull/%d, which is required to build it", commit, cockroachdbRequiredPR)
+	}
+	return nil
 }
 
 func (h CockroachDB) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
@@ -45,57 +131,168 @@ func (h CockroachDB) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
 	// We do this by using the cockroach `dev` tool. The dev tool is a bazel
 	// wrapper normally used for building cockroach, but can also be used to
 	// generate artifacts that can then be built by `go build`.
+	ctx, cancel := bcfg.BuildContext()
+	defer cancel()
+
+	if bcfg.Static && runtime.GOOS != "linux" {
+		return fmt.Errorf("cockroachdb can only be linked statically on linux, not %s", runtime.GOOS)
+	}
 
 	// Install bazel via bazelisk which is used by `dev`. Install it in the
 	// BinDir to ensure we get a new copy every run and avoid reuse. This is
 	// done by setting the `GOBIN` env var for the `go install` cmd.
 	goInstall := cfg.GoTool()
 	goInstall.Env = goInstall.Env.MustSet(fmt.Sprintf("GOBIN=%s", bcfg.BinDir))
-	if err := goInstall.Do(bcfg.BinDir, "install", "github.com/bazelbuild/bazelisk@latest"); err != nil {
+	log.Infof("cockroachdb: installing bazelisk")
+	if err := goInstall.DoContext(ctx, bcfg.BinDir, "install", "github.com/bazelbuild/bazelisk@latest"); err != nil {
 		return fmt.Errorf("error building bazelisk: %v", err)
 	}
 
-	// Helper that returns the path to the bazel binary.
-	bazel := func() string {
-		return filepath.Join(bcfg.BinDir, "bazelisk")
+	// If caching is enabled, keep bazel's output in the cache, keyed by the
+	// commit we're building, alongside the generated sources that refer
+	// into it.
+	//
+	// Otherwise, if the source is a local checkout, which outlives the
+	// build, keep the c-deps built for it in bazel's workspace, so that
+	// later builds of the same commit can skip building them again.
+	var cacheDir string
+	var commit string
+	keepCDeps := false
+	if bcfg.CacheDir != "" {
+		var err error
+		commit, err = gitHeadCommit(bcfg.SrcDir)
+		if err != nil {
+			return fmt.Errorf("error determining cockroachdb commit for caching: %v", err)
+		}
+		cacheDir = filepath.Join(bcfg.CacheDir, "cockroachdb", commit)
+	} else if info, err := os.Lstat(bcfg.SrcDir); err != nil {
+		return err
+	} else if info.Mode()&fs.ModeSymlink != 0 {
+		// The c-deps are kept for a commit, so there's no keeping them
+		// for a checkout that isn't a git repository.
+		if c, err := gitHeadCommit(bcfg.SrcDir); err != nil {
+			log.Debugf("cockroachdb: not keeping c-deps for %s: %v", bcfg.SrcDir, err)
+		} else {
+			commit, keepCDeps = c, true
+		}
+	}
+	reuseCDeps := false
+	if keepCDeps && !bcfg.ForceCDeps {
+		var err error
+		reuseCDeps, err = cockroachdbCDepsBuilt(bcfg.SrcDir, commit)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Clean up the bazel workspace. If we don't do this, our _bazel directory
-	// will quickly grow as Bazel treats each run as its own workspace with its
-	// own artifacts.
-	defer func() {
-		cmd := exec.Command(bazel(), "clean", "--expunge")
-		cmd.Dir = bcfg.SrcDir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		// Cleanup is best effort, there might not be anything to clean up
-		// if we fail early enough in the build process.
-		_ = cmd.Run()
-	}()
-
-	// Configure the build env.
+	// Configure the build env. Code generation is part of building
+	// cockroach, so it uses the same toolchain as the binary.
+	goroot := cfg.BuildGoRootOrDefault()
 	env := cfg.BuildEnv.Env
-	env = env.Prefix("PATH", filepath.Join(cfg.GoRoot, "bin")+":")
-	env = env.MustSet("GOROOT=" + cfg.GoRoot)
+	env = env.PrependPath("PATH", filepath.Join(goroot, "bin"))
+	env = env.MustSet("GOROOT=" + goroot)
+	// cockroach is a module and its build breaks in GOPATH mode, so don't
+	// let a stray GO111MODULE from the environment through.
+	env = env.Unset("GO111MODULE")
 
-	// Use bazel to generate the artifacts needed to enable a `go build`.
-	cmd := exec.Command(bazel(), "run", "//pkg/gen:code")
-	cmd.Dir = bcfg.SrcDir
-	cmd.Env = env.Collapse()
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	// Helper that runs a bazel command in the source directory, streaming
+	// its output to bcfg.Output. Each command gets its own line buffering,
+	// so the output of concurrent commands doesn't interleave mid-line.
+	// If ctx is done first, the command is killed, along with any
+	// processes it started that are still in its process group.
+	bazel := func(ctx context.Context, args ...string) error {
+		if cacheDir != "" {
+			args = append([]string{"--output_user_root=" + filepath.Join(cacheDir, "bazel")}, args...)
+		}
+		out := log.NewPrefixWriter(bcfg.Output, "")
+		defer out.Flush()
+		cmd := common.CommandContext(ctx, filepath.Join(bcfg.BinDir, "bazelisk"), args...)
+		cmd.Dir = bcfg.SrcDir
+		cmd.Env = env.Collapse()
+		cmd.Stdout = out
+		cmd.Stderr = out
+		log.TraceCommand(cmd, false)
+		if dryRun(cfg, cmd) {
+			return nil
+		}
+		err := common.RunCommand(cmd)
+		if err != nil && ctx.Err() != nil {
+			return fmt.Errorf("bazel %s: %w: %v", strings.Join(args, " "), ctx.Err(), err)
+		}
 		return err
 	}
 
-	// Build the c-deps needed.
-	cmd = exec.Command(bazel(), "run", "//pkg/cmd/generate-cgo:generate-cgo", "--run_under", fmt.Sprintf("cd %s && ", bcfg.SrcDir))
-	cmd.Dir = bcfg.SrcDir
-	cmd.Env = env.Collapse()
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	// Clean up the bazel workspace. If we don't do this, our _bazel directory
+	// will quickly grow as Bazel treats each run as its own workspace with its
+	// own artifacts. If we're caching, or keeping the c-deps, the artifacts
+	// are exactly what we want to keep.
+	if cacheDir == "" && !keepCDeps {
+		defer func() {
+			// Cleanup is best effort, there might not be anything to clean up
+			// if we fail early enough in the build process. It gets to run
+			// even if the build timed out.
+			_ = bazel(context.Background(), "clean", "--expunge")
+		}()
+	}
+
+	if ok, err := cockroachdbGenCached(cacheDir); err != nil {
 		return err
+	} else if ok {
+		// The cache is warm: restore the generated sources instead of
+		// generating them again.
+		genDir := filepath.Join(cacheDir, "gen")
+		log.Infof("cockroachdb: restoring generated code from %s", genDir)
+		log.CommandPrintf("cp -r %s/* %s", genDir, bcfg.SrcDir)
+		if err := fileutil.CopyDir(bcfg.SrcDir, genDir, nil); err != nil {
+			return fmt.Errorf("error restoring generated code from cache: %v", err)
+		}
+	} else {
+		// Use bazel to generate the artifacts needed to enable a `go build`,
+		// and build the c-deps needed. These steps are independent, so run
+		// them concurrently. The bazel server will serialize the parts that
+		// need exclusive access to the workspace, but the generators
+		// themselves can run in parallel.
+		//
+		// Note that Wait waits for both steps to finish even if one fails,
+		// so the deferred clean up can't clobber a running step.
+		//
+		// If the c-deps built by an earlier build are still there, only
+		// generate the code.
+		var g errgroup.Group
+		g.Go(func() error {
+			return bazel(ctx, "run", "//pkg/gen:code")
+		})
+		if reuseCDeps {
+			log.Infof("cockroachdb: generating code with bazel, reusing the c-deps built for %s (use -force-cdeps to rebuild them)", commit)
+		} else {
+			log.Infof("cockroachdb: generating code and building c-deps with bazel")
+			// Don't leave a stamp behind for c-deps that may be only
+			// partly rebuilt if the build fails.
+			if keepCDeps && !cfg.DryRun {
+				if err := removeCockroachDBCDepsStamp(bcfg.SrcDir); err != nil {
+					return err
+				}
+			}
+			g.Go(func() error {
+				return bazel(ctx, "run", "//pkg/cmd/generate-cgo:generate-cgo", "--run_under", fmt.Sprintf("cd %s && ", bcfg.SrcDir))
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		if keepCDeps && !reuseCDeps && !cfg.DryRun {
+			if err := stampCockroachDBCDeps(bcfg.SrcDir, commit); err != nil {
+				return fmt.Errorf("error recording the c-deps built: %v", err)
+			}
+		}
+
+		// In a dry run nothing was generated, so don't cache the
+		// remains of an ungenerated tree as if it were complete.
+		if cacheDir != "" && !cfg.DryRun {
+			if err := cacheCockroachDBGen(cacheDir, bcfg.SrcDir); err != nil {
+				return fmt.Errorf("error caching generated code: %v", err)
+			}
+		}
 	}
 
 	// Finally build the cockroach binary with `go build`. Build the
@@ -104,85 +301,856 @@ func (h CockroachDB) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
 	//
 	// As of go1.23, we need to pass the `-ldflags=-checklinkname=0` flag
 	// to build cockroach. However, benchmark release branches are on older
-	// versions that don't recognize the flag. Try first with the flag and
-	// again without if there is an error.
-	if buildWithFlagErr := cfg.GoTool().BuildPath(filepath.Join(bcfg.SrcDir, "pkg/cmd/cockroach-short"), bcfg.BinDir, "-ldflags=-checklinkname=0"); buildWithFlagErr != nil {
-		if buildWithoutFlagErr := cfg.GoTool().BuildPath(filepath.Join(bcfg.SrcDir, "pkg/cmd/cockroach-short"), bcfg.BinDir); buildWithoutFlagErr != nil {
-			return errors.Join(buildWithFlagErr, buildWithoutFlagErr)
+	// versions that don't recognize the flag, so only pass it to
+	// toolchains that do.
+	//
+	// If we were given a PGO profile, make the build profile-guided.
+	//
+	// The configuration's GOEXPERIMENT and GOFLAGS apply to this build
+	// only, not to code generation. Experiments are compiled into the
+	// binary, so the workload needs no GOEXPERIMENT when it runs.
+	//
+	// The binary and the benchmark wrapper may be built with different
+	// toolchains, so the binary can be compared against a fixed wrapper.
+	pgo, err := pgoProfile(bcfg)
+	if err != nil {
+		return err
+	}
+	goTool := cfg.BuildGoTool()
+	release, err := goTool.ReleaseVersion()
+	if err != nil {
+		return fmt.Errorf("error determining Go version: %v", err)
+	}
+	opts := &common.BuildOptions{PGO: pgo}
+	if release.AtLeast(1, 23) {
+		opts.Ldflags = append(opts.Ldflags, "-checklinkname=0")
+	}
+	if bcfg.Static {
+		// The c-deps are linked in with cgo, so cgo must stay enabled,
+		// and the external linker does the static linking. Use the pure
+		// Go user and DNS lookups, since glibc's can't be linked
+		// statically.
+		goTool.Env = goTool.Env.MustSet("CGO_ENABLED=1")
+		opts.Tags = append(opts.Tags, "osusergo", "netgo")
+		opts.Ldflags = append(opts.Ldflags, "-linkmode=external", "-extldflags=-static")
+	}
+	goTool.Env = bcfg.GoEnv(goTool.Env)
+	log.Infof("cockroachdb: building cockroach-short")
+	if err := goTool.BuildPathOpts(ctx, filepath.Join(bcfg.SrcDir, "pkg/cmd/cockroach-short"), bcfg.BinDir, opts); err != nil {
+		if bcfg.Static {
+			return fmt.Errorf("linking cockroach statically, which requires static versions of the system libraries the c-deps use, e.g. from glibc-static: %w", err)
+		}
+		return err
+	}
+	if bcfg.Static && !cfg.DryRun {
+		if err := checkStaticBinary(filepath.Join(bcfg.BinDir, "cockroach-short")); err != nil {
+			return err
 		}
 	}
 
 	// Rename the binary from cockroach-short to cockroach for
 	// ease of use.
-	if err := copyFile(filepath.Join(bcfg.BinDir, "cockroach"), filepath.Join(bcfg.BinDir, "cockroach-short")); err != nil {
+	if err := copyBuiltFile(cfg, filepath.Join(bcfg.BinDir, "cockroach"), filepath.Join(bcfg.BinDir, "cockroach-short")); err != nil {
 		return err
 	}
 
 	// Build the benchmark wrapper.
-	if err := cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "cockroachdb-bench")); err != nil {
+	log.Infof("cockroachdb: building the benchmark wrapper")
+	if err := cfg.BenchGoTool().BuildPathContext(ctx, bcfg.BenchDir, filepath.Join(bcfg.BinDir, "cockroachdb-bench")); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// checkStaticBinary returns an error if the ELF binary at path is linked
+// dynamically, which may happen even when linking with -static, e.g. if
+// a library supplies only a shared version of itself.
+func checkStaticBinary(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return fmt.Errorf("reading the libraries %s is linked with: %w", path, err)
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP && len(libs) == 0 {
+			libs = []string{"the dynamic linker"}
+		}
+	}
+	if len(libs) != 0 {
+		return fmt.Errorf("%s isn't statically linked: it loads %s at run time", path, strings.Join(libs, ", "))
+	}
+	return nil
+}
+
+// cockroachdbShortDuration is how long each benchmark's workload runs
+// for in short mode. The results are too noisy to be useful, but each
+// benchmark still exercises its whole configuration.
+const cockroachdbShortDuration = 5 * time.Second
+
+// cockroachdbSoakSamples is about how many samples of the servers' memory
+// use and GC are reported over each benchmark's run, with RunConfig.Duration.
+const cockroachdbSoakSamples = 60
+
+// cockroachdbSampleInterval returns how often to sample the servers of a
+// benchmark whose workload runs for d: often enough to show a trend, but
+// not so often that the samples drown out the rest of the results.
+func cockroachdbSampleInterval(d time.Duration) time.Duration {
+	interval := (d / cockroachdbSoakSamples).Round(time.Second)
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// cockroachdbBenchmark describes one of the benchmarks the cockroachdb
+// wrapper runs: the kv workload against a cluster.
+type cockroachdbBenchmark struct {
+	// nodes is the number of nodes in the cluster.
+	nodes int
+
+	// readPercent is the percentage of the workload's operations that
+	// are reads rather than writes.
+	readPercent int
+
+	// concurrency is the number of concurrent workers the load generator
+	// runs, or 0 for the wrapper's default.
+	concurrency int
+}
+
+// name returns the name of b, which -bench-filter matches, e.g.
+// "kv95/nodes=3", or "kv95/nodes=3/conc=64" with an explicit
+// concurrency. The wrapper knows it by the name without the concurrency,
+// which it's passed separately; see splitCockroachDBConcurrency.
+func (b cockroachdbBenchmark) name() string {
+	name := fmt.Sprintf("kv%d/nodes=%d", b.readPercent, b.nodes)
+	if b.concurrency != 0 {
+		name += fmt.Sprintf("/conc=%d", b.concurrency)
+	}
+	return name
+}
+
+// splitCockroachDBConcurrency splits the name of a benchmark into the
+// name the wrapper knows it by and its concurrency, if it has one, e.g.
+// "kv95/nodes=3/conc=64" into "kv95/nodes=3" and "64".
+func splitCockroachDBConcurrency(bench string) (name, concurrency string) {
+	name, concurrency, _ = strings.Cut(bench, "/conc=")
+	return name, concurrency
+}
+
+// cockroachdbDefaultReadPercents are the read percentages of the kv
+// workloads the benchmarks run, unless RunConfig.ReadPercents says
+// otherwise: writes only, a mix, and mostly reads.
+var cockroachdbDefaultReadPercents = []int{0, 50, 95}
+
+// cockroachdbDefaultNodeCounts are the sizes of the clusters the
+// workloads run against, unless RunConfig.NodeCounts says otherwise: a
+// single node and a small cluster.
+var cockroachdbDefaultNodeCounts = []int{1, 3}
+
+// cockroachdbBenchmarks returns the benchmarks the cockroachdb harness
+// runs, in order: the kv workload with each of readPercents reads against
+// a cluster of each of nodeCounts nodes. Either defaults if empty. If
+// concurrencies is non-empty, each of those runs with each of
+// concurrencies workers; otherwise, with the wrapper's default.
+//
+// Short mode runs every benchmark, just for a short while, so that it
+// catches breakage specific to any of them.
+func cockroachdbBenchmarks(nodeCounts, readPercents, concurrencies []int) []cockroachdbBenchmark {
+	if len(nodeCounts) == 0 {
+		nodeCounts = cockroachdbDefaultNodeCounts
+	}
+	if len(readPercents) == 0 {
+		readPercents = cockroachdbDefaultReadPercents
+	}
+	if len(concurrencies) == 0 {
+		concurrencies = []int{0}
+	}
+	var benchmarks []cockroachdbBenchmark
+	for _, nodes := range nodeCounts {
+		for _, p := range readPercents {
+			for _, c := range concurrencies {
+				benchmarks = append(benchmarks, cockroachdbBenchmark{nodes: nodes, readPercent: p, concurrency: c})
+			}
+		}
+	}
+	return benchmarks
+}
+
+// cockroachdbSmokeBenchmarks returns the cheapest of benchmarks, the one
+// with the smallest cluster and the fewest reads, e.g. kv0/nodes=1, for
+// a smoke run.
+func cockroachdbSmokeBenchmarks(benchmarks []cockroachdbBenchmark) []cockroachdbBenchmark {
+	if len(benchmarks) == 0 {
+		return nil
+	}
+	min := benchmarks[0]
+	for _, b := range benchmarks[1:] {
+		if b.nodes < min.nodes || b.nodes == min.nodes && b.readPercent < min.readPercent {
+			min = b
+		}
+	}
+	return []cockroachdbBenchmark{min}
+}
+
+// cockroachdbBenchmarkNames returns the names of benchmarks.
+func cockroachdbBenchmarkNames(benchmarks []cockroachdbBenchmark) []string {
+	var names []string
+	for _, b := range benchmarks {
+		names = append(names, b.name())
+	}
+	return names
+}
+
+// cockroachdbMinCPUsPerProcess is how many CPUs each cockroach node,
+// and the load generator, need for a cluster's results to mean much.
+// The wrapper shares the CPUs out between them, so with fewer, they
+// mostly measure how they compete for the CPUs rather than how cockroach
+// performs or scales.
+const cockroachdbMinCPUsPerProcess = 2
+
+// warnCockroachDBCPUsOnce makes fitCockroachDBBenchmarks log what it
+// skips just once, rather than for every run.
+var warnCockroachDBCPUsOnce sync.Once
+
+// fitCockroachDBBenchmarks returns those of benchmarks whose clusters
+// fit on the CPUs they run on, with cockroachdbMinCPUsPerProcess for
+// each node and the load generator, warning about those skipped if warn.
+// With rcfg.Force, every benchmark is kept, but those that don't fit are
+// still warned about. It returns an error if none fit.
+func fitCockroachDBBenchmarks(benchmarks []cockroachdbBenchmark, rcfg *common.RunConfig, warn bool) ([]cockroachdbBenchmark, error) {
+	if rcfg.Remote != nil {
+		// The CPUs that matter are the remote host's.
+		return benchmarks, nil
+	}
+	cpus := runtime.NumCPU()
+	if cpuPinningSupported(rcfg) {
+		list, err := parseCPUList(rcfg.CPUList)
+		if err != nil {
+			// benchmarkCmd reports this.
+			return benchmarks, nil
+		}
+		cpus = len(list)
+	}
+	var fit []cockroachdbBenchmark
+	warned := make(map[int]bool)
+	for _, b := range benchmarks {
+		need := (b.nodes + 1) * cockroachdbMinCPUsPerProcess
+		if cpus >= need || rcfg.Force {
+			fit = append(fit, b)
+		}
+		if cpus >= need || !warn || warned[b.nodes] {
+			continue
+		}
+		warned[b.nodes] = true
+		what := "skipping it (pass -force to run it anyway)"
+		if rcfg.Force {
+			what = "running it anyway (-force)"
+		}
+		log.Warnf("cockroachdb with %d nodes needs at least %d CPUs, %d for each node and for the load generator, but has %d; %s", b.nodes, need, cockroachdbMinCPUsPerProcess, cpus, what)
+	}
+	if len(fit) == 0 && len(benchmarks) != 0 {
+		return nil, fmt.Errorf("every cockroachdb benchmark needs more than the %d CPUs there are; pass -force to run them anyway", cpus)
+	}
+	return fit, nil
+}
+
+func (h CockroachDB) Benchmarks() []string {
+	return cockroachdbBenchmarkNames(cockroachdbBenchmarks(nil, nil, nil))
+}
+
+func (h CockroachDB) Metrics() []string {
+	metrics := []string{"ns/op", "p50-latency-ns", "p95-latency-ns", "p99-latency-ns"}
+	for _, typ := range []string{"read", "write"} {
+		for _, unit := range []string{"ops/sec", "ops", "ns/op", "p50-latency-ns", "p95-latency-ns", "p99-latency-ns", "p100-latency-ns"} {
+			metrics = append(metrics, typ+"-"+unit)
+		}
+	}
+	return append(metrics, "peak-RSS-bytes", "average-RSS-bytes", "server-peak-RSS-bytes", "server-avg-RSS-bytes", "gc-cpu-fraction")
+}
+
 func (h CockroachDB) Run(cfg *common.Config, rcfg *common.RunConfig) error {
-	benchmarks := []string{"kv0/nodes=1", "kv50/nodes=1", "kv95/nodes=1", "kv0/nodes=3", "kv50/nodes=3", "kv95/nodes=3"}
-	if rcfg.Short {
-		benchmarks = []string{"kv0/nodes=3", "kv95/nodes=3"}
+	var warn bool
+	warnCockroachDBCPUsOnce.Do(func() { warn = true })
+	all, err := fitCockroachDBBenchmarks(cockroachdbBenchmarks(rcfg.NodeCounts, rcfg.ReadPercents, rcfg.Concurrency), rcfg, warn)
+	if err != nil {
+		return err
+	}
+	if rcfg.Smoke {
+		all = cockroachdbSmokeBenchmarks(all)
+	}
+	benchmarks, err := filterBenchmarks(cockroachdbBenchmarkNames(all), rcfg.BenchFilter)
+	if err != nil {
+		return err
+	}
+	if err := checkRemote(rcfg); err != nil {
+		return err
+	}
+
+	var stagingDir string
+	if rcfg.ProfileDir != "" {
+		if err := mkdirAll(rcfg.ProfileDir); err != nil {
+			return err
+		}
+	}
+	if rcfg.TraceDir != "" {
+		if err := mkdirAll(rcfg.TraceDir); err != nil {
+			return err
+		}
+	}
+	if rcfg.ProfileDir != "" || rcfg.PGOProfile != "" {
+		// The wrapper writes many profiles with non-deterministic names,
+		// so collect them somewhere private and merge them afterwards.
+		stagingDir = filepath.Join(rcfg.TmpDir, "profiles")
 	}
 
 	for _, bench := range benchmarks {
-		args := append(rcfg.Args, []string{
-			"-bench", bench,
-			"-cockroachdb-bin", filepath.Join(rcfg.BinDir, "cockroach"),
-			"-tmp", rcfg.TmpDir,
-		}...)
-		if rcfg.Short {
-			args = append(args, "-short")
-		}
-		// The short benchmarks take about 1 minute to run.
-		// The long benchmarks take about 10 minutes to run.
-		// We set the timeout to 30 minutes to give ample buffer.
-		cmd := exec.Command(
-			filepath.Join(rcfg.BinDir, "cockroachdb-bench"),
-			args...,
+		for _, v := range execVariants(rcfg) {
+			if err := h.runBenchmark(cfg, rcfg, bench, v, stagingDir); err != nil {
+				return err
+			}
+		}
+	}
+	if rcfg.PGOProfile != "" {
+		log.Printf("Wrote PGO profile for cockroachdb to %s", rcfg.PGOProfile)
+	}
+	return nil
+}
+
+// runBenchmark runs a single cockroachdb benchmark in the variant v of
+// the execution environment. If stagingDir is non-empty, profiles are
+// collected there and then merged into rcfg.ProfileDir.
+func (h CockroachDB) runBenchmark(cfg *common.Config, rcfg *common.RunConfig, bench string, v execVariant, stagingDir string) error {
+	// Bring the system to a steady state first. Each warmup starts a
+	// fresh cluster, just like the measured run, so this mostly warms up
+	// the machine rather than cockroach itself: page cache, CPU
+	// frequency, and so on.
+	for i := 0; i < rcfg.Warmup; i++ {
+		if err := h.warmup(cfg, rcfg, bench, v); err != nil {
+			return fmt.Errorf("warmup %d of %s%s: %w", i+1, bench, v.tag, err)
+		}
+	}
+
+	args := rcfg.Args
+	if stagingDir != "" {
+		if err := mkdirAll(stagingDir); err != nil {
+			return err
+		}
+		args = append(args[:len(args):len(args)],
+			diagnostics.CPUProfile.AsFlag(), stagingDir,
+			diagnostics.MemProfile.AsFlag(), stagingDir,
 		)
-		cmd.Env = cfg.ExecEnv.Collapse()
-		cmd.Stdout = rcfg.Results
-		cmd.Stderr = rcfg.Results
-		log.TraceCommand(cmd, false)
-		if err := cmd.Start(); err != nil {
+	}
+	var traceStagingDir string
+	if rcfg.TraceDir != "" {
+		// Like profiles, the wrapper names traces non-deterministically.
+		traceStagingDir = filepath.Join(rcfg.TmpDir, "traces")
+		if err := mkdirAll(traceStagingDir); err != nil {
+			return err
+		}
+		args = append(args[:len(args):len(args)], diagnostics.Trace.AsFlag(), traceStagingDir)
+	}
+	var heapDiffDir, contentionDir string
+	if rcfg.ProfileDir != "" {
+		heapDiffDir = filepath.Join(rcfg.TmpDir, "heap-diff")
+		contentionDir = filepath.Join(rcfg.TmpDir, "contention")
+		args = append(args, "-heap-diff-dir", heapDiffDir, "-contention-profile-dir", contentionDir)
+		if rcfg.MutexProfileFraction != 0 {
+			args = append(args, "-mutex-profile-fraction", strconv.Itoa(rcfg.MutexProfileFraction))
+		}
+		if rcfg.BlockProfileRate != 0 {
+			args = append(args, "-block-profile-rate", strconv.Itoa(rcfg.BlockProfileRate))
+		}
+	}
+	cmd, err := h.benchmarkCmd(cfg, rcfg, args, bench, v)
+	if err != nil {
+		return err
+	}
+	setResultsOutput(cmd, cfg, rcfg)
+	reportPerfStat := perfStat(cmd, rcfg, "CockroachDB"+bench+v.tag)
+	removeContainer, err := containerize(cmd, rcfg)
+	if err != nil {
+		return err
+	}
+	runRemotely(cmd, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	// The wrapper starts the cockroach servers, which mustn't outlive it:
+	// they would hold on to their ports and tmp for the next run.
+	common.SetProcessGroup(cmd)
+	err = runHooked(cfg, rcfg, bench+v.tag, func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+	removeContainer()
+	if err != nil {
+		return errors.Join(err, preserveCockroachLogs(rcfg, bench+v.tag))
+	}
+	reportPerfStat()
+
+	if stagingDir != "" && rcfg.ProfileDir != "" {
+		// Name profiles after the benchmark, e.g. kv0-nodes=1.cpu.pprof.
+		prefix := filepath.Join(rcfg.ProfileDir, strings.ReplaceAll(bench+v.tag, "/", "-"))
+		if err := mergeProfiles(stagingDir, diagnostics.CPUProfile, prefix+".cpu.pprof"); err != nil {
+			return err
+		}
+		if err := mergeProfiles(stagingDir, diagnostics.MemProfile, prefix+".mem.pprof"); err != nil {
+			return err
+		}
+	}
+	if traceStagingDir != "" {
+		// The wrapper traces each server in many short windows, so name
+		// the traces after the benchmark and number them, e.g.
+		// kv0-nodes=1.0.trace, kv0-nodes=1.1.trace, and so on.
+		prefix := filepath.Join(rcfg.TraceDir, strings.ReplaceAll(bench+v.tag, "/", "-"))
+		if err := collectTraces(traceStagingDir, prefix); err != nil {
 			return err
 		}
-		if rcfg.Short {
-			if err := cmd.Wait(); err != nil {
+	}
+	if heapDiffDir != "" {
+		// Summarize how the servers' heaps grew over the benchmark, e.g.
+		// in kv0-nodes=1.heap-diff.txt.
+		prefix := filepath.Join(rcfg.ProfileDir, strings.ReplaceAll(bench+v.tag, "/", "-"))
+		if err := writeHeapDiff(heapDiffDir, prefix+".heap-diff", bench+v.tag); err != nil {
+			return err
+		}
+	}
+	if contentionDir != "" {
+		// Sum the servers' contention over the benchmark, e.g. into
+		// kv0-nodes=1.mutex.pprof and kv0-nodes=1.block.pprof.
+		prefix := filepath.Join(rcfg.ProfileDir, strings.ReplaceAll(bench+v.tag, "/", "-"))
+		for _, kind := range []string{"mutex", "block"} {
+			if err := writeContentionProfile(contentionDir, kind, prefix+"."+kind+".pprof"); err != nil {
 				return err
 			}
-		} else {
-			// Wait for 30 minutes.
-			c := make(chan error)
-			go func() {
-				c <- cmd.Wait()
-			}()
-			select {
-			case err := <-c:
-				if err != nil {
-					return err
-				}
-			case <-time.After(30 * time.Minute):
-				if err := cmd.Process.Kill(); err != nil {
-					return fmt.Errorf("timeout, error killing process: %s", err.Error())
-				}
-				return fmt.Errorf("timeout")
+		}
+	}
+	if rcfg.ProfileSummary && rcfg.ProfileDir != "" {
+		// Summarize each profile written above, e.g. kv0-nodes=1.cpu.pprof
+		// in kv0-nodes=1.cpu.top.txt.
+		prefix := filepath.Join(rcfg.ProfileDir, strings.ReplaceAll(bench+v.tag, "/", "-"))
+		bin := filepath.Join(rcfg.BinDir, "cockroach")
+		for _, kind := range []string{"cpu", "mem", "mutex", "block"} {
+			if err := writeProfileSummary(cfg, bin, prefix+"."+kind+".pprof"); err != nil {
+				return err
 			}
 		}
+	}
+	if stagingDir != "" && rcfg.PGOProfile != "" {
+		// Accumulate the CPU profiles of every benchmark, across both the
+		// kv workloads and cluster sizes, into a single PGO profile.
+		if err := mergeProfilesInto(stagingDir, diagnostics.CPUProfile, rcfg.PGOProfile); err != nil {
+			return err
+		}
+	}
+
+	// Delete tmp because cockroachdb will have written something there and
+	// might attempt to reuse it. We don't want to reuse the same cluster, so
+	// if anything is left behind, stop rather than let it skew the results
+	// of the remaining benchmarks. With an in-memory store, the data is
+	// gone with the servers, but their logs are still there.
+	if err := cleanTmpDir(rcfg); err != nil {
+		return fmt.Errorf("aborting remaining benchmarks: %w", err)
+	}
+	return nil
+}
+
+// benchmarkCmd returns the command to run bench in the variant v of the
+// execution environment, passing args to the wrapper.
+func (h CockroachDB) benchmarkCmd(cfg *common.Config, rcfg *common.RunConfig, args []string, bench string, v execVariant) (*exec.Cmd, error) {
+	name, concurrency := splitCockroachDBConcurrency(bench)
+	args = append(args[:len(args):len(args)],
+		"-bench", name,
+		"-cockroachdb-bin", filepath.Join(rcfg.BinDir, "cockroach"),
+		"-tmp", rcfg.TmpDir,
+	)
+	if concurrency != "" {
+		args = append(args, "-concurrency", concurrency)
+	}
+	if rcfg.Secure {
+		// The wrapper generates the certificates itself, in tmp, so that
+		// they're wherever the cluster runs, e.g. in a container.
+		args = append(args, "-secure")
+	}
+	if rcfg.StoreMode != "" {
+		args = append(args, "-store", rcfg.StoreMode)
+	}
+	if rcfg.GCTrace {
+		args = append(args, "-gc-trace")
+	}
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	switch {
+	case rcfg.Duration != 0:
+		args = append(args,
+			"-duration", rcfg.Duration.String(),
+			"-sample-interval", cockroachdbSampleInterval(rcfg.Duration).String(),
+		)
+	case rcfg.Short:
+		args = append(args, "-duration", cockroachdbShortDuration.String())
+	}
+	if v.tag != "" {
+		args = append(args, "-name-suffix", v.tag)
+	}
+	if rcfg.ReadyTimeout != 0 {
+		args = append(args, "-ready-timeout", rcfg.ReadyTimeout.String())
+	}
+	if rcfg.Seed != 0 {
+		args = append(args, "-seed", strconv.FormatInt(rcfg.Seed, 10))
+	}
+	// Pin the cockroach servers to one half of the CPU list, and the
+	// wrapper, along with the load generator it runs, to the other.
+	var clientCPUs string
+	if cpuPinningSupported(rcfg) {
+		serverCPUs, cpus, err := splitCPUList(rcfg.CPUList)
+		if err != nil {
+			return nil, err
+		}
+		clientCPUs = cpus
+		args = append(args, "-server-cpus", serverCPUs)
+	}
+	cmd := exec.Command(
+		filepath.Join(rcfg.BinDir, "cockroachdb-bench"),
+		args...,
+	)
+	// The wrapper passes its environment on to the cockroach server
+	// processes it launches, so the variant applies to them as well.
+	// In particular, a GOMAXPROCS variant applies to every node, rather
+	// than being split between them as the wrapper otherwise does.
+	cmd.Env = cfg.ExecEnv.MustSet(v.env...).Collapse()
+	if clientCPUs != "" {
+		if err := pinCPUs(cmd, clientCPUs); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+// warmup runs bench once with its results discarded, cleaning up tmp
+// afterwards. Diagnostics aren't collected, so warmups don't leave
+// profiles behind either.
+func (h CockroachDB) warmup(cfg *common.Config, rcfg *common.RunConfig, bench string, v execVariant) error {
+	cmd, err := h.benchmarkCmd(cfg, rcfg, withoutDiagnosticArgs(rcfg.Args), bench, v)
+	if err != nil {
+		return err
+	}
+	// Hold on to the output in case the warmup fails.
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	removeContainer, err := containerize(cmd, rcfg)
+	if err != nil {
+		return err
+	}
+	runRemotely(cmd, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	common.SetProcessGroup(cmd)
+	err = runInCgroup(rcfg, cmd, nil)
+	removeContainer()
+	if err != nil {
+		return errors.Join(fmt.Errorf("%w\n%s", err, out.String()), preserveCockroachLogs(rcfg, bench+v.tag+"/warmup"))
+	}
+	if err := cleanTmpDir(rcfg); err != nil {
+		return fmt.Errorf("aborting remaining benchmarks: %w", err)
+	}
+	return nil
+}
+
+// preserveCockroachLogs copies the logs that the servers of the failed
+// benchmark name wrote to their stores in rcfg.TmpDir into
+// rcfg.FailureDir, e.g. to kv0-nodes=3/roach-node-1/logs, if it's set.
+// Nothing cleans up tmp after a failure, but the stores in it are
+// overwritten by the next run in the same work directory.
+func preserveCockroachLogs(rcfg *common.RunConfig, name string) error {
+	if rcfg.FailureDir == "" {
+		return nil
+	}
+	logDirs, err := filepath.Glob(filepath.Join(rcfg.TmpDir, "*", "logs"))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(rcfg.FailureDir, strings.ReplaceAll(name, "/", "-"))
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for _, logDir := range logDirs {
+		node := filepath.Base(filepath.Dir(logDir))
+		// cockroach links the latest log files from stable names, so
+		// the links need copying too.
+		if err := fileutil.CopyTree(filepath.Join(dir, node, "logs"), logDir); err != nil {
+			return fmt.Errorf("preserving logs of %s: %w", node, err)
+		}
+	}
+	if len(logDirs) != 0 {
+		log.Printf("Preserved cockroachdb logs of failed benchmark %s in %s", name, dir)
+	}
+	return nil
+}
+
+// collectTraces copies all the execution traces in dir to files named
+// <prefix>.<n>.trace, first removing any existing traces with that prefix
+// so that a rerun doesn't leave stale ones behind.
+func collectTraces(dir, prefix string) error {
+	old, err := filepath.Glob(prefix + ".*.trace")
+	if err != nil {
+		return err
+	}
+	for _, name := range old {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, de := range des {
+		if strings.Contains(de.Name(), "."+string(diagnostics.Trace)) {
+			names = append(names, de.Name())
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no traces found in %q", dir)
+	}
+	sort.Strings(names)
+	log.CommandPrintf("cp %s/*.%s* %s.*.trace", dir, diagnostics.Trace, prefix)
+	for i, name := range names {
+		dst := fmt.Sprintf("%s.%d.trace", prefix, i)
+		if err := fileutil.CopyFile(dst, filepath.Join(dir, name), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cockroachdbGenStamp is the name of the file written into a cockroachdb
+// build cache directory once its copy of the generated code is complete.
+const cockroachdbGenStamp = "gen.stamp"
+
+// cockroachdbGenCached reports whether cacheDir contains a complete copy
+// of the generated sources for a cockroachdb build. An empty cacheDir is
+// never populated.
+func cockroachdbGenCached(cacheDir string) (bool, error) {
+	if cacheDir == "" {
+		return false, nil
+	}
+	return fileutil.FileExists(filepath.Join(cacheDir, cockroachdbGenStamp))
+}
 
-		// Delete tmp because cockroachdb will have written something there and
-		// might attempt to reuse it. We don't want to reuse the same cluster.
-		if err := rmDirContents(rcfg.TmpDir); err != nil {
+// cacheCockroachDBGen copies all the files generated into srcDir by the
+// bazel code generation steps into cacheDir. Generated files are identified
+// as any files git doesn't know about after generation, excluding symlinks,
+// which bazel uses to point into its own output tree.
+func cacheCockroachDBGen(cacheDir, srcDir string) error {
+	cmd := exec.Command("git", "-C", srcDir, "ls-files", "--others", "-z")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cacheDir, "gen")
+	log.CommandPrintf("rm -rf %s", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		src := filepath.Join(srcDir, name)
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := fileutil.CopyFile(dst, src, info, nil); err != nil {
 			return err
 		}
 	}
+	stamp := filepath.Join(cacheDir, cockroachdbGenStamp)
+	log.CommandPrintf("touch %s", stamp)
+	return os.WriteFile(stamp, nil, 0644)
+}
+
+// cockroachdbCDepsStamp is the name of the file written into a cockroachdb
+// source directory once its c-deps are built and kept for later builds.
+// It records the commit they were built for and, one per line after it,
+// the cgo flags files generate-cgo wrote, which point to the c-deps.
+const cockroachdbCDepsStamp = ".sweet-cdeps-stamp"
+
+// cgoLibDir matches the library directories in cgo flags files.
+var cgoLibDir = regexp.MustCompile(`-L(\S+)`)
+
+// cockroachdbCDepsBuilt reports whether the c-deps kept by an earlier
+// build of srcDir may be reused by a build of commit: that the stamp
+// names commit, that c-deps has no uncommitted changes, and that the cgo
+// flags files and the library directories they point to still exist.
+func cockroachdbCDepsBuilt(srcDir, commit string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(srcDir, cockroachdbCDepsStamp))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] != commit {
+		return false, nil
+	}
+	cmd := exec.Command("git", "-C", srcDir, "status", "--porcelain", "--", "c-deps")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	if len(bytes.TrimSpace(out)) != 0 {
+		return false, nil
+	}
+	for _, name := range lines[1:] {
+		flags, err := os.ReadFile(filepath.Join(srcDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		for _, m := range cgoLibDir.FindAllSubmatch(flags, -1) {
+			if ok, err := fileutil.FileExists(string(m[1])); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// stampCockroachDBCDeps records that the c-deps of srcDir were built for
+// commit, along with the cgo flags files that point to them: any untracked
+// file generate-cgo may have written.
+func stampCockroachDBCDeps(srcDir, commit string) error {
+	cmd := exec.Command("git", "-C", srcDir, "ls-files", "--others", "-z", "--", "*zcgo_flags*.go")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	lines := []string{commit}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			lines = append(lines, name)
+		}
+	}
+	stamp := filepath.Join(srcDir, cockroachdbCDepsStamp)
+	log.CommandPrintf("touch %s", stamp)
+	return os.WriteFile(stamp, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// removeCockroachDBCDepsStamp removes the stamp of the c-deps of srcDir,
+// if there is one.
+func removeCockroachDBCDepsStamp(srcDir string) error {
+	stamp := filepath.Join(srcDir, cockroachdbCDepsStamp)
+	log.CommandPrintf("rm -f %s", stamp)
+	if err := os.Remove(stamp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	return nil
 }
+
+// mergeProfiles merges all profiles of type typ in dir into a single
+// profile written to out, overwriting any existing file.
+func mergeProfiles(dir string, typ diagnostics.Type, out string) error {
+	profiles, err := readProfiles(dir, typ)
+	if err != nil {
+		return err
+	}
+	return writeMergedProfile(profiles, typ, dir, out)
+}
+
+// mergeProfilesInto is like mergeProfiles, but merges any profile that
+// already exists at out into the result rather than overwriting it.
+func mergeProfilesInto(dir string, typ diagnostics.Type, out string) error {
+	profiles, err := readProfiles(dir, typ)
+	if err != nil {
+		return err
+	}
+	if f, err := os.Open(out); err == nil {
+		p, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading existing profile %q: %w", out, err)
+		}
+		profiles = append(profiles, p)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return writeMergedProfile(profiles, typ, dir, out)
+}
+
+// profileSummaryTop is the number of functions listed in a profile
+// summary.
+const profileSummaryTop = 20
+
+// writeProfileSummary writes the profileSummaryTop functions with the
+// most samples in the profile at path, a .pprof file, symbolized against
+// the binary bin, to a .top.txt file alongside it.
+func writeProfileSummary(cfg *common.Config, bin, path string) error {
+	out := strings.TrimSuffix(path, ".pprof") + ".top.txt"
+	goTool := cfg.GoTool()
+	cmd := exec.Command(goTool.Tool, "tool", "pprof", "-top", fmt.Sprintf("-nodecount=%d", profileSummaryTop), bin, path)
+	cmd.Env = goTool.Env.Collapse()
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd.Stdout = f
+	log.TraceCommand(cmd, false)
+	if err := common.RunCommand(cmd); err != nil {
+		return fmt.Errorf("summarizing profile %s: %w", path, err)
+	}
+	return nil
+}
+
+// readProfiles reads all profiles of type typ in dir, returning an error
+// if there are none.
+func readProfiles(dir string, typ diagnostics.Type) ([]*profile.Profile, error) {
+	profiles, err := sprofile.ReadDirPprof(dir, func(name string) bool {
+		return strings.Contains(name, "."+string(typ))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s profiles from %q: %w", typ, dir, err)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no %s profiles found in %q", typ, dir)
+	}
+	return profiles, nil
+}
+
+// writeMergedProfile merges profiles, of type typ and read from dir, and
+// writes the result to out.
+func writeMergedProfile(profiles []*profile.Profile, typ diagnostics.Type, dir, out string) error {
+	p, err := profile.Merge(profiles)
+	if err != nil {
+		return fmt.Errorf("error merging %s profiles: %w", typ, err)
+	}
+	log.CommandPrintf("go tool pprof -proto %s/*.%s* > %s", dir, typ, out)
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.Write(f)
+}
@@ -5,48 +5,484 @@
 package harnesses
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/diagnostics"
 	"golang.org/x/benchmarks/sweet/common/fileutil"
 	"golang.org/x/benchmarks/sweet/common/log"
 )
 
-func gitShallowClone(dir, url, ref string) error {
-	cmd := exec.Command("git", "clone", "--depth", "1", "-b", ref, url, dir)
+// gitClone returns a git clone command with args. If mirror is
+// non-empty, the clone, and any submodule clones, fetch from mirror rather
+// than github.com: https://github.com/org/repo is rewritten to
+// <mirror>/org/repo.
+func gitClone(mirror string, args ...string) *exec.Cmd {
+	return gitFetchCommand(mirror, append([]string{"clone"}, args...)...)
+}
+
+// gitFetchCommand returns a git command with args that fetches from
+// github.com, or from mirror instead, as for gitClone.
+func gitFetchCommand(mirror string, args ...string) *exec.Cmd {
+	if mirror != "" {
+		// Configuration set with -c is inherited by the git commands
+		// that clone submodules.
+		insteadOf := fmt.Sprintf("url.%s/.insteadOf=https://github.com/", strings.TrimSuffix(mirror, "/"))
+		args = append([]string{"-c", insteadOf}, args...)
+	}
+	return exec.Command("git", args...)
+}
+
+func gitShallowClone(dir, url, ref, mirror string) error {
+	cmd := gitClone(mirror, "--depth", "1", "-b", ref, url, dir)
 	log.TraceCommand(cmd, false)
-	_, err := cmd.Output()
-	return err
+	return common.RunCommand(cmd)
 }
 
-func gitRecursiveCloneToCommit(dir, url, branch, hash string) error {
-	cloneCmd := exec.Command("git", "clone", "--recursive", "--shallow-submodules", "-b", branch, url, dir)
+// gitRecursiveCloneToCommit clones url into dir, with its submodules,
+// and checks out the commit hash, which may also be a ref, on branch.
+// If shallow, it first tries to fetch only that commit, and its
+// submodules' commits, which not all servers allow, falling back to
+// cloning branch's whole history. What was fetched is recorded in stats.
+func gitRecursiveCloneToCommit(dir, url, branch, hash, mirror string, shallow bool, stats *common.GetStats) error {
+	if shallow {
+		fetches, err := gitShallowFetchCommit(dir, url, hash, mirror)
+		if err == nil {
+			stats.Add(fetches...)
+			return nil
+		}
+		log.Warnf("failed to fetch only commit %s of %s, cloning its whole history instead: %v", hash, url, err)
+		log.CommandPrintf("rm -rf %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to clean up partial fetch: %w", err)
+		}
+	}
+	start := time.Now()
+	cloneCmd := gitClone(mirror, "-b", branch, url, dir)
 	log.TraceCommand(cloneCmd, false)
-	if _, err := cloneCmd.Output(); err != nil {
+	if err := common.RunCommand(cloneCmd); err != nil {
+		return err
+	}
+	repo, err := gitFetchStat(url, "", filepath.Join(dir, ".git"), start)
+	if err != nil {
+		return err
+	}
+	hash, err = gitResolveCommit(dir, hash)
+	if err != nil {
 		return err
 	}
 	checkoutCmd := exec.Command("git", "-C", dir, "checkout", hash)
 	log.TraceCommand(checkoutCmd, false)
-	_, err := checkoutCmd.Output()
+	if err := common.RunCommand(checkoutCmd); err != nil {
+		return err
+	}
+	// Fetch the submodules the commit pins, which needn't be the ones
+	// the branch pins.
+	subs, err := gitUpdateSubmodules(dir, mirror)
+	if err != nil {
+		return err
+	}
+	stats.Add(append([]common.FetchStat{repo}, subs...)...)
+	return nil
+}
+
+// gitShallowFetchCommit fetches just the commit hash, which may also be
+// a ref, of the repository at url into a new repository in dir, with its
+// submodules at depth 1, and checks it out. It returns what it fetched.
+func gitShallowFetchCommit(dir, url, hash, mirror string) ([]common.FetchStat, error) {
+	for _, cmd := range []*exec.Cmd{
+		exec.Command("git", "init", "-q", dir),
+		exec.Command("git", "-C", dir, "remote", "add", "origin", url),
+	} {
+		log.TraceCommand(cmd, false)
+		if err := common.RunCommand(cmd); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	fetchCmd := gitFetchCommand(mirror, "-C", dir, "fetch", "--depth", "1", "origin", hash)
+	log.TraceCommand(fetchCmd, false)
+	if err := common.RunCommand(fetchCmd); err != nil {
+		return nil, err
+	}
+	repo, err := gitFetchStat(url, "", filepath.Join(dir, ".git"), start)
+	if err != nil {
+		return nil, err
+	}
+	checkoutCmd := exec.Command("git", "-C", dir, "checkout", "-q", "FETCH_HEAD")
+	log.TraceCommand(checkoutCmd, false)
+	if err := common.RunCommand(checkoutCmd); err != nil {
+		return nil, err
+	}
+	subs, err := gitUpdateSubmodules(dir, mirror)
+	if err != nil {
+		return nil, err
+	}
+	return append([]common.FetchStat{repo}, subs...), nil
+}
+
+// gitUpdateSubmodules fetches and checks out each submodule of the
+// repository in dir, and theirs in turn, at depth 1. It fetches them one
+// at a time, to return how long each one took.
+func gitUpdateSubmodules(dir, mirror string) ([]common.FetchStat, error) {
+	gitmodules := filepath.Join(dir, ".gitmodules")
+	if _, err := os.Stat(gitmodules); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	cmd := exec.Command("git", "config", "-f", gitmodules, "--get-regexp", `^submodule\..*\.(path|url)$`)
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing submodules of %s: %w", dir, err)
+	}
+	// Each line is "submodule.<name>.<key> <value>".
+	var names []string
+	paths, urls := make(map[string]string), make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		k, v, _ := strings.Cut(line, " ")
+		k = strings.TrimPrefix(k, "submodule.")
+		if name, ok := strings.CutSuffix(k, ".path"); ok {
+			names = append(names, name)
+			paths[name] = v
+		} else if name, ok := strings.CutSuffix(k, ".url"); ok {
+			urls[name] = v
+		}
+	}
+	var fetches []common.FetchStat
+	for _, name := range names {
+		start := time.Now()
+		cmd := gitFetchCommand(mirror, "-C", dir, "submodule", "update", "--init", "--recursive", "--depth", "1", "--", paths[name])
+		log.TraceCommand(cmd, false)
+		if err := common.RunCommand(cmd); err != nil {
+			return nil, err
+		}
+		f, err := gitFetchStat(urls[name], paths[name], filepath.Join(dir, ".git", "modules", name), start)
+		if err != nil {
+			return nil, err
+		}
+		fetches = append(fetches, f)
+	}
+	return fetches, nil
+}
+
+// gitFetchStat describes a fetch from url, of the submodule at path or
+// of the repository itself if path is empty, that started at start and
+// stored its objects in the git directory gitDir. A submodule's objects
+// include those of its own submodules, but the repository's exclude its
+// submodules'.
+func gitFetchStat(url, path, gitDir string, start time.Time) (common.FetchStat, error) {
+	f := common.FetchStat{URL: url, Path: path, Seconds: time.Since(start).Seconds()}
+	err := filepath.WalkDir(gitDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == "" && p == filepath.Join(gitDir, "modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		sep := string(filepath.Separator)
+		if rel, _ := filepath.Rel(gitDir, p); !d.Type().IsRegular() || !strings.Contains(sep+rel, sep+"objects"+sep) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f.Bytes += info.Size()
+		return nil
+	})
+	return f, err
+}
+
+// gitIsShallow reports whether the repository in dir is missing history,
+// e.g. because it was fetched by gitShallowFetchCommit.
+func gitIsShallow(dir string) (bool, error) {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "--is-shallow-repository")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "true", nil
+}
+
+// gitResolveCommit returns the full hash of the commit ref, a commit
+// hash or a ref like a branch or tag, refers to in the repository in
+// dir. Branches are looked for among the remote's, too.
+func gitResolveCommit(dir, ref string) (string, error) {
+	for _, r := range []string{ref, "origin/" + ref} {
+		cmd := exec.Command("git", "-C", dir, "rev-parse", "--verify", "--quiet", "--end-of-options", r+"^{commit}")
+		log.TraceCommand(cmd, false)
+		out, err := cmd.Output()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+		var ee *exec.ExitError
+		if !errors.As(err, &ee) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %q in %s", errUnknownCommit, ref, dir)
+}
+
+// errUnknownCommit is the error gitResolveCommit returns for a ref that
+// doesn't name a commit. Cloning again won't help.
+var errUnknownCommit = errors.New("no such commit")
+
+// verifyClone checks that the tree checked out in dir has the git tree
+// hash treeHash, and that every submodule is checked out at the commit
+// the tree pins. If not, it deletes dir, so that a tampered source tree
+// isn't picked up by a later run, and returns an error. An empty
+// treeHash skips verification.
+func verifyClone(dir, treeHash string) error {
+	if treeHash == "" {
+		return nil
+	}
+	err := verifyTree(dir, treeHash)
+	if err == nil {
+		return nil
+	}
+	log.CommandPrintf("rm -rf %s", dir)
+	if rmErr := os.RemoveAll(dir); rmErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to remove unverified source: %w", rmErr))
+	}
 	return err
 }
 
-func gitCloneToCommit(dir, url, branch, hash string) error {
-	cloneCmd := exec.Command("git", "clone", "-b", branch, url, dir)
+func verifyTree(dir, treeHash string) error {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "HEAD^{tree}")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	if got := strings.TrimSpace(string(out)); got != treeHash {
+		return fmt.Errorf("source tree in %s has hash %s, expected %s", dir, got, treeHash)
+	}
+	// Each line of output describes a submodule, and starts with a space
+	// only if its checked out commit is the one the tree pins: "+" means
+	// a different commit, "-" not initialized and "U" conflicts.
+	cmd = exec.Command("git", "-C", dir, "submodule", "status", "--recursive")
+	log.TraceCommand(cmd, false)
+	out, err = cmd.Output()
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if line != "" && line[0] != ' ' {
+			return fmt.Errorf("submodule in %s is not checked out at its pinned commit: %s", dir, line)
+		}
+	}
+	return nil
+}
+
+// gitLogGrep returns the hash of the most recent ancestor of the commit
+// checked out in dir whose commit message matches the extended regular
+// expression pattern, or the empty string if there is none.
+func gitLogGrep(dir, pattern string) (string, error) {
+	cmd := exec.Command("git", "-C", dir, "log", "-n", "1", "--format=%H", "-E", "--grep="+pattern, "HEAD")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitHeadCommit returns the hash of the commit checked out in dir.
+func gitHeadCommit(dir string) (string, error) {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "HEAD")
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// retryClone calls clone, retrying up to retries times if it fails. clone
+// is expected to populate dir, which is removed before each retry so that
+// every attempt starts from scratch. Attempts are spaced out with an
+// exponential backoff.
+func retryClone(dir string, retries int, clone func() error) error {
+	backoff := 5 * time.Second
+	for attempt := 0; ; attempt++ {
+		err := clone()
+		if err == nil || attempt >= retries || errors.Is(err, errUnknownCommit) {
+			return err
+		}
+		log.Printf("failed to clone into %s (attempt %d of %d), retrying in %s: %v", dir, attempt+1, retries+1, backoff, err)
+		log.CommandPrintf("rm -rf %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to clean up partial clone: %w", err)
+		}
+		log.CommandPrintf("sleep %d", int(backoff.Seconds()))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func gitCloneToCommit(dir, url, branch, hash, mirror string) error {
+	cloneCmd := gitClone(mirror, "-b", branch, url, dir)
 	log.TraceCommand(cloneCmd, false)
-	if _, err := cloneCmd.Output(); err != nil {
+	if err := common.RunCommand(cloneCmd); err != nil {
 		return err
 	}
 	checkoutCmd := exec.Command("git", "-C", dir, "checkout", hash)
 	log.TraceCommand(checkoutCmd, false)
-	_, err := checkoutCmd.Output()
-	return err
+	return common.RunCommand(checkoutCmd)
 }
 
+// linkLocalSrc makes dir refer to the existing source tree at src,
+// which must be a directory.
+func linkLocalSrc(dir, src string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("local source: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("local source %q is not a directory", src)
+	}
+	if err := mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	return symlink(dir, src)
+}
+
+// smokeBenchmarks returns just the first of names for a smoke run, which
+// only needs to show that the harness works, or else all of them.
+func smokeBenchmarks(names []string, rcfg *common.RunConfig) []string {
+	if rcfg.Smoke && len(names) > 1 {
+		return names[:1]
+	}
+	return names
+}
+
+// filterBenchmarks returns the subset of names matching the regular
+// expression filter. An empty filter matches every name. It is an error
+// for a non-empty filter to match nothing.
+func filterBenchmarks(names []string, filter string) ([]string, error) {
+	if filter == "" {
+		return names, nil
+	}
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid benchmark filter: %w", err)
+	}
+	var matched []string
+	for _, name := range names {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("benchmark filter %q matches none of %s", filter, strings.Join(names, ", "))
+	}
+	return matched, nil
+}
+
+// execVariant is a variation on the environment a benchmark is executed in.
+type execVariant struct {
+	// tag is appended to the names of the benchmarks run in this
+	// variant, e.g. "/memlimit=2GiB".
+	tag string
+
+	// env are additional environment variables to set, of the form X=Y.
+	env []string
+}
+
+// execVariants returns all the variations of the execution environment
+// requested by rcfg. If rcfg requests no variations, it returns a single
+// variant that changes nothing.
+func execVariants(rcfg *common.RunConfig) []execVariant {
+	variants := []execVariant{{}}
+	variants = crossVariants(variants, "memlimit", "GOMEMLIMIT", rcfg.MemLimits)
+	var gogcs []string
+	for _, gogc := range rcfg.GOGCValues {
+		if gogc < 0 {
+			gogcs = append(gogcs, "off")
+		} else {
+			gogcs = append(gogcs, strconv.Itoa(gogc))
+		}
+	}
+	variants = crossVariants(variants, "gogc", "GOGC", gogcs)
+	var procs []string
+	for _, p := range rcfg.GOMAXPROCSValues {
+		procs = append(procs, strconv.Itoa(p))
+	}
+	variants = crossVariants(variants, "gomaxprocs", "GOMAXPROCS", procs)
+	return variants
+}
+
+// crossVariants returns the cross product of variants with the values
+// for the environment variable envVar, tagged as name=value. If values
+// is empty, variants is returned unchanged.
+func crossVariants(variants []execVariant, name, envVar string, values []string) []execVariant {
+	if len(values) == 0 {
+		return variants
+	}
+	var result []execVariant
+	for _, v := range variants {
+		for _, value := range values {
+			result = append(result, execVariant{
+				tag: fmt.Sprintf("%s/%s=%s", v.tag, name, value),
+				env: append(v.env[:len(v.env):len(v.env)], envVar+"="+value),
+			})
+		}
+	}
+	return result
+}
+
+// copyFile copies the file at src to dst, giving dst the file mode of src
+// even if dst already exists, so that, say, a copied binary is executable.
+// dst is synced and checked to be as large as src before returning, so a
+// short copy can't go unnoticed until whatever uses dst fails.
 func copyFile(dst, src string) error {
 	log.CommandPrintf("cp %s %s", src, dst)
-	return fileutil.CopyFile(dst, src, nil, nil)
+	sfinfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.CopyFile(dst, src, sfinfo, nil); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Chmod(sfinfo.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	dfinfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if dfinfo.Size() != sfinfo.Size() {
+		return fmt.Errorf("copying %s to %s: copied %d bytes, expected %d", src, dst, dfinfo.Size(), sfinfo.Size())
+	}
+	return f.Close()
 }
 
 func makeWriteable(dir string) error {
@@ -62,7 +498,296 @@ func makeWriteable(dir string) error {
 	})
 }
 
+func mkdirAll(path string) error {
+	log.CommandPrintf("mkdir -p %s", path)
+	return os.MkdirAll(path, os.ModePerm)
+}
+
 func symlink(dst, src string) error {
 	log.CommandPrintf("ln -s %s %s", src, dst)
 	return os.Symlink(src, dst)
 }
+
+// dryRun reports whether cfg is a dry run, in which case it prints cmd
+// and the caller must skip running it.
+func dryRun(cfg *common.Config, cmd *exec.Cmd) bool {
+	if !cfg.DryRun {
+		return false
+	}
+	log.DryRunCommand(cmd)
+	return true
+}
+
+// copyBuiltFile is like copyFile, but for copying a file produced by a
+// build command. In a dry run, that command was skipped, so the copy is
+// only printed.
+func copyBuiltFile(cfg *common.Config, dst, src string) error {
+	if cfg.DryRun {
+		log.DryRunPrintf("cp %s %s", src, dst)
+		return nil
+	}
+	return copyFile(dst, src)
+}
+
+// setResultsOutput directs the output of cmd, a benchmark binary, to
+// rcfg.Results and, if set, copies it to rcfg.TeeResults and converts
+// the results in it for rcfg.JSONResults and rcfg.EventsWriter.
+func setResultsOutput(cmd *exec.Cmd, cfg *common.Config, rcfg *common.RunConfig) {
+	if rcfg.JSONResults == nil && rcfg.TeeResults == nil && rcfg.EventsWriter == nil {
+		cmd.Stdout = rcfg.Results
+		cmd.Stderr = rcfg.Results
+		return
+	}
+	ws := []io.Writer{rcfg.Results}
+	if rcfg.TeeResults != nil {
+		ws = append(ws, rcfg.TeeResults)
+	}
+	if rcfg.JSONResults != nil {
+		ws = append(ws, common.NewJSONResultsWriter(rcfg.JSONResults, rcfg.Benchmark, cfg.Name))
+	}
+	if rcfg.EventsWriter != nil {
+		ws = append(ws, common.NewResultEventsWriter(rcfg.EventsWriter, rcfg.Benchmark, cfg.Name))
+	}
+	out := io.MultiWriter(ws...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// The output now goes through a pipe, which any servers the binary
+	// leaves behind, e.g. after being killed on timeout, may hold open.
+	// Don't wait on them forever.
+	cmd.WaitDelay = 10 * time.Second
+}
+
+// runtimeMetricsArgs returns the arguments for a benchmark binary that has
+// it snapshot rcfg.RuntimeMetrics into rcfg.ProfileDir, if both are set.
+func runtimeMetricsArgs(rcfg *common.RunConfig) []string {
+	if len(rcfg.RuntimeMetrics) == 0 || rcfg.ProfileDir == "" {
+		return nil
+	}
+	return []string{
+		"-runtime-metrics-dir", rcfg.ProfileDir,
+		"-runtime-metrics", strings.Join(rcfg.RuntimeMetrics, ","),
+	}
+}
+
+// runHooked calls run to run the benchmark named name, the harness's
+// name for one of the benchmarks it runs under cfg, or "" if run runs
+// all of them at once, notifying rcfg.Hooks before and after. The hooks
+// are told about the results run writes to rcfg.Results. It also writes
+// an EventBenchmarkStart to rcfg.EventsWriter, if set.
+func runHooked(cfg *common.Config, rcfg *common.RunConfig, name string, run func() error) error {
+	if rcfg.EventsWriter != nil {
+		err := common.WriteEvent(rcfg.EventsWriter, common.Event{
+			Type:      common.EventBenchmarkStart,
+			Benchmark: rcfg.Benchmark,
+			Config:    cfg.Name,
+			Name:      name,
+		})
+		if err != nil {
+			return fmt.Errorf("writing event: %w", err)
+		}
+	}
+	if len(rcfg.Hooks) == 0 {
+		return run()
+	}
+	if name == "" {
+		name = rcfg.Benchmark
+	} else {
+		name = rcfg.Benchmark + "/" + name
+	}
+	for _, h := range rcfg.Hooks {
+		if err := h.BeforeBenchmark(name); err != nil {
+			return fmt.Errorf("hook before %s: %w", name, err)
+		}
+	}
+	// Note where the benchmark's results start, to read them back.
+	offset := int64(-1)
+	if rcfg.Results != nil {
+		if off, err := rcfg.Results.Seek(0, io.SeekCurrent); err == nil {
+			offset = off
+		}
+	}
+	start := time.Now()
+	err := run()
+	result := &common.RunResult{Duration: time.Since(start), Err: err}
+	if offset >= 0 {
+		if end, serr := rcfg.Results.Seek(0, io.SeekCurrent); serr == nil {
+			result.Results, _ = common.ReadResults(io.NewSectionReader(rcfg.Results, offset, end-offset))
+		}
+	}
+	errs := []error{err}
+	for _, h := range rcfg.Hooks {
+		if herr := h.AfterBenchmark(name, result); herr != nil {
+			errs = append(errs, fmt.Errorf("hook after %s: %w", name, herr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withoutDiagnosticArgs returns a copy of args, which are arguments for
+// a benchmark binary, with any flags for collecting diagnostics or core
+// dumps removed. This is useful for runs whose results are discarded.
+func withoutDiagnosticArgs(args []string) []string {
+	drop := map[string]bool{"-dump-cores": true}
+	for _, t := range diagnostics.Types() {
+		drop[t.AsFlag()] = true
+		drop[t.AsFlag()+"-flags"] = true
+	}
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		if drop[args[i]] {
+			// Skip the flag's value too.
+			i++
+			continue
+		}
+		kept = append(kept, args[i])
+	}
+	return kept
+}
+
+// pgoProfile returns the PGO profile in bcfg to build with, if any. It
+// returns an error if the profile is missing or empty, since building
+// without it would silently produce a binary that isn't profile-guided.
+func pgoProfile(bcfg *common.BuildConfig) (string, error) {
+	if bcfg.PGOProfile == "" {
+		return "", nil
+	}
+	info, err := os.Stat(bcfg.PGOProfile)
+	if err != nil {
+		return "", fmt.Errorf("PGO profile: %w", err)
+	}
+	if info.Size() == 0 {
+		return "", fmt.Errorf("PGO profile %s is empty", bcfg.PGOProfile)
+	}
+	return bcfg.PGOProfile, nil
+}
+
+// runWithTimeout starts cmd and waits for it to complete. If timeout is
+// non-zero and cmd does not complete within timeout, cmd is killed and
+// an error is returned.
+//
+// If grace is non-zero, a timed out cmd is first sent SIGTERM, and only
+// killed if it's still running after grace, giving it a chance to exit
+// cleanly and write out what it has, e.g. profiles. Only cmd's own process
+// is sent SIGTERM, so that it can shut down the processes it started in
+// an orderly way.
+//
+// On timeout, a note is appended to results and results is synced before
+// returning, so that whatever partial output cmd managed to write is
+// available for inspection. results may be nil.
+//
+// If cmd fails because it, or a process it started, was OOM-killed, the
+// error wraps common.ErrOOMKilled, and a note is appended to results too.
+//
+// If cmd was set up to run in its own process group with
+// common.SetProcessGroup, killing it kills everything it started too, and
+// so does cmd exiting, so that nothing it started outlives it. Since the
+// group doesn't receive the terminal's signals, runWithTimeout kills it
+// once ctx is done too, typically because sweet was interrupted.
+func runWithTimeout(ctx context.Context, cmd *exec.Cmd, timeout, grace time.Duration, results *os.File) error {
+	return runWatchingOOM(ctx, cmd, timeout, grace, results, watchOOM())
+}
+
+// runInCgroup is like runWithTimeout, with rcfg's context, timeout and
+// grace, except that if rcfg.Cgroup is set, it runs cmd in a new cgroup
+// created from it, and OOM kills are counted there. The cgroup is removed
+// once cmd is done, killing anything left in it.
+func runInCgroup(rcfg *common.RunConfig, cmd *exec.Cmd, results *os.File) error {
+	if rcfg.Cgroup == nil {
+		return runWithTimeout(rcfg.Context(), cmd, rcfg.Timeout, rcfg.TimeoutGrace, results)
+	}
+	cg, err := rcfg.Cgroup.Create()
+	if err != nil {
+		return err
+	}
+	cg.Apply(cmd)
+	err = runWatchingOOM(rcfg.Context(), cmd, rcfg.Timeout, rcfg.TimeoutGrace, results, watchOOMIn(cg.MemoryEvents()))
+	return errors.Join(err, cg.Remove())
+}
+
+// runWatchingOOM is runWithTimeout, with OOM kills detected by oom.
+func runWatchingOOM(ctx context.Context, cmd *exec.Cmd, timeout, grace time.Duration, results *os.File, oom *oomWatch) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Kill whatever's left of the process group once cmd is done. This
+	// fails harmlessly if there's nothing left.
+	defer common.KillProcessGroup(cmd)
+	exited := func(err error) error {
+		err = oom.check(cmd, err)
+		if errors.Is(err, common.ErrOOMKilled) && results != nil {
+			fmt.Fprintf(results, "# %s\n", err)
+		}
+		return err
+	}
+	c := make(chan error, 1)
+	go func() {
+		c <- cmd.Wait()
+	}()
+	var timedOut <-chan time.Time
+	if timeout != 0 {
+		timedOut = time.After(timeout)
+	}
+	select {
+	case err := <-c:
+		return exited(err)
+	case <-ctx.Done():
+		common.KillProcessGroup(cmd)
+		<-c
+		return fmt.Errorf("%s: %w", filepath.Base(cmd.Path), ctx.Err())
+	case <-timedOut:
+	}
+	exitedInGrace := false
+	if grace != 0 && cmd.Process.Signal(syscall.SIGTERM) == nil {
+		select {
+		case <-c:
+			exitedInGrace = true
+		case <-time.After(grace):
+		}
+	}
+	if !exitedInGrace {
+		if err := common.KillProcessGroup(cmd); err != nil {
+			return fmt.Errorf("timeout after %s, error killing process: %v", timeout, err)
+		}
+		// Wait for the process to actually exit so that all of its output
+		// has landed in results.
+		<-c
+	}
+	if results == nil {
+		return fmt.Errorf("timeout after %s", timeout)
+	}
+	fmt.Fprintf(results, "# %s timed out after %s\n", filepath.Base(cmd.Path), timeout)
+	if err := results.Sync(); err != nil {
+		return fmt.Errorf("timeout after %s, error syncing results: %v", timeout, err)
+	}
+	return fmt.Errorf("timeout after %s, partial output in %s", timeout, results.Name())
+}
+
+// replaceModule writes a copy of the go.mod (and go.sum) governing
+// bcfg.BenchDir into bcfg.BinDir with mod replaced by dir, and returns
+// the path to the new go.mod, suitable for passing to -modfile.
+func replaceModule(cfg *common.Config, bcfg *common.BuildConfig, mod, dir string) (string, error) {
+	goTool := cfg.GoTool()
+	cmd := exec.Command(goTool.Tool, "env", "GOMOD")
+	cmd.Dir = bcfg.BenchDir
+	cmd.Env = goTool.Env.Collapse()
+	log.TraceCommand(cmd, false)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("finding go.mod for %s: %w", bcfg.BenchDir, err)
+	}
+	srcMod := strings.TrimSpace(string(out))
+	if srcMod == "" || srcMod == "/dev/null" {
+		return "", fmt.Errorf("%s is not in a module", bcfg.BenchDir)
+	}
+	modFile := filepath.Join(bcfg.BinDir, "go.mod")
+	if err := copyFile(modFile, srcMod); err != nil {
+		return "", err
+	}
+	if err := copyFile(filepath.Join(bcfg.BinDir, "go.sum"), strings.TrimSuffix(srcMod, ".mod")+".sum"); err != nil {
+		return "", err
+	}
+	if err := goTool.Do("", "mod", "edit", "-replace="+mod+"="+dir, modFile); err != nil {
+		return "", err
+	}
+	return modFile, nil
+}
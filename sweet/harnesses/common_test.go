@@ -0,0 +1,164 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+func TestCopyFileExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no executable bit on windows")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "cockroach-short")
+	if err := os.WriteFile(src, []byte("#!/bin/sh\necho ok\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The copy must be executable even if it replaces a file that isn't.
+	dst := filepath.Join(dir, "cockroach")
+	if err := os.WriteFile(dst, []byte("stale contents, longer than the source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(dst, src); err != nil {
+		t.Fatalf("copyFile: %v", err)
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0755 {
+		t.Errorf("copied file has mode %v, expected %v", got, os.FileMode(0755))
+	}
+	out, err := exec.Command(dst).Output()
+	if err != nil {
+		t.Fatalf("running copied binary: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "ok" {
+		t.Errorf("copied binary printed %q, expected %q", got, "ok")
+	}
+}
+
+// recordingHook is a RunHook that records what it's told.
+type recordingHook struct {
+	events  []string
+	results []*common.RunResult
+}
+
+func (h *recordingHook) BeforeBenchmark(name string) error {
+	h.events = append(h.events, "before "+name)
+	return nil
+}
+
+func (h *recordingHook) AfterBenchmark(name string, result *common.RunResult) error {
+	h.events = append(h.events, "after "+name)
+	h.results = append(h.results, result)
+	return nil
+}
+
+func TestRunHooked(t *testing.T) {
+	results, err := os.Create(filepath.Join(t.TempDir(), "go.results"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer results.Close()
+	// Results from a previous benchmark aren't reported again.
+	if _, err := results.WriteString("BenchmarkEtcdPut 1 100 ns/op\n"); err != nil {
+		t.Fatal(err)
+	}
+	h := new(recordingHook)
+	var events strings.Builder
+	rcfg := &common.RunConfig{
+		Benchmark:    "etcd",
+		Results:      results,
+		Hooks:        []common.RunHook{h},
+		EventsWriter: &events,
+	}
+	errBench := errors.New("benchmark failed")
+	err = runHooked(&common.Config{Name: "base"}, rcfg, "stm", func() error {
+		h.events = append(h.events, "run")
+		results.WriteString("goos: linux\nBenchmarkEtcdSTM 1 200 ns/op 5 p50-latency-ns\n")
+		return errBench
+	})
+	if !errors.Is(err, errBench) {
+		t.Errorf("runHooked returned %v, expected %v", err, errBench)
+	}
+	if got, want := strings.Join(h.events, ", "), "before etcd/stm, run, after etcd/stm"; got != want {
+		t.Errorf("got events %q, expected %q", got, want)
+	}
+	if len(h.results) != 1 {
+		t.Fatalf("got %d results, expected 1", len(h.results))
+	}
+	r := h.results[0]
+	if r.Err != errBench {
+		t.Errorf("got result error %v, expected %v", r.Err, errBench)
+	}
+	if len(r.Results) != 1 || r.Results[0].Name != "BenchmarkEtcdSTM" || r.Results[0].Metrics["p50-latency-ns"] != 5 {
+		t.Errorf("got results %+v, expected only BenchmarkEtcdSTM's", r.Results)
+	}
+	var e common.Event
+	if err := json.Unmarshal([]byte(events.String()), &e); err != nil {
+		t.Fatalf("failed to decode event %q: %v", events.String(), err)
+	}
+	if e.Type != common.EventBenchmarkStart || e.Benchmark != "etcd" || e.Config != "base" || e.Name != "stm" {
+		t.Errorf("got event %+v, expected the start of etcd/stm under base", e)
+	}
+}
+
+func TestRunHookedWholeBenchmark(t *testing.T) {
+	h := new(recordingHook)
+	var events strings.Builder
+	rcfg := &common.RunConfig{
+		Benchmark:    "caddy",
+		Hooks:        []common.RunHook{h},
+		EventsWriter: &events,
+	}
+	err := runHooked(&common.Config{Name: "base"}, rcfg, "", func() error {
+		h.events = append(h.events, "run")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runHooked returned %v", err)
+	}
+	if got, want := strings.Join(h.events, ", "), "before caddy, run, after caddy"; got != want {
+		t.Errorf("got events %q, expected %q", got, want)
+	}
+	var e common.Event
+	if err := json.Unmarshal([]byte(events.String()), &e); err != nil {
+		t.Fatalf("failed to decode event %q: %v", events.String(), err)
+	}
+	if e.Type != common.EventBenchmarkStart || e.Benchmark != "caddy" || e.Name != "" {
+		t.Errorf("got event %+v, expected the start of caddy as a whole", e)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if h := Lookup("cockroachdb"); h != (CockroachDB{}) {
+		t.Errorf("Lookup(%q) = %v, expected the built-in harness", "cockroachdb", h)
+	}
+	if h := Lookup("no-such-benchmark"); h != nil {
+		t.Errorf("Lookup of an unregistered benchmark returned %v", h)
+	}
+	names := Names()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("Names returned %v, which isn't sorted", names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering cockroachdb twice didn't panic")
+		}
+	}()
+	Register("cockroachdb", CockroachDB{})
+}
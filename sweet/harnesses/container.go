@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// containerCount numbers the containers started by this process, to give
+// each a unique name.
+var containerCount atomic.Int64
+
+// containerize arranges for cmd to run in the container described by
+// rcfg.Container, if any, instead of directly on the host. It must be
+// called last, once everything else about cmd is set up, so that any
+// wrappers like perf run inside the container too.
+//
+// It returns a function to call once cmd has exited, which removes the
+// container. Killing the runtime's CLI, e.g. on a timeout, doesn't stop
+// the container itself.
+func containerize(cmd *exec.Cmd, rcfg *common.RunConfig) (cleanup func(), err error) {
+	cleanup = func() {}
+	spec := rcfg.Container
+	if spec == nil {
+		return cleanup, nil
+	}
+	runtime := spec.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+	rt, err := exec.LookPath(runtime)
+	if err != nil {
+		return nil, fmt.Errorf("running in a container: %w", err)
+	}
+	name := fmt.Sprintf("sweet-%d-%d", os.Getpid(), containerCount.Add(1))
+
+	// Use the host's network so that benchmarks which talk to servers
+	// they start, or listen on fixed ports, work unchanged.
+	args := []string{"run", "--rm", "--init", "--name", name, "--network", "host"}
+	if spec.CPUs != "" {
+		args = append(args, "--cpus", spec.CPUs)
+	}
+	if spec.Memory != "" {
+		args = append(args, "--memory", spec.Memory)
+	}
+	for _, s := range spec.Sysctls {
+		args = append(args, "--sysctl", s)
+	}
+	dirs := []string{rcfg.BinDir, rcfg.TmpDir}
+	if rcfg.AssetsDir != "" {
+		if _, err := os.Stat(rcfg.AssetsDir); err == nil {
+			dirs = append(dirs, rcfg.AssetsDir)
+		}
+	}
+	if rcfg.Results != nil {
+		dirs = append(dirs, filepath.Dir(rcfg.Results.Name()))
+	}
+	for _, d := range dirs {
+		args = append(args, "-v", d+":"+d)
+	}
+	for _, m := range spec.Mounts {
+		args = append(args, "-v", m)
+	}
+	if cmd.Dir != "" {
+		args = append(args, "--workdir", cmd.Dir)
+	}
+	// Variables inherited unchanged from our own environment describe
+	// the host, not the container, so only pass on the ones that were
+	// set for the benchmark, like those from the configuration.
+	host := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		host[kv] = true
+	}
+	for _, kv := range cmd.Env {
+		if !host[kv] {
+			args = append(args, "-e", kv)
+		}
+	}
+	args = append(args, spec.Image, cmd.Path)
+	args = append(args, cmd.Args[1:]...)
+	cmd.Path = rt
+	cmd.Args = append([]string{rt}, args...)
+
+	return func() {
+		// The container is normally gone by now, thanks to --rm, so
+		// ignore errors.
+		rm := exec.Command(rt, "rm", "-f", name)
+		log.TraceCommand(rm, false)
+		_ = rm.Run()
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// parseCPUList parses a CPU list in the format accepted by taskset -c,
+// e.g. "0-3,8,10-11", returning the CPUs in it in ascending order.
+func parseCPUList(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	var cpus []int
+	for _, r := range strings.Split(list, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q: bad CPU %q", list, lo)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(hi)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU list %q: bad range %q", list, r)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// formatCPUList formats cpus as a CPU list accepted by taskset -c.
+func formatCPUList(cpus []int) string {
+	s := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		s[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(s, ",")
+}
+
+// splitCPUList splits the CPU list list into two disjoint halves, for
+// pinning a server and its load generator apart. If list contains only
+// one CPU, both halves are that CPU.
+func splitCPUList(list string) (server, client string, err error) {
+	cpus, err := parseCPUList(list)
+	if err != nil {
+		return "", "", err
+	}
+	if len(cpus) == 1 {
+		return list, list, nil
+	}
+	half := len(cpus) / 2
+	return formatCPUList(cpus[:half]), formatCPUList(cpus[half:]), nil
+}
+
+var warnCPUPinningOnce sync.Once
+
+// cpuPinningSupported reports whether RunConfig.CPUList can be honored on
+// this platform, warning once if it is set but can't be.
+func cpuPinningSupported(rcfg *common.RunConfig) bool {
+	if rcfg.CPUList == "" {
+		return false
+	}
+	if runtime.GOOS != "linux" {
+		warnCPUPinningOnce.Do(func() {
+			log.Warnf("CPU pinning is only supported on Linux, ignoring CPU list %q", rcfg.CPUList)
+		})
+		return false
+	}
+	return true
+}
+
+// pinCPUs arranges for cmd to run under taskset, pinned to the CPUs in
+// the CPU list cpus.
+func pinCPUs(cmd *exec.Cmd, cpus string) error {
+	taskset, err := exec.LookPath("taskset")
+	if err != nil {
+		return fmt.Errorf("pinning to CPUs %s: %w", cpus, err)
+	}
+	cmd.Args = append([]string{taskset, "-c", cpus, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = taskset
+	return nil
+}
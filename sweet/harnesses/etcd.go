@@ -5,9 +5,13 @@
 package harnesses
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/benchmarks/sweet/common"
 	"golang.org/x/benchmarks/sweet/common/log"
@@ -15,6 +19,10 @@ import (
 
 type Etcd struct{}
 
+func init() {
+	Register("etcd", Etcd{})
+}
+
 func (h Etcd) CheckPrerequisites() error {
 	return nil
 }
@@ -30,6 +38,7 @@ func (h Etcd) Get(gcfg *common.GetConfig) error {
 		gcfg.SrcDir,
 		"https://github.com/etcd-io/etcd",
 		"v3.6.0-alpha.0",
+		gcfg.MirrorBase,
 	)
 }
 
@@ -40,20 +49,21 @@ func (h Etcd) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
 	// visibility into how etcd is built to allow us to pass this information
 	// directly. Also set the GOROOT explicitly because it might have propagated
 	// differently from the environment.
-	env = env.Prefix("PATH", filepath.Join(cfg.GoRoot, "bin")+":")
+	env = env.PrependPath("PATH", filepath.Join(cfg.GoRoot, "bin"))
 	env = env.MustSet("GOROOT=" + cfg.GoRoot)
 
 	cmd := exec.Command("make", "-C", bcfg.SrcDir, "build")
 	cmd.Env = env.Collapse()
 	log.TraceCommand(cmd, false)
-	// Call Output here to get an *ExitError with a populated Stderr field.
-	if _, err := cmd.Output(); err != nil {
-		return err
+	if !dryRun(cfg, cmd) {
+		if err := common.RunCommand(cmd); err != nil {
+			return err
+		}
 	}
 	// Note that no matter what we do, the build script insists on putting the
 	// binaries into the source directory, so copy the one we care about into
 	// BinDir.
-	if err := copyFile(filepath.Join(bcfg.BinDir, "etcd"), filepath.Join(bcfg.SrcDir, "bin", "etcd")); err != nil {
+	if err := copyBuiltFile(cfg, filepath.Join(bcfg.BinDir, "etcd"), filepath.Join(bcfg.SrcDir, "bin", "etcd")); err != nil {
 		return err
 	}
 	// Build etcd's benchmarking tool. Our benchmark is just a wrapper around that.
@@ -65,47 +75,102 @@ func (h Etcd) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
 	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "etcd-bench"))
 }
 
+func (h Etcd) Benchmarks() []string {
+	return []string{"put", "stm", "wal-fsync"}
+}
+
 func (h Etcd) Run(cfg *common.Config, rcfg *common.RunConfig) error {
-	for _, bench := range []string{"put", "stm"} {
-		args := append(rcfg.Args, []string{
-			"-bench", bench,
-			"-etcd-bin", filepath.Join(rcfg.BinDir, "etcd"),
-			"-benchmark-bin", filepath.Join(rcfg.BinDir, "benchmark"),
-			"-tmp", rcfg.TmpDir,
-		}...)
-		if rcfg.Short {
-			args = append(args, "-short")
-		}
-		cmd := exec.Command(
-			filepath.Join(rcfg.BinDir, "etcd-bench"),
-			args...,
-		)
-		cmd.Env = cfg.ExecEnv.Collapse()
-		cmd.Stdout = rcfg.Results
-		cmd.Stderr = rcfg.Results
-		log.TraceCommand(cmd, false)
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-		// Delete tmp because etcd will have written something there and
-		// might attempt to reuse it.
-		if err := rmDirContents(rcfg.TmpDir); err != nil {
-			return err
+	benchmarks, err := filterBenchmarks(h.Benchmarks(), rcfg.BenchFilter)
+	if err != nil {
+		return err
+	}
+	benchmarks = smokeBenchmarks(benchmarks, rcfg)
+	for _, bench := range benchmarks {
+		// Run any warmups first, followed by the measured run.
+		for i := 0; i <= rcfg.Warmup; i++ {
+			warmup := i < rcfg.Warmup
+			args := rcfg.Args
+			if warmup {
+				args = withoutDiagnosticArgs(args)
+			}
+			args = append(args[:len(args):len(args)],
+				"-bench", bench,
+				"-etcd-bin", filepath.Join(rcfg.BinDir, "etcd"),
+				"-benchmark-bin", filepath.Join(rcfg.BinDir, "benchmark"),
+				"-tmp", rcfg.TmpDir,
+			)
+			if rcfg.Short {
+				args = append(args, "-short")
+			}
+			cmd := common.CommandContext(rcfg.Context(),
+				filepath.Join(rcfg.BinDir, "etcd-bench"),
+				args...,
+			)
+			cmd.Env = cfg.ExecEnv.Collapse()
+			// Discard the results of warmups, but hold on to their
+			// output in case they fail.
+			var out bytes.Buffer
+			if warmup {
+				cmd.Stdout = &out
+				cmd.Stderr = &out
+			} else {
+				setResultsOutput(cmd, cfg, rcfg)
+			}
+			log.TraceCommand(cmd, false)
+			if dryRun(cfg, cmd) {
+				continue
+			}
+			if warmup {
+				err = cmd.Run()
+			} else {
+				err = runHooked(cfg, rcfg, bench, cmd.Run)
+			}
+			if err != nil {
+				if warmup {
+					return fmt.Errorf("warmup %d of %s: %w\n%s", i+1, bench, err, out.String())
+				}
+				return err
+			}
+			// Delete tmp because etcd will have written something there and
+			// might attempt to reuse it.
+			if err := cleanTmpDir(rcfg); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// rmDirContents removes everything in dir, leaving dir itself in place.
+// It attempts to remove every entry even if some fail, and returns an
+// error listing each path that couldn't be removed. It also returns an
+// error if dir isn't empty afterwards, e.g. because a process that is
+// still running created new files in it.
 func rmDirContents(dir string) error {
 	log.CommandPrintf("rm -rf %s/*", dir)
 	fs, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
+	var errs []error
 	for _, fi := range fs {
 		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
-			return err
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove contents of %s:\n%w", dir, err)
+	}
+	fs, err = os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(fs) != 0 {
+		names := make([]string, 0, len(fs))
+		for _, fi := range fs {
+			names = append(names, fi.Name())
 		}
+		return fmt.Errorf("%s is not empty after removing its contents: %s", dir, strings.Join(names, ", "))
 	}
 	return nil
 }
@@ -6,8 +6,8 @@ package harnesses
 
 import (
 	"fmt"
-	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/benchmarks/sweet/common"
 	"golang.org/x/benchmarks/sweet/common/fileutil"
@@ -17,7 +17,7 @@ import (
 type buildBenchmark struct {
 	name  string
 	pkg   string
-	clone func(outDir string) error
+	clone func(outDir, mirror string) error
 }
 
 var (
@@ -25,34 +25,37 @@ var (
 		{
 			name: "kubernetes",
 			pkg:  "cmd/kubelet",
-			clone: func(outDir string) error {
+			clone: func(outDir, mirror string) error {
 				return gitShallowClone(
 					outDir,
 					"https://github.com/kubernetes/kubernetes",
 					"v1.22.1",
+					mirror,
 				)
 			},
 		},
 		{
 			name: "istio",
 			pkg:  "istioctl/cmd/istioctl",
-			clone: func(outDir string) error {
+			clone: func(outDir, mirror string) error {
 				return gitShallowClone(
 					outDir,
 					"https://github.com/istio/istio",
 					"1.11.1",
+					mirror,
 				)
 			},
 		},
 		{
 			name: "pkgsite",
 			pkg:  "cmd/frontend",
-			clone: func(outDir string) error {
+			clone: func(outDir, mirror string) error {
 				return gitCloneToCommit(
 					outDir,
 					"https://go.googlesource.com/pkgsite",
 					"master",
 					"0a8194a898a1ceff6a0b29e3419650daf43d8567",
+					mirror,
 				)
 			},
 		},
@@ -65,6 +68,10 @@ var (
 
 type GoBuild struct{}
 
+func init() {
+	Register("go-build", GoBuild{})
+}
+
 func (h GoBuild) CheckPrerequisites() error {
 	return nil
 }
@@ -72,7 +79,7 @@ func (h GoBuild) CheckPrerequisites() error {
 func (h GoBuild) Get(gcfg *common.GetConfig) error {
 	// Clone the sources that we're going to build.
 	for _, bench := range goBuildBenchmarks(gcfg.Short) {
-		if err := bench.clone(filepath.Join(gcfg.SrcDir, bench.name)); err != nil {
+		if err := bench.clone(filepath.Join(gcfg.SrcDir, bench.name), gcfg.MirrorBase); err != nil {
 			return err
 		}
 	}
@@ -139,21 +146,39 @@ func (h GoBuild) Run(pcfg *common.Config, rcfg *common.RunConfig) error {
 	cfg.GoRoot = filepath.Join(rcfg.BinDir, "goroot") // see Build, above.
 
 	benchmarks := goBuildBenchmarks(rcfg.Short)
+	if rcfg.Smoke {
+		// Building one package shows the toolchain works.
+		benchmarks = benchmarks[:1]
+	}
 	for _, bench := range benchmarks {
-		cmd := exec.Command(
-			filepath.Join(rcfg.BinDir, "go-build-bench"),
-			append(rcfg.Args, []string{
+		// Builds take GOMAXPROCS as their default parallelism, so a
+		// GOMAXPROCS sweep doubles as a sweep of the parallelism of the
+		// build, which is also set explicitly with -p, e.g. to see how
+		// build times scale.
+		for _, v := range execVariants(rcfg) {
+			args := append(rcfg.Args[:len(rcfg.Args):len(rcfg.Args)],
 				"-go", cfg.GoTool().Tool,
 				"-tmp", rcfg.TmpDir,
-				filepath.Join(rcfg.BinDir, bench.name, bench.pkg),
-			}...)...,
-		)
-		cmd.Env = cfg.ExecEnv.Collapse()
-		cmd.Stdout = rcfg.Results
-		cmd.Stderr = rcfg.Results
-		log.TraceCommand(cmd, false)
-		if err := cmd.Run(); err != nil {
-			return err
+			)
+			if v.tag != "" {
+				args = append(args, "-name-suffix", v.tag)
+			}
+			for _, kv := range v.env {
+				if procs, ok := strings.CutPrefix(kv, "GOMAXPROCS="); ok {
+					args = append(args, "-p", procs)
+				}
+			}
+			args = append(args, filepath.Join(rcfg.BinDir, bench.name, bench.pkg))
+			cmd := common.CommandContext(rcfg.Context(), filepath.Join(rcfg.BinDir, "go-build-bench"), args...)
+			cmd.Env = cfg.ExecEnv.MustSet(v.env...).Collapse()
+			setResultsOutput(cmd, cfg, rcfg)
+			log.TraceCommand(cmd, false)
+			if dryRun(cfg, cmd) {
+				continue
+			}
+			if err := runHooked(cfg, rcfg, bench.name+v.tag, cmd.Run); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,69 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// GRPC implements the Harness interface.
+type GRPC struct{}
+
+func init() {
+	Register("grpc", GRPC{})
+}
+
+func (h GRPC) CheckPrerequisites() error {
+	return nil
+}
+
+func (h GRPC) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
+	return retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitShallowClone(
+			gcfg.SrcDir,
+			"https://github.com/grpc/grpc-go",
+			"v1.64.0",
+			gcfg.MirrorBase,
+		)
+	})
+}
+
+func (h GRPC) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// Build the driver, which is both the client and the server, against
+	// the checkout in SrcDir. That checkout may need newer dependencies
+	// than the module's go.mod lists, so let the build update the copy of
+	// go.mod it uses.
+	modFile, err := replaceModule(cfg, bcfg, "google.golang.org/grpc", bcfg.SrcDir)
+	if err != nil {
+		return err
+	}
+	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "grpc-bench"), "-modfile="+modFile, "-mod=mod")
+}
+
+func (h GRPC) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	// Note that rcfg.Args may carry -payload and -concurrency flags for
+	// the driver, passed through with -bench-args.
+	args := append(rcfg.Args, "-tmp", rcfg.TmpDir)
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	cmd := exec.Command(filepath.Join(rcfg.BinDir, "grpc-bench"), args...)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	return runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+}
@@ -7,7 +7,6 @@ package harnesses
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 
@@ -17,6 +16,10 @@ import (
 
 type GVisor struct{}
 
+func init() {
+	Register("gvisor", GVisor{})
+}
+
 func (h GVisor) CheckPrerequisites() error {
 	if runtime.GOOS != "linux" {
 		return fmt.Errorf("requires Linux")
@@ -30,6 +33,7 @@ func (h GVisor) Get(gcfg *common.GetConfig) error {
 		"https://github.com/google/gvisor",
 		"go",
 		"b75aeea", // release-20240513.0-37-g4f08fc481
+		gcfg.MirrorBase,
 	)
 }
 
@@ -65,13 +69,15 @@ func (h GVisor) Run(cfg *common.Config, rcfg *common.RunConfig) error {
 	if rcfg.Short {
 		args = append(args, "-short")
 	}
-	cmd := exec.Command(
+	cmd := common.CommandContext(rcfg.Context(),
 		filepath.Join(rcfg.BinDir, "gvisor-bench"),
 		args...,
 	)
 	cmd.Env = cfg.ExecEnv.Collapse()
-	cmd.Stdout = rcfg.Results
-	cmd.Stderr = rcfg.Results
+	setResultsOutput(cmd, cfg, rcfg)
 	log.TraceCommand(cmd, false)
-	return cmd.Run()
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	return runHooked(cfg, rcfg, "", cmd.Run)
 }
@@ -0,0 +1,183 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/benchmarks/sweet/common/log"
+
+	"github.com/google/pprof/profile"
+)
+
+// heapDiffTop is the number of allocation sites listed in a heap diff
+// summary.
+const heapDiffTop = 20
+
+// writeHeapDiff reads pairs of heap profiles from dir, named
+// <name>.heap-start.pprof and <name>.heap-end.pprof, and writes the
+// difference between the end and start profiles, summed over all pairs,
+// to out+".pprof", along with a summary of the sites whose in-use memory
+// grew the most to out+".txt". title heads the summary.
+func writeHeapDiff(dir, out, title string) error {
+	diff, n, err := diffProfiles(dir, "heap")
+	if err != nil {
+		return err
+	}
+
+	log.CommandPrintf("go tool pprof -proto -base %s/*.heap-start.pprof %s/*.heap-end.pprof > %s.pprof", dir, dir, out)
+	f, err := os.Create(out + ".pprof")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := diff.Write(f); err != nil {
+		return err
+	}
+
+	log.CommandPrintf("go tool pprof -top -sample_index=inuse_space %s.pprof > %s.txt", out, out)
+	s, err := os.Create(out + ".txt")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return summarizeHeapDiff(s, diff, title, n)
+}
+
+// writeContentionProfile reads pairs of profiles of kind, "mutex" or
+// "block", from dir, named <name>.<kind>-start.pprof and
+// <name>.<kind>-end.pprof, and writes the contention between the start
+// and the end, summed over all pairs, to out.
+func writeContentionProfile(dir, kind, out string) error {
+	diff, _, err := diffProfiles(dir, kind)
+	if err != nil {
+		return err
+	}
+	log.CommandPrintf("go tool pprof -proto -base %s/*.%s-start.pprof %s/*.%s-end.pprof > %s", dir, kind, dir, kind, out)
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return diff.Write(f)
+}
+
+// diffProfiles reads pairs of profiles of kind from dir, named
+// <name>.<kind>-start.pprof and <name>.<kind>-end.pprof, and returns the
+// difference between the end and start profiles, summed over all pairs,
+// along with the number of pairs.
+func diffProfiles(dir, kind string) (*profile.Profile, int, error) {
+	starts, err := filepath.Glob(filepath.Join(dir, "*."+kind+"-start.pprof"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(starts) == 0 {
+		return nil, 0, fmt.Errorf("no %s profiles found in %q", kind, dir)
+	}
+	var profiles []*profile.Profile
+	for _, start := range starts {
+		end := strings.TrimSuffix(start, "."+kind+"-start.pprof") + "." + kind + "-end.pprof"
+		ps, err := readProfile(start)
+		if err != nil {
+			return nil, 0, err
+		}
+		pe, err := readProfile(end)
+		if err != nil {
+			return nil, 0, err
+		}
+		ps.Scale(-1)
+		profiles = append(profiles, ps, pe)
+	}
+	diff, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error diffing %s profiles: %w", kind, err)
+	}
+	return diff, len(starts), nil
+}
+
+func readProfile(path string) (*profile.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading profile %q: %w", path, err)
+	}
+	return p, nil
+}
+
+// summarizeHeapDiff writes a summary of the change in in-use memory in
+// diff, a difference of heap profiles, by the function that allocated it.
+func summarizeHeapDiff(w io.Writer, diff *profile.Profile, title string, processes int) error {
+	idx := -1
+	for i, st := range diff.SampleType {
+		if st.Type == "inuse_space" {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("heap profile has no inuse_space samples")
+	}
+	var total int64
+	byFunc := make(map[string]int64)
+	for _, s := range diff.Sample {
+		v := s.Value[idx]
+		total += v
+		fn := "<unknown>"
+		if len(s.Location) != 0 && len(s.Location[0].Line) != 0 && s.Location[0].Line[0].Function != nil {
+			fn = s.Location[0].Line[0].Function.Name
+		}
+		byFunc[fn] += v
+	}
+	funcs := make([]string, 0, len(byFunc))
+	for fn, v := range byFunc {
+		if v != 0 {
+			funcs = append(funcs, fn)
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if byFunc[funcs[i]] != byFunc[funcs[j]] {
+			return byFunc[funcs[i]] > byFunc[funcs[j]]
+		}
+		return funcs[i] < funcs[j]
+	})
+	if len(funcs) > heapDiffTop {
+		funcs = funcs[:heapDiffTop]
+	}
+
+	fmt.Fprintf(w, "# Change in in-use heap memory for %s, from the start to the end of the benchmark.\n", title)
+	fmt.Fprintf(w, "# Processes: %d\n", processes)
+	fmt.Fprintf(w, "# Total: %s\n", formatByteDelta(total))
+	fmt.Fprintf(w, "# Top %d allocation sites by growth:\n", len(funcs))
+	for _, fn := range funcs {
+		fmt.Fprintf(w, "%12s  %s\n", formatByteDelta(byFunc[fn]), fn)
+	}
+	return nil
+}
+
+// formatByteDelta formats a change of n bytes, e.g. "+1.5MiB".
+func formatByteDelta(n int64) string {
+	sign := "+"
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%s%dB", sign, n)
+	}
+	v, exp := float64(n)/unit, 0
+	for v >= unit && exp < 3 {
+		v /= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f%ciB", sign, v, "KMGT"[exp])
+}
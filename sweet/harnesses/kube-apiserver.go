@@ -0,0 +1,109 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// KubeAPIServer implements the Harness interface.
+type KubeAPIServer struct{}
+
+func init() {
+	Register("kube-apiserver", KubeAPIServer{})
+}
+
+func (h KubeAPIServer) CheckPrerequisites() error {
+	if runtime.GOARCH != "arm64" && runtime.GOARCH != "amd64" {
+		return fmt.Errorf("requires amd64 or arm64")
+	}
+	return nil
+}
+
+func (h KubeAPIServer) Get(gcfg *common.GetConfig) error {
+	kubeDir := filepath.Join(gcfg.SrcDir, "kubernetes")
+	etcdDir := filepath.Join(gcfg.SrcDir, "etcd")
+	if gcfg.LocalSrc != "" {
+		// A local checkout only replaces kubernetes itself; etcd is
+		// always fetched.
+		if err := linkLocalSrc(kubeDir, gcfg.LocalSrc); err != nil {
+			return err
+		}
+	} else {
+		// The repository is very large, so only fetch the one commit we
+		// need, and retry on flaky networks.
+		if err := retryClone(kubeDir, gcfg.Retries, func() error {
+			return gitShallowClone(kubeDir, "https://github.com/kubernetes/kubernetes", "v1.30.2", gcfg.MirrorBase)
+		}); err != nil {
+			return err
+		}
+	}
+	// Use an etcd from the release line this version of kubernetes is
+	// tested against.
+	return retryClone(etcdDir, gcfg.Retries, func() error {
+		return gitShallowClone(etcdDir, "https://github.com/etcd-io/etcd", "v3.5.13", gcfg.MirrorBase)
+	})
+}
+
+func (h KubeAPIServer) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// kube-apiserver is a very large build. If caching is enabled, share Go's
+	// build cache across builds. Unlike caching binaries, this is always
+	// safe: the build cache is keyed by the toolchain and all build inputs,
+	// so a different toolchain or configuration simply misses.
+	goTool := cfg.GoTool()
+	if bcfg.CacheDir != "" {
+		cacheDir := filepath.Join(bcfg.CacheDir, "kube-apiserver", "gocache")
+		if err := mkdirAll(cacheDir); err != nil {
+			return err
+		}
+		goTool.Env = goTool.Env.MustSet("GOCACHE=" + cacheDir)
+	}
+	serverPkg := filepath.Join(bcfg.SrcDir, "kubernetes", "cmd", "kube-apiserver")
+	if err := goTool.BuildPath(serverPkg, filepath.Join(bcfg.BinDir, "kube-apiserver")); err != nil {
+		return fmt.Errorf("error building kube-apiserver: %v", err)
+	}
+	etcdPkg := filepath.Join(bcfg.SrcDir, "etcd", "server")
+	if err := goTool.BuildPath(etcdPkg, filepath.Join(bcfg.BinDir, "etcd")); err != nil {
+		return fmt.Errorf("error building etcd: %v", err)
+	}
+	// Build the benchmark wrapper.
+	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "kube-apiserver-bench"))
+}
+
+func (h KubeAPIServer) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	args := append(rcfg.Args, []string{
+		"-apiserver-bin", filepath.Join(rcfg.BinDir, "kube-apiserver"),
+		"-etcd-bin", filepath.Join(rcfg.BinDir, "etcd"),
+		"-tmp", rcfg.TmpDir,
+	}...)
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	cmd := exec.Command(
+		filepath.Join(rcfg.BinDir, "kube-apiserver-bench"),
+		args...,
+	)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	err := runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+	if err != nil {
+		return err
+	}
+	// Delete tmp because etcd and kube-apiserver will have written their
+	// data, keys, and certificates there.
+	return cleanTmpDir(rcfg)
+}
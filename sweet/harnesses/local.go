@@ -5,18 +5,32 @@
 package harnesses
 
 import (
-	"os/exec"
 	"path/filepath"
 
 	"golang.org/x/benchmarks/sweet/common"
 	"golang.org/x/benchmarks/sweet/common/log"
 )
 
+func init() {
+	Register("biogo-igor", BiogoIgor())
+	Register("biogo-krishna", BiogoKrishna())
+	Register("bleve-index", BleveIndex())
+	Register("gopher-lua", GopherLua())
+	Register("markdown", Markdown())
+}
+
 type localBenchHarness struct {
 	binName   string
 	genArgs   func(cfg *common.Config, rcfg *common.RunConfig) []string
 	beforeRun func(cfg *common.Config, rcfg *common.RunConfig) error
 	noStdout  bool
+
+	// benchmarks, if non-empty, are the names of the benchmarks the
+	// binary can run. Each one selected by RunConfig.BenchFilter runs in
+	// its own invocation of the binary, which is passed -bench and the
+	// arguments from benchArgs instead of genArgs.
+	benchmarks []string
+	benchArgs  func(cfg *common.Config, rcfg *common.RunConfig, bench string) []string
 }
 
 func (h *localBenchHarness) CheckPrerequisites() error {
@@ -28,7 +42,13 @@ func (h *localBenchHarness) Get(_ *common.GetConfig) error {
 }
 
 func (h *localBenchHarness) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
-	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, h.binName))
+	ctx, cancel := bcfg.BuildContext()
+	defer cancel()
+	return cfg.GoTool().BuildPathContext(ctx, bcfg.BenchDir, filepath.Join(bcfg.BinDir, h.binName))
+}
+
+func (h *localBenchHarness) Benchmarks() []string {
+	return h.benchmarks
 }
 
 func (h *localBenchHarness) Run(cfg *common.Config, rcfg *common.RunConfig) error {
@@ -37,17 +57,41 @@ func (h *localBenchHarness) Run(cfg *common.Config, rcfg *common.RunConfig) erro
 			return err
 		}
 	}
-	cmd := exec.Command(
+	if len(h.benchmarks) == 0 {
+		return h.run(cfg, rcfg, "", h.genArgs(cfg, rcfg))
+	}
+	benchmarks, err := filterBenchmarks(h.benchmarks, rcfg.BenchFilter)
+	if err != nil {
+		return err
+	}
+	benchmarks = smokeBenchmarks(benchmarks, rcfg)
+	for _, bench := range benchmarks {
+		args := append([]string{"-bench", bench}, h.benchArgs(cfg, rcfg, bench)...)
+		if err := h.run(cfg, rcfg, bench, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs the benchmark binary once with args, after rcfg.Args, to run
+// the benchmark named bench, or all of them if bench is empty.
+func (h *localBenchHarness) run(cfg *common.Config, rcfg *common.RunConfig, bench string, args []string) error {
+	args = append(runtimeMetricsArgs(rcfg), args...)
+	cmd := common.CommandContext(rcfg.Context(),
 		filepath.Join(rcfg.BinDir, h.binName),
-		append(rcfg.Args, h.genArgs(cfg, rcfg)...)...,
+		append(rcfg.Args[:len(rcfg.Args):len(rcfg.Args)], args...)...,
 	)
 	cmd.Env = cfg.ExecEnv.Collapse()
-	if !h.noStdout {
-		cmd.Stdout = rcfg.Results
+	setResultsOutput(cmd, cfg, rcfg)
+	if h.noStdout {
+		cmd.Stdout = nil
 	}
-	cmd.Stderr = rcfg.Results
 	log.TraceCommand(cmd, false)
-	return cmd.Run()
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	return runHooked(cfg, rcfg, bench, cmd.Run)
 }
 
 func BiogoIgor() common.Harness {
@@ -98,14 +142,18 @@ func BleveIndex() common.Harness {
 
 func GopherLua() common.Harness {
 	return &localBenchHarness{
-		binName: "gopher-lua-bench",
-		genArgs: func(cfg *common.Config, rcfg *common.RunConfig) []string {
-			args := []string{
-				filepath.Join(rcfg.AssetsDir, "k-nucleotide.lua"),
-				filepath.Join(rcfg.AssetsDir, "input.txt"),
-			}
+		binName:    "gopher-lua-bench",
+		benchmarks: []string{"knucleotide", "gc-stress"},
+		benchArgs: func(cfg *common.Config, rcfg *common.RunConfig, bench string) []string {
+			var args []string
 			if rcfg.Short {
-				args = append([]string{"-short"}, args...)
+				args = append(args, "-short")
+			}
+			if bench == "knucleotide" {
+				args = append(args,
+					filepath.Join(rcfg.AssetsDir, "k-nucleotide.lua"),
+					filepath.Join(rcfg.AssetsDir, "input.txt"),
+				)
 			}
 			return args
 		},
@@ -114,11 +162,14 @@ func GopherLua() common.Harness {
 
 func Markdown() common.Harness {
 	return &localBenchHarness{
-		binName: "markdown-bench",
-		genArgs: func(cfg *common.Config, rcfg *common.RunConfig) []string {
-			return []string{
-				rcfg.AssetsDir,
+		binName:    "markdown-bench",
+		benchmarks: []string{"render", "render-large"},
+		benchArgs: func(cfg *common.Config, rcfg *common.RunConfig, bench string) []string {
+			var args []string
+			if rcfg.Short {
+				args = append(args, "-short")
 			}
+			return append(args, rcfg.AssetsDir)
 		},
 	}
 }
@@ -0,0 +1,76 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// NATS implements the Harness interface.
+type NATS struct{}
+
+func init() {
+	Register("nats", NATS{})
+}
+
+func (h NATS) CheckPrerequisites() error {
+	return nil
+}
+
+func (h NATS) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
+	return retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitShallowClone(
+			gcfg.SrcDir,
+			"https://github.com/nats-io/nats-server",
+			"v2.10.18",
+			gcfg.MirrorBase,
+		)
+	})
+}
+
+func (h NATS) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// The server's main package lives at the root of the repository.
+	if err := cfg.GoTool().BuildPath(bcfg.SrcDir, filepath.Join(bcfg.BinDir, "nats-server")); err != nil {
+		return err
+	}
+	// Build the benchmark wrapper, which doubles as the load generator.
+	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "nats-bench"))
+}
+
+func (h NATS) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	args := append(rcfg.Args, []string{
+		"-nats-bin", filepath.Join(rcfg.BinDir, "nats-server"),
+		"-tmp", rcfg.TmpDir,
+	}...)
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	cmd := exec.Command(
+		filepath.Join(rcfg.BinDir, "nats-bench"),
+		args...,
+	)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	err := runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+	if err != nil {
+		return err
+	}
+	// Delete tmp because the server will have written its pid and log
+	// files there.
+	return cleanTmpDir(rcfg)
+}
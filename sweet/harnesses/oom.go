@@ -0,0 +1,145 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+// oomWatch records the number of OOM kills so far in sweet's memory
+// cgroup, for oomCheck to tell whether a benchmark was OOM-killed. Since
+// benchmarks run in the same cgroup, the count also covers any processes
+// a benchmark starts, like the servers of a CockroachDB cluster.
+type oomWatch struct {
+	// events is the cgroup file the count was read from, or empty if it
+	// couldn't be read.
+	events string
+	kills  uint64
+}
+
+// watchOOM starts watching for OOM kills. It's only effective on Linux.
+func watchOOM() *oomWatch {
+	return watchOOMIn(memoryEventsFile())
+}
+
+// watchOOMIn is like watchOOM, but counts OOM kills in the cgroup file
+// events, e.g. the memory.events of a cgroup the benchmark runs in
+// rather than sweet's own.
+func watchOOMIn(events string) *oomWatch {
+	if events == "" {
+		return &oomWatch{}
+	}
+	kills, err := readOOMKills(events)
+	if err != nil {
+		return &oomWatch{}
+	}
+	return &oomWatch{events: events, kills: kills}
+}
+
+// check returns an error wrapping common.ErrOOMKilled if err, the
+// result of waiting for cmd, was caused by the OOM killer. If the cause
+// can't be determined, it returns err as is.
+//
+// A kill is attributed to the OOM killer if the cgroup's OOM kill count
+// went up while cmd ran, or if cmd was killed with SIGKILL and the kernel
+// log records it being killed for lack of memory.
+func (w *oomWatch) check(cmd *exec.Cmd, err error) error {
+	if err == nil {
+		return nil
+	}
+	name := filepath.Base(cmd.Path)
+	if w.events != "" {
+		if kills, rerr := readOOMKills(w.events); rerr == nil && kills > w.kills {
+			return fmt.Errorf("%s: %w: %d process(es) killed for lack of memory (%s): %v", name, common.ErrOOMKilled, kills-w.kills, w.events, err)
+		}
+	}
+	if cmd.ProcessState == nil {
+		return err
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() || ws.Signal() != syscall.SIGKILL {
+		return err
+	}
+	if killedInKernelLog(cmd.ProcessState.Pid()) {
+		return fmt.Errorf("%s: %w: process %d killed for lack of memory (dmesg): %v", name, common.ErrOOMKilled, cmd.ProcessState.Pid(), err)
+	}
+	return err
+}
+
+// memoryEventsFile returns the path of the file counting OOM kills in
+// sweet's memory cgroup, memory.events for cgroup v2 and
+// memory.oom_control for v1, or the empty string if there's none.
+func memoryEventsFile() string {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var v1, v2 string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Each line is hierarchy-ID:controllers:path, where v2's unified
+		// hierarchy has ID 0 and no controllers.
+		fields := strings.SplitN(s.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			v2 = filepath.Join("/sys/fs/cgroup", fields[2], "memory.events")
+		case fields[1] == "memory":
+			v1 = filepath.Join("/sys/fs/cgroup/memory", fields[2], "memory.oom_control")
+		}
+	}
+	for _, path := range []string{v2, v1} {
+		if path == "" {
+			continue
+		}
+		if _, err := readOOMKills(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readOOMKills returns the oom_kill count from the cgroup file path.
+func readOOMKills(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if ok && key == "oom_kill" {
+			return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return 0, errors.New("no oom_kill count in " + path)
+}
+
+// killedInKernelLog reports whether the kernel log records the OOM killer
+// killing pid. Reading the log often requires privileges, in which case
+// it reports false.
+func killedInKernelLog(pid int) bool {
+	out, err := exec.Command("dmesg").Output()
+	if err != nil {
+		return false
+	}
+	// e.g. "Out of memory: Killed process 1234 (cockroach) total-vm:...",
+	// or "Memory cgroup out of memory: Killed process 1234 ...".
+	re := regexp.MustCompile(`(?i)out of memory: Killed process ` + strconv.Itoa(pid) + `\b`)
+	return re.Match(out)
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// perfStatEvents are the hardware events counted for RunConfig.PerfStat,
+// in the order they're reported.
+var perfStatEvents = []string{"cycles", "instructions", "cache-misses", "branch-misses"}
+
+// perfStat arranges for cmd to run under perf stat if rcfg.PerfStat is
+// set. It returns a function to call once cmd has exited, which reports
+// the counters as metrics of a result named name, e.g.
+// "CockroachDBkv0/nodes=1", written to cmd's output. The counters cover
+// the whole of cmd's execution, including any setup it does.
+//
+// It must be called after cmd's output is set up. If perf isn't
+// available or can't count events, e.g. for lack of permissions, perfStat
+// writes a warning to cmd's output and leaves cmd to run as is.
+func perfStat(cmd *exec.Cmd, rcfg *common.RunConfig, name string) (report func()) {
+	report = func() {}
+	if !rcfg.PerfStat {
+		return
+	}
+	w := cmd.Stdout
+	if w == nil {
+		w = io.Discard
+	}
+	warn := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Warnf("%s", msg)
+		fmt.Fprintf(w, "# warning: %s\n", msg)
+	}
+	perf, err := exec.LookPath("perf")
+	if err != nil {
+		warn("perf not found, not collecting counters for %s: %v", name, err)
+		return
+	}
+	// Check that perf can count events at all before relying on it, since
+	// it refuses to run anything if, say, perf_event_paranoid is too high.
+	events := strings.Join(perfStatEvents, ",")
+	probe := exec.Command(perf, "stat", "-x", ",", "-e", events, "-o", os.DevNull, "--", "true")
+	log.TraceCommand(probe, false)
+	if out, err := probe.CombinedOutput(); err != nil {
+		warn("perf stat failed, not collecting counters for %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+		return
+	}
+
+	statFile := filepath.Join(rcfg.TmpDir, "perf-stat.csv")
+	cmd.Args = append([]string{perf, "stat", "-x", ",", "-e", events, "-o", statFile, "--", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = perf
+	return func() {
+		data, err := os.ReadFile(statFile)
+		if err != nil {
+			warn("failed to read perf stat output for %s: %v", name, err)
+			return
+		}
+		counts := parsePerfStat(data)
+		if len(counts) == 0 {
+			warn("perf stat counted no events for %s", name)
+			return
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Benchmark%s 1", name)
+		for _, event := range perfStatEvents {
+			if v, ok := counts[event]; ok {
+				fmt.Fprintf(&sb, " %d %s", v, event)
+			}
+		}
+		fmt.Fprintln(w, sb.String())
+	}
+}
+
+// parsePerfStat parses the CSV output of perf stat -x, returning the
+// count for each event that was counted.
+func parsePerfStat(data []byte) map[string]uint64 {
+	counts := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Each line is value,unit,event,... where value may be e.g.
+		// "<not supported>".
+		f := strings.Split(line, ",")
+		if len(f) < 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Strip any modifiers, like the ":u" perf adds when it can only
+		// count user space events.
+		event, _, _ := strings.Cut(f[2], ":")
+		counts[event] = v
+	}
+	return counts
+}
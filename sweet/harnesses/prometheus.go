@@ -0,0 +1,90 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/benchmarks/sweet/common"
+	"golang.org/x/benchmarks/sweet/common/log"
+)
+
+// Prometheus implements the Harness interface.
+type Prometheus struct{}
+
+func init() {
+	Register("prometheus", Prometheus{})
+}
+
+func (h Prometheus) CheckPrerequisites() error {
+	if runtime.GOARCH != "arm64" && runtime.GOARCH != "amd64" {
+		return fmt.Errorf("requires amd64 or arm64")
+	}
+	return nil
+}
+
+func (h Prometheus) Get(gcfg *common.GetConfig) error {
+	if gcfg.LocalSrc != "" {
+		return linkLocalSrc(gcfg.SrcDir, gcfg.LocalSrc)
+	}
+	// Build against the v2.45.0 release, which is an LTS release.
+	if err := retryClone(gcfg.SrcDir, gcfg.Retries, func() error {
+		return gitRecursiveCloneToCommit(
+			gcfg.SrcDir,
+			"https://github.com/prometheus/prometheus",
+			"release-2.45",
+			"8ef767e396bf8445f009f945b0162fd71827f445",
+			gcfg.MirrorBase,
+			gcfg.Shallow,
+			gcfg.Stats,
+		)
+	}); err != nil {
+		return err
+	}
+	return verifyClone(gcfg.SrcDir, gcfg.ExpectedTreeHash)
+}
+
+func (h Prometheus) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
+	// Build the server directly rather than through the Makefile, which
+	// also wants to build the web UI with npm. The benchmark doesn't
+	// need the UI.
+	serverPkg := filepath.Join(bcfg.SrcDir, "cmd", "prometheus")
+	if err := cfg.GoTool().BuildPath(serverPkg, filepath.Join(bcfg.BinDir, "prometheus")); err != nil {
+		return err
+	}
+	// Build the benchmark wrapper.
+	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "prometheus-bench"))
+}
+
+func (h Prometheus) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	args := append(rcfg.Args, []string{
+		"-prometheus-bin", filepath.Join(rcfg.BinDir, "prometheus"),
+		"-tmp", rcfg.TmpDir,
+	}...)
+	if rcfg.Short {
+		args = append(args, "-short")
+	}
+	cmd := exec.Command(
+		filepath.Join(rcfg.BinDir, "prometheus-bench"),
+		args...,
+	)
+	cmd.Env = cfg.ExecEnv.Collapse()
+	setResultsOutput(cmd, cfg, rcfg)
+	log.TraceCommand(cmd, false)
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	err := runHooked(cfg, rcfg, "", func() error {
+		return runInCgroup(rcfg, cmd, rcfg.Results)
+	})
+	if err != nil {
+		return err
+	}
+	// Delete tmp because Prometheus will have written its TSDB there.
+	return cleanTmpDir(rcfg)
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]common.Harness)
+)
+
+// Register makes the harness h available to sweet as the benchmark name.
+// It's meant to be called from the init function of the package that
+// implements h, so that importing the package, even with a blank import,
+// adds the benchmark to a sweet binary. The built-in harnesses register
+// themselves this way too. See the README for how to build sweet with a
+// harness from outside this repository.
+//
+// If h has a Description() string method, sweet describes the benchmark
+// with it, e.g. in "sweet list".
+//
+// Register panics if h is nil or if name is already registered.
+func Register(name string, h common.Harness) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if h == nil {
+		panic(fmt.Sprintf("harnesses: Register of %s with a nil harness", name))
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("harnesses: Register called twice for %s", name))
+	}
+	registry[name] = h
+}
+
+// Lookup returns the harness registered as name, or nil if there's none.
+func Lookup(name string) common.Harness {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return registry[name]
+}
+
+// Names returns the names of the registered harnesses, sorted.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package harnesses
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/benchmarks/sweet/common"
+)
+
+// checkRemote returns an error if rcfg asks to run on a remote host along
+// with options that rely on reading files the benchmark writes, which
+// would be left on the host.
+func checkRemote(rcfg *common.RunConfig) error {
+	if rcfg.Remote == nil {
+		return nil
+	}
+	switch {
+	case rcfg.ProfileDir != "":
+		return fmt.Errorf("profiles are not supported when running remotely")
+	case rcfg.TraceDir != "":
+		return fmt.Errorf("traces are not supported when running remotely")
+	case rcfg.FailureDir != "":
+		return fmt.Errorf("preserving logs of failed benchmarks is not supported when running remotely")
+	case rcfg.KeepTmp:
+		return fmt.Errorf("keeping tmp directories is not supported when running remotely")
+	case rcfg.PGOProfile != "":
+		return fmt.Errorf("generating PGO profiles is not supported when running remotely")
+	case rcfg.PerfStat:
+		return fmt.Errorf("perf stat is not supported when running remotely")
+	case rcfg.Container != nil:
+		return fmt.Errorf("containers are not supported when running remotely")
+	}
+	return nil
+}
+
+// runRemotely arranges for cmd to run on rcfg.Remote's host, if any,
+// streaming its output back. The remote command gets cmd's directory
+// and the parts of its environment set for the benchmark; ssh gets the
+// rest, so that it can find, say, an SSH agent.
+func runRemotely(cmd *exec.Cmd, rcfg *common.RunConfig) {
+	if rcfg.Remote == nil {
+		return
+	}
+	r := rcfg.Remote.Command(cmd.Dir, cmd.Env, cmd.Path, cmd.Args[1:]...)
+	cmd.Path = r.Path
+	cmd.Args = r.Args
+	cmd.Dir = ""
+}
+
+// cleanTmpDir removes everything in rcfg.TmpDir, on rcfg.Remote's host if
+// the benchmark runs there, or moves it aside with rcfg.SaveTmp if
+// rcfg.KeepTmp is set.
+func cleanTmpDir(rcfg *common.RunConfig) error {
+	if rcfg.Remote != nil {
+		return rcfg.Remote.RmDirContents(rcfg.TmpDir)
+	}
+	if rcfg.KeepTmp {
+		return rcfg.SaveTmp()
+	}
+	return rmDirContents(rcfg.TmpDir)
+}
@@ -7,6 +7,7 @@ package harnesses
 import (
 	"os/exec"
 	"path/filepath"
+	"strconv"
 
 	"golang.org/x/benchmarks/sweet/common"
 	"golang.org/x/benchmarks/sweet/common/log"
@@ -18,6 +19,10 @@ const (
 
 type Tile38 struct{}
 
+func init() {
+	Register("tile38", Tile38{})
+}
+
 func (h Tile38) CheckPrerequisites() error {
 	return nil
 }
@@ -27,6 +32,7 @@ func (h Tile38) Get(gcfg *common.GetConfig) error {
 		gcfg.SrcDir,
 		"https://github.com/tidwall/tile38",
 		"1.29.1",
+		gcfg.MirrorBase,
 	)
 }
 
@@ -37,28 +43,52 @@ func (h Tile38) Build(cfg *common.Config, bcfg *common.BuildConfig) error {
 	// visibility into how tile38 is built to allow us to pass this information
 	// directly. Also set the GOROOT explicitly because it might have propagated
 	// differently from the environment.
-	env = env.Prefix("PATH", filepath.Join(cfg.GoRoot, "bin")+":")
+	env = env.PrependPath("PATH", filepath.Join(cfg.GoRoot, "bin"))
 	env = env.MustSet("GOROOT=" + cfg.GoRoot)
 
 	cmd := exec.Command("make", "-C", bcfg.SrcDir)
 	cmd.Env = env.Collapse()
 	log.TraceCommand(cmd, false)
-	// Call Output here to get an *ExitError with a populated Stderr field.
-	if _, err := cmd.Output(); err != nil {
-		return err
+	if !dryRun(cfg, cmd) {
+		if err := common.RunCommand(cmd); err != nil {
+			return err
+		}
 	}
 	// Note that no matter what we do, the build script insists on putting the
 	// binaries into the source directory, so copy the one we care about into
 	// BinDir.
-	if err := copyFile(filepath.Join(bcfg.BinDir, server), filepath.Join(bcfg.SrcDir, server)); err != nil {
+	if err := copyBuiltFile(cfg, filepath.Join(bcfg.BinDir, server), filepath.Join(bcfg.SrcDir, server)); err != nil {
 		return err
 	}
 	return cfg.GoTool().BuildPath(bcfg.BenchDir, filepath.Join(bcfg.BinDir, "tile38-bench"))
 }
 
+func (h Tile38) Benchmarks() []string {
+	return []string{"query-load", "preloaded-query"}
+}
+
 func (h Tile38) Run(cfg *common.Config, rcfg *common.RunConfig) error {
+	benchmarks, err := filterBenchmarks(h.Benchmarks(), rcfg.BenchFilter)
+	if err != nil {
+		return err
+	}
+	benchmarks = smokeBenchmarks(benchmarks, rcfg)
+	for _, bench := range benchmarks {
+		if err := h.runBenchmark(cfg, rcfg, bench); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBenchmark runs the tile38 benchmark bench. The preloaded-query
+// benchmark loads its own data into an empty server, while query-load
+// queries the data in the assets.
+func (h Tile38) runBenchmark(cfg *common.Config, rcfg *common.RunConfig, bench string) error {
 	var dataPath string
-	if rcfg.Short {
+	if bench == "preloaded-query" {
+		dataPath = filepath.Join(rcfg.TmpDir, "data-preload")
+	} else if rcfg.Short {
 		// Don't load the real data for short mode. It takes a long time.
 		dataPath = filepath.Join(rcfg.TmpDir, "data-empty-fake")
 	} else {
@@ -69,7 +99,8 @@ func (h Tile38) Run(cfg *common.Config, rcfg *common.RunConfig) error {
 			return err
 		}
 	}
-	args := append(rcfg.Args, []string{
+	args := append(rcfg.Args[:len(rcfg.Args):len(rcfg.Args)], []string{
+		"-bench", bench,
 		"-host", "127.0.0.1",
 		"-port", "9851",
 		"-server", filepath.Join(rcfg.BinDir, server),
@@ -79,13 +110,18 @@ func (h Tile38) Run(cfg *common.Config, rcfg *common.RunConfig) error {
 	if rcfg.Short {
 		args = append(args, "-short")
 	}
-	cmd := exec.Command(
+	if rcfg.Seed != 0 {
+		args = append(args, "-seed", strconv.FormatInt(rcfg.Seed, 10))
+	}
+	cmd := common.CommandContext(rcfg.Context(),
 		filepath.Join(rcfg.BinDir, "tile38-bench"),
 		args...,
 	)
 	cmd.Env = cfg.ExecEnv.Collapse()
-	cmd.Stdout = rcfg.Results
-	cmd.Stderr = rcfg.Results
+	setResultsOutput(cmd, cfg, rcfg)
 	log.TraceCommand(cmd, false)
-	return cmd.Run()
+	if dryRun(cfg, cmd) {
+		return nil
+	}
+	return runHooked(cfg, rcfg, bench, cmd.Run)
 }